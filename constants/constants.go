@@ -119,6 +119,14 @@ var (
 	KafkaBrokers       = config.Config("KAFKA_BROKERS")
 	KafkaUser          = config.Config("KAFKA_USER")
 	KafkaPass          = config.Config("KAFKA_PASS")
+	// KAFKA_SASL_ENABLE defaults to true when KAFKA_USER is set
+	KafkaSASLEnable    = config.Config("KAFKA_SASL_ENABLE")
+	KafkaSASLMechanism = config.Config("KAFKA_SASL_MECHANISM")
+	KafkaTLSEnable     = config.Config("KAFKA_TLS_ENABLE")
+	KafkaTLSCAFile     = config.Config("KAFKA_TLS_CA_FILE")
+	KafkaTLSCertFile   = config.Config("KAFKA_TLS_CERT_FILE")
+	KafkaTLSKeyFile    = config.Config("KAFKA_TLS_KEY_FILE")
+	KafkaTLSSkipVerify = config.Config("KAFKA_TLS_SKIP_VERIFY")
 
 	// SeaweedFS
 	SeaweedFSHost = config.Config("SEAWEEDFS_HOST")
@@ -0,0 +1,316 @@
+package kf
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// ErrProducerClosed is returned for messages sent after Close
+var ErrProducerClosed = errors.New("kafka producer closed")
+
+const (
+	defaultRetryBackoff    = 100 * time.Millisecond
+	defaultMaxRetryBackoff = 5 * time.Second
+	flushPollInterval      = 10 * time.Millisecond
+)
+
+// ProducerConfig tunes batching, compression, retries and idempotence of
+// the producers. Zero values keep the sarama defaults.
+type ProducerConfig struct {
+	// BatchSize and BatchBytes send a batch once that many messages or
+	// bytes are buffered; Linger sends it after that long at the latest
+	BatchSize  int
+	BatchBytes int
+	Linger     time.Duration
+	// Compression is none, gzip, snappy, lz4 or zstd
+	Compression string
+	MaxRetries  int
+	// RetryBackoff is the first retry delay; it doubles up to MaxRetryBackoff
+	RetryBackoff    time.Duration
+	MaxRetryBackoff time.Duration
+	// Idempotent avoids duplicates from producer retries (acks=all, one
+	// request in flight per broker). TransactionalID implies it and is
+	// used by TransactionalProducer only.
+	Idempotent      bool
+	TransactionalID string
+}
+
+func (p ProducerConfig) apply(sc *sarama.Config) error {
+	if p.BatchSize > 0 {
+		sc.Producer.Flush.Messages = p.BatchSize
+	}
+	if p.BatchBytes > 0 {
+		sc.Producer.Flush.Bytes = p.BatchBytes
+	}
+	if p.Linger > 0 {
+		sc.Producer.Flush.Frequency = p.Linger
+	}
+	if p.Compression != "" {
+		var codec sarama.CompressionCodec
+		if err := codec.UnmarshalText([]byte(strings.ToLower(p.Compression))); err != nil {
+			return fmt.Errorf("invalid Kafka compression: %w", err)
+		}
+		sc.Producer.Compression = codec
+	}
+	if p.MaxRetries > 0 {
+		sc.Producer.Retry.Max = p.MaxRetries
+	}
+	sc.Producer.Retry.BackoffFunc = func(retries, _ int) time.Duration {
+		return retryBackoff(retries, p.RetryBackoff, p.MaxRetryBackoff)
+	}
+	sc.Producer.Return.Successes = true
+	sc.Producer.Return.Errors = true
+	p.applyIdempotence(sc)
+	return nil
+}
+
+// retryBackoff is the delay before retry number retries (1-based),
+// doubling from initial up to max
+func retryBackoff(retries int, initial, max time.Duration) time.Duration {
+	if initial <= 0 {
+		initial = defaultRetryBackoff
+	}
+	if max <= 0 {
+		max = defaultMaxRetryBackoff
+	}
+	d := initial
+	for i := 1; i < retries && d < max; i++ {
+		d *= 2
+	}
+	return min(d, max)
+}
+
+// Delivery is the outcome of an asynchronously sent message. Like
+// Publish, a message handed to the Redis spool counts as accepted: it is
+// Spooled with a nil Err, and Cause holds the Kafka error that made it
+// spooled, if any. Err is set when the message was lost.
+type Delivery struct {
+	Topic     string
+	Key       string
+	Partition int32
+	Offset    int64
+	Err       error
+	Spooled   bool
+	Cause     error
+}
+
+// DeliveryFunc is called once the outcome of a message is known. It runs
+// on the producer's result goroutine and must not block.
+type DeliveryFunc func(Delivery)
+
+// Future resolves to the Delivery of a message
+type Future struct {
+	done     chan struct{}
+	delivery Delivery
+}
+
+func newFuture() *Future {
+	return &Future{done: make(chan struct{})}
+}
+
+func (f *Future) resolve(d Delivery) {
+	f.delivery = d
+	close(f.done)
+}
+
+// Done is closed once the delivery is known
+func (f *Future) Done() <-chan struct{} {
+	return f.done
+}
+
+// Wait blocks until the message is delivered or failed, or ctx ends
+func (f *Future) Wait(ctx context.Context) (Delivery, error) {
+	select {
+	case <-f.done:
+		return f.delivery, f.delivery.Err
+	case <-ctx.Done():
+		return Delivery{}, ctx.Err()
+	}
+}
+
+// AsyncProducer sends messages in batches without blocking the caller.
+// Messages Kafka could not take after the producer's retries are appended
+// to the spool when one is set; permanent errors are not spooled.
+type AsyncProducer struct {
+	producer sarama.AsyncProducer
+	spool    *Spool
+
+	// input is held shared by Send and exclusively by Close while it
+	// closes the producer, so no message is sent on a closed Input
+	input    sync.RWMutex
+	closed   atomic.Bool
+	done     chan struct{} // closed by Close
+	inFlight atomic.Int64
+	results  sync.WaitGroup
+}
+
+type pendingMessage struct {
+	future   *Future
+	callback DeliveryFunc
+	key      string
+	value    []byte
+}
+
+// NewAsyncProducer creates an async producer; spool may be nil
+func NewAsyncProducer(cfg *Config, spool *Spool) (*AsyncProducer, error) {
+	saramaCfg, err := newSaramaConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.Producer.apply(saramaCfg); err != nil {
+		return nil, err
+	}
+
+	producer, err := sarama.NewAsyncProducer(cfg.Brokers, saramaCfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create async Kafka producer: %w", err)
+	}
+
+	return newAsyncProducer(producer, spool), nil
+}
+
+func newAsyncProducer(producer sarama.AsyncProducer, spool *Spool) *AsyncProducer {
+	p := &AsyncProducer{producer: producer, spool: spool, done: make(chan struct{})}
+	p.results.Add(2)
+	go p.handleSuccesses()
+	go p.handleErrors()
+	return p
+}
+
+// Send queues a message. callback may be nil; the returned Future
+// resolves with the same Delivery. While the spool holds messages, as seen
+// by the last spool or replay pass, the message is spooled behind them
+// like in Publish, which makes Send wait for Redis.
+func (p *AsyncProducer) Send(topic, key string, value []byte, callback DeliveryFunc) *Future {
+	future := newFuture()
+	pending := &pendingMessage{future: future, callback: callback, key: key, value: value}
+
+	p.input.RLock()
+	defer p.input.RUnlock()
+
+	// Counted before closed is checked, so Close either sees the message
+	// in flight and waits for it or this call sees closed
+	p.inFlight.Add(1)
+	if p.closed.Load() {
+		p.inFlight.Add(-1)
+		p.finish(pending, Delivery{Topic: topic, Key: key, Err: ErrProducerClosed})
+		return future
+	}
+	if p.spool != nil && p.spool.Metrics.size.Load() > 0 {
+		p.finish(pending, p.spoolMessage(topic, pending, nil))
+		p.inFlight.Add(-1)
+		return future
+	}
+
+	select {
+	case p.producer.Input() <- &sarama.ProducerMessage{
+		Topic:    topic,
+		Key:      sarama.StringEncoder(key),
+		Value:    sarama.ByteEncoder(value),
+		Metadata: pending,
+	}:
+	case <-p.done:
+		p.inFlight.Add(-1)
+		p.finish(pending, Delivery{Topic: topic, Key: key, Err: ErrProducerClosed})
+	}
+	return future
+}
+
+// Flush waits until every message sent so far is delivered or failed
+func (p *AsyncProducer) Flush(ctx context.Context) error {
+	ticker := time.NewTicker(flushPollInterval)
+	defer ticker.Stop()
+	for p.inFlight.Load() > 0 {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return fmt.Errorf("kafka flush: %w", ctx.Err())
+		}
+	}
+	return nil
+}
+
+// Close stops accepting messages, flushes the ones in flight and closes
+// the producer
+func (p *AsyncProducer) Close(ctx context.Context) error {
+	if !p.closed.CompareAndSwap(false, true) {
+		return ErrProducerClosed
+	}
+	close(p.done)
+
+	flushErr := p.Flush(ctx)
+	// Sends still waiting on Input give up once done is closed; the lock
+	// waits for them, so a Flush that timed out cannot leave one behind
+	p.input.Lock()
+	err := p.producer.Close()
+	p.input.Unlock()
+	p.results.Wait()
+	if flushErr != nil {
+		return flushErr
+	}
+	return err
+}
+
+func (p *AsyncProducer) handleSuccesses() {
+	defer p.results.Done()
+	for msg := range p.producer.Successes() {
+		pending, ok := msg.Metadata.(*pendingMessage)
+		if !ok {
+			continue
+		}
+		p.finish(pending, Delivery{Topic: msg.Topic, Key: pending.key, Partition: msg.Partition, Offset: msg.Offset})
+		p.inFlight.Add(-1)
+	}
+}
+
+func (p *AsyncProducer) handleErrors() {
+	defer p.results.Done()
+	for perr := range p.producer.Errors() {
+		pending, ok := perr.Msg.Metadata.(*pendingMessage)
+		if !ok {
+			log.Printf("❌ Kafka async publish to [%s] failed: %v", perr.Msg.Topic, perr.Err)
+			continue
+		}
+
+		delivery := Delivery{Topic: perr.Msg.Topic, Key: pending.key, Err: perr.Err}
+		if p.spool != nil && spoolable(perr.Err) {
+			delivery = p.spoolMessage(perr.Msg.Topic, pending, perr.Err)
+			if delivery.Spooled {
+				log.Printf("⚠️ Kafka async publish to [%s] failed, message spooled for replay: %v", perr.Msg.Topic, perr.Err)
+			}
+		}
+		p.finish(pending, delivery)
+		p.inFlight.Add(-1)
+	}
+}
+
+// spoolMessage appends a message to the spool; cause is the Kafka error
+// that made it spooled, nil when it queues behind spooled messages
+func (p *AsyncProducer) spoolMessage(topic string, pending *pendingMessage, cause error) Delivery {
+	delivery := Delivery{Topic: topic, Key: pending.key, Cause: cause}
+	if err := p.spool.Add(context.Background(), topic, pending.key, pending.value, cause); err != nil {
+		log.Printf("❌ %v", err)
+		delivery.Err = err
+		if cause != nil {
+			delivery.Err = fmt.Errorf("%w (after Kafka error: %v)", err, cause)
+		}
+		return delivery
+	}
+	delivery.Spooled = true
+	return delivery
+}
+
+func (p *AsyncProducer) finish(pending *pendingMessage, d Delivery) {
+	if pending.callback != nil {
+		pending.callback(d)
+	}
+	pending.future.resolve(d)
+}
@@ -0,0 +1,99 @@
+package kf
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+func TestRetryBackoff(t *testing.T) {
+	tests := []struct {
+		name    string
+		retries int
+		initial time.Duration
+		max     time.Duration
+		want    time.Duration
+	}{
+		{name: "defaults, first retry", retries: 1, want: defaultRetryBackoff},
+		{name: "defaults, third retry", retries: 3, want: 4 * defaultRetryBackoff},
+		{name: "defaults, capped", retries: 20, want: defaultMaxRetryBackoff},
+		{name: "zero retries", retries: 0, initial: time.Second, max: time.Minute, want: time.Second},
+		{name: "doubles", retries: 4, initial: 50 * time.Millisecond, max: time.Minute, want: 400 * time.Millisecond},
+		{name: "capped at max", retries: 4, initial: time.Second, max: 5 * time.Second, want: 5 * time.Second},
+		{name: "initial above max", retries: 1, initial: time.Minute, max: time.Second, want: time.Second},
+		{name: "huge retry count does not overflow", retries: 1 << 20, initial: time.Second, max: time.Hour, want: time.Hour},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := retryBackoff(tt.retries, tt.initial, tt.max); got != tt.want {
+				t.Errorf("retryBackoff(%d, %s, %s) = %s, want %s", tt.retries, tt.initial, tt.max, got, tt.want)
+			}
+		})
+	}
+}
+
+// stalledProducer never takes messages from Input, like a producer whose
+// buffers are full
+type stalledProducer struct {
+	sarama.AsyncProducer
+	input     chan *sarama.ProducerMessage
+	successes chan *sarama.ProducerMessage
+	errors    chan *sarama.ProducerError
+}
+
+func newStalledProducer() *stalledProducer {
+	return &stalledProducer{
+		input:     make(chan *sarama.ProducerMessage),
+		successes: make(chan *sarama.ProducerMessage),
+		errors:    make(chan *sarama.ProducerError),
+	}
+}
+
+func (p *stalledProducer) Input() chan<- *sarama.ProducerMessage     { return p.input }
+func (p *stalledProducer) Successes() <-chan *sarama.ProducerMessage { return p.successes }
+func (p *stalledProducer) Errors() <-chan *sarama.ProducerError      { return p.errors }
+func (p *stalledProducer) Close() error {
+	close(p.input)
+	close(p.successes)
+	close(p.errors)
+	return nil
+}
+
+func TestAsyncProducerCloseWithBlockedSends(t *testing.T) {
+	p := newAsyncProducer(newStalledProducer(), nil)
+
+	var wg sync.WaitGroup
+	futures := make(chan *Future, 50)
+	for range cap(futures) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			futures <- p.Send("orders", "k", []byte("v"), nil)
+		}()
+	}
+
+	for p.inFlight.Load() < int64(cap(futures)) {
+		time.Sleep(time.Millisecond)
+	}
+
+	// The flush cannot finish, so Close closes the producer while sends
+	// are still waiting on Input; none of them may panic
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := p.Close(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("Close() = %v, want %v", err, context.Canceled)
+	}
+	wg.Wait()
+	close(futures)
+
+	for future := range futures {
+		if _, err := future.Wait(context.Background()); !errors.Is(err, ErrProducerClosed) {
+			t.Errorf("Wait() = %v, want %v", err, ErrProducerClosed)
+		}
+	}
+}
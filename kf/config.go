@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"log"
 	"strings"
+	"time"
 )
 
 // Config represents the application configuration
@@ -24,7 +25,35 @@ type Config struct {
 	Username   string
 	Password   string
 	SASLEnable bool
-	TLSEnable  bool
+	// SASLMechanism is PLAIN (default), SCRAM-SHA-256 or SCRAM-SHA-512
+	SASLMechanism string
+	TLSEnable     bool
+	// TLSCAFile verifies the brokers against a custom CA; TLSCertFile and
+	// TLSKeyFile present a client certificate
+	TLSCAFile     string
+	TLSCertFile   string
+	TLSKeyFile    string
+	TLSSkipVerify bool
+	// Workers is the number of workers per partition. Messages with the same
+	// key go to the same worker and keep their order; 0 or 1 handles one
+	// message at a time. The handler must be safe for concurrent use.
+	Workers int
+	// ReadCommitted makes consumers skip messages of aborted transactions
+	ReadCommitted bool
+	// Producer tunes the async producer of KafkaPublisher
+	Producer ProducerConfig
+	// CommitInterval is how often marked offsets are committed (1s by default)
+	CommitInterval time.Duration
+	// MaxLag makes ConsumerManager.Health fail when a partition of Topics
+	// is more messages behind; 0 only checks that a session is active
+	MaxLag int64
+	// MaxRetryLag is the same limit for the retry tiers; 0 ignores them
+	MaxRetryLag int64
+	// Retry routes messages whose handler failed to retry tiers and then
+	// to a dead-letter topic. It is off unless MaxRetryCount or DLQPrefix
+	// is set; while it is off, a failed message is not committed but
+	// consumed again after the session restarts.
+	Retry KafkaRetry
 }
 
 type AppConfig struct {
@@ -35,19 +64,11 @@ type AppConfig struct {
 func LoadConfig(brokersString string, groupID string, username string, password string, topics []string) (*Config, error) {
 	brokers := strings.Split(brokersString, ",")
 
-	// You can hardcode TLS/SASL if needed or add more config vars
-	saslEnable := true
-	tlsEnable := false
-
-	cfg := &Config{
-		Brokers:    brokers,
-		GroupID:    groupID,
-		Username:   username,
-		Password:   password,
-		SASLEnable: saslEnable,
-		TLSEnable:  tlsEnable,
-		Topics:     topics,
-	}
+	// SASL/TLS settings come from the KAFKA_* environment
+	cfg := SecurityFromEnv(username, password)
+	cfg.Brokers = brokers
+	cfg.GroupID = groupID
+	cfg.Topics = topics
 
 	if err := cfg.validate(); err != nil {
 		return nil, fmt.Errorf("invalid Kafka config: %w", err)
@@ -62,10 +83,10 @@ func (c *Config) validate() error {
 	if len(c.Brokers) == 0 {
 		return fmt.Errorf("missing brokers")
 	}
-	if c.Username == "" {
+	if c.SASLEnable && c.Username == "" {
 		return fmt.Errorf("missing username")
 	}
-	if c.Password == "" {
+	if c.SASLEnable && c.Password == "" {
 		return fmt.Errorf("missing password")
 	}
 	if c.GroupID == "" {
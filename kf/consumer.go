@@ -4,36 +4,91 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"time"
 
 	"github.com/IBM/sarama"
 )
 
+const (
+	defaultCommitInterval = time.Second
+	maxRouteBackoff       = 30 * time.Second
+	// maxRouteAttempts bounds how often a failed message is handed to its
+	// retry or dead-letter topic before the claim gives up on it
+	maxRouteAttempts = 8
+)
+
 // HandlerFunc defines the signature for message handlers
 type HandlerFunc func(message *sarama.ConsumerMessage) error
 
+// ContextHandlerFunc is a message handler that receives the context of the
+// consumer group session, which is cancelled on rebalance and shutdown
+type ContextHandlerFunc func(ctx context.Context, message *sarama.ConsumerMessage) error
+
 // ConsumerManager manages the Kafka consumer lifecycle
 type ConsumerManager struct {
 	config        *Config
 	consumerGroup sarama.ConsumerGroup
-	handler       HandlerFunc
+	producer      sarama.SyncProducer
+	client        sarama.Client
+	admin         sarama.ClusterAdmin
+	metrics       *ConsumerMetrics
+	lag           lagCache
+	handler       ContextHandlerFunc
 	ctx           context.Context
 	cancel        context.CancelFunc
 }
 
 // NewConsumerManager creates a new consumer manager
 func NewConsumerManager(cfg *Config, handler HandlerFunc) (*ConsumerManager, error) {
-	saramaConfig := newSaramaConfig(cfg)
+	return NewContextConsumerManager(cfg, func(_ context.Context, message *sarama.ConsumerMessage) error {
+		return handler(message)
+	})
+}
+
+// NewContextConsumerManager creates a consumer manager whose handler gets
+// the session context, e.g. Router.Dispatch
+func NewContextConsumerManager(cfg *Config, handler ContextHandlerFunc) (*ConsumerManager, error) {
+	saramaConfig, err := newSaramaConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
 
 	consumerGroup, err := sarama.NewConsumerGroup(cfg.Brokers, cfg.GroupID, saramaConfig)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create consumer group: %w", err)
 	}
 
+	// Failed messages are re-published to retry and dead-letter topics
+	producer, err := sarama.NewSyncProducer(cfg.Brokers, saramaConfig)
+	if err != nil {
+		consumerGroup.Close()
+		return nil, fmt.Errorf("failed to create retry producer: %w", err)
+	}
+
+	// High-water marks and committed offsets for Lag and Health
+	client, err := sarama.NewClient(cfg.Brokers, saramaConfig)
+	if err != nil {
+		producer.Close()
+		consumerGroup.Close()
+		return nil, fmt.Errorf("failed to create Kafka client: %w", err)
+	}
+	admin, err := sarama.NewClusterAdminFromClient(client)
+	if err != nil {
+		client.Close()
+		producer.Close()
+		consumerGroup.Close()
+		return nil, fmt.Errorf("failed to create Kafka cluster admin: %w", err)
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
 
 	return &ConsumerManager{
 		config:        cfg,
 		consumerGroup: consumerGroup,
+		producer:      producer,
+		client:        client,
+		admin:         admin,
+		metrics:       &ConsumerMetrics{},
 		handler:       handler,
 		ctx:           ctx,
 		cancel:        cancel,
@@ -47,8 +102,15 @@ func (cm *ConsumerManager) Start() error {
 	}
 
 	handler := &ConsumerGroupHandler{
-		handler: cm.handler,
+		handler:        cm.handler,
+		metrics:        cm.metrics,
+		commitInterval: cm.config.CommitInterval,
+		workers:        cm.config.Workers,
 	}
+	if cm.config.Retry.Enabled() {
+		handler.retrier = NewRetrier(cm.producer, cm.config.Retry)
+	}
+	topics := append(append([]string{}, cm.config.Topics...), cm.config.Retry.RetryTopics(cm.config.Topics)...)
 
 	go func() {
 		for {
@@ -56,7 +118,7 @@ func (cm *ConsumerManager) Start() error {
 			case <-cm.ctx.Done():
 				return
 			default:
-				if err := cm.consumerGroup.Consume(cm.ctx, cm.config.Topics, handler); err != nil {
+				if err := cm.consumerGroup.Consume(cm.ctx, topics, handler); err != nil {
 					log.Printf("❌ Kafka consume error: %v", err)
 				}
 			}
@@ -64,7 +126,7 @@ func (cm *ConsumerManager) Start() error {
 	}()
 
 	log.Printf("✅ Consumer started for topics: %v, group: %s, brokers: %v", 
-		cm.config.Topics, cm.config.GroupID, cm.config.Brokers)
+		topics, cm.config.GroupID, cm.config.Brokers)
 	log.Printf("📋 Consumer config: offset=OffsetOldest (consumes ALL messages, including those published before consumer starts)")
 	return nil
 }
@@ -78,32 +140,91 @@ func (cm *ConsumerManager) Stop() error {
 	if err := cm.consumerGroup.Close(); err != nil {
 		return fmt.Errorf("failed to close consumer group: %w", err)
 	}
+	if err := cm.producer.Close(); err != nil {
+		return fmt.Errorf("failed to close retry producer: %w", err)
+	}
+	// Closing the admin closes its client too
+	if err := cm.admin.Close(); err != nil {
+		return fmt.Errorf("failed to close Kafka cluster admin: %w", err)
+	}
 
 	log.Println("✅ Consumer stopped")
 	return nil
 }
 
-// ConsumerGroupHandler implements sarama.ConsumerGroupHandler
+// ConsumerGroupHandler implements sarama.ConsumerGroupHandler with
+// at-least-once delivery: an offset is marked only once its message was
+// handled or handed to a retry/dead-letter topic, and marked offsets are
+// committed every commitInterval and when the session ends.
 type ConsumerGroupHandler struct {
-	handler HandlerFunc
+	handler        ContextHandlerFunc
+	retrier        *Retrier
+	metrics        *ConsumerMetrics
+	commitInterval time.Duration
+	workers        int
+
+	stopCommit chan struct{}
+	commitDone chan struct{}
 }
 
-func (h *ConsumerGroupHandler) Setup(sarama.ConsumerGroupSession) error {
+func (h *ConsumerGroupHandler) Setup(session sarama.ConsumerGroupSession) error {
 	log.Println("✅ Consumer group session setup")
+	if h.metrics != nil {
+		h.metrics.joins.Add(1)
+		h.metrics.sessions.Add(1)
+	}
+
+	interval := h.commitInterval
+	if interval <= 0 {
+		interval = defaultCommitInterval
+	}
+	h.stopCommit = make(chan struct{})
+	h.commitDone = make(chan struct{})
+	go func() {
+		defer close(h.commitDone)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				session.Commit()
+			case <-h.stopCommit:
+				return
+			}
+		}
+	}()
 	return nil
 }
 
-func (h *ConsumerGroupHandler) Cleanup(sarama.ConsumerGroupSession) error {
+// Cleanup runs once every ConsumeClaim has returned, so no message is in
+// flight any more; it commits what was marked before the partitions are
+// handed over
+func (h *ConsumerGroupHandler) Cleanup(session sarama.ConsumerGroupSession) error {
+	if h.stopCommit != nil {
+		close(h.stopCommit)
+		<-h.commitDone
+	}
+	session.Commit()
+	if h.metrics != nil {
+		h.metrics.sessions.Add(-1)
+	}
 	log.Println("🛑 Consumer group session cleanup")
 	return nil
 }
 
 func (h *ConsumerGroupHandler) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
 	log.Printf("📥 Starting to consume from partition %d, initial offset: %d", claim.Partition(), claim.InitialOffset())
+	if h.workers > 1 {
+		return h.consumeConcurrently(session, claim)
+	}
 	
 	for {
 		select {
-		case message := <-claim.Messages():
+		case message, ok := <-claim.Messages():
+			if !ok {
+				log.Printf("🛑 Claim for partition %d closed", claim.Partition())
+				return nil
+			}
 			if message == nil {
 				log.Println("⚠️ Received nil message, continuing...")
 				continue
@@ -112,14 +233,12 @@ func (h *ConsumerGroupHandler) ConsumeClaim(session sarama.ConsumerGroupSession,
 			log.Printf("📨 Consuming message: topic=[%s], partition=%d, offset=%d, key=%s, value_len=%d", 
 				message.Topic, message.Partition, message.Offset, string(message.Key), len(message.Value))
 
-			if err := h.handler(message); err != nil {
-				log.Printf("❌ Handler error on topic [%s], partition %d, offset %d: %v", 
-					message.Topic, message.Partition, message.Offset, err)
-			} else {
-				log.Printf("✅ Successfully processed message: topic=[%s], partition=%d, offset=%d", 
-					message.Topic, message.Partition, message.Offset)
+			if !h.process(session.Context(), message) {
+				// Not marked; ending the claim ends the session, so the
+				// message is delivered again once the consumer rejoins
+				log.Printf("🛑 Stopping claim for partition %d at offset %d", claim.Partition(), message.Offset)
+				return nil
 			}
-
 			session.MarkMessage(message, "")
 
 		case <-session.Context().Done():
@@ -129,8 +248,89 @@ func (h *ConsumerGroupHandler) ConsumeClaim(session sarama.ConsumerGroupSession,
 	}
 }
 
+// process handles one message and reports whether its offset may be
+// marked. A failed message is routed to its retry or dead-letter topic,
+// retrying the hand-off up to maxRouteAttempts times while ctx lasts; when
+// that fails, or when no retry routing is configured, the message is left
+// unmarked and the claim must end.
+func (h *ConsumerGroupHandler) process(ctx context.Context, message *sarama.ConsumerMessage) bool {
+	if !waitNotBefore(ctx, message) {
+		return false
+	}
+
+	start := time.Now()
+	err := h.handler(ctx, originalMessage(message))
+	if h.metrics != nil {
+		h.metrics.observe(time.Since(start), err)
+	}
+	if err == nil {
+		log.Printf("✅ Successfully processed message: topic=[%s], partition=%d, offset=%d", 
+			message.Topic, message.Partition, message.Offset)
+		return true
+	}
+
+	log.Printf("❌ Handler error on topic [%s], partition %d, offset %d: %v", 
+		message.Topic, message.Partition, message.Offset, err)
+	if h.retrier == nil {
+		log.Printf("⚠️ No retry or dead-letter topic configured, leaving offset %d of partition %d unmarked",
+			message.Offset, message.Partition)
+		return false
+	}
+
+	backoff := time.Second
+	for attempt := 1; ; attempt++ {
+		topic, routeErr := h.retrier.Route(message, err)
+		if routeErr == nil {
+			h.countRouted(message, topic)
+			log.Printf("🔁 Routed failed message to topic [%s] (attempt %d)", topic, Attempt(message)+1)
+			return true
+		}
+		if attempt == maxRouteAttempts {
+			if h.metrics != nil {
+				h.metrics.routeFailures.Add(1)
+			}
+			log.Printf("🚨 Giving up routing message: topic=[%s], partition=%d, offset=%d after %d attempts: %v",
+				message.Topic, message.Partition, message.Offset, attempt, routeErr)
+			return false
+		}
+		log.Printf("❌ %v, retrying in %s", routeErr, backoff)
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return false
+		}
+		backoff = min(backoff*2, maxRouteBackoff)
+	}
+}
+
+// countRouted counts a failed message as a retry or, once it reached the
+// dead-letter topic, as a DLQ send
+func (h *ConsumerGroupHandler) countRouted(message *sarama.ConsumerMessage, topic string) {
+	if h.metrics == nil {
+		return
+	}
+	if topic == h.retrier.retry.DLQTopic(OriginOf(message).Topic) {
+		h.metrics.dlq.Add(1)
+	} else {
+		h.metrics.retries.Add(1)
+	}
+}
+
+// originalMessage presents a message taken from a retry tier to the
+// handler under the topic it was first published to
+func originalMessage(message *sarama.ConsumerMessage) *sarama.ConsumerMessage {
+	origin := OriginOf(message)
+	if origin.Topic == message.Topic {
+		return message
+	}
+	m := *message
+	m.Topic = origin.Topic
+	return &m
+}
+
 // newSaramaConfig creates a new Sarama configuration
-func newSaramaConfig(cfg *Config) *sarama.Config {
+func newSaramaConfig(cfg *Config) (*sarama.Config, error) {
 	config := sarama.NewConfig()
 
 	config.Producer.Return.Successes = true
@@ -139,11 +339,14 @@ func newSaramaConfig(cfg *Config) *sarama.Config {
 	config.Consumer.Offsets.Initial = sarama.OffsetOldest
 	config.Consumer.Group.Rebalance.Strategy = sarama.BalanceStrategyRoundRobin
 	config.Version = sarama.V2_8_0_0
-	config.Net.SASL.Enable = true
-	config.Net.SASL.Mechanism = sarama.SASLTypePlaintext
-	config.Net.SASL.User = cfg.Username
-	config.Net.SASL.Password = cfg.Password
-	config.Net.TLS.Enable = false
+	// Offsets are committed explicitly by ConsumerGroupHandler
+	config.Consumer.Offsets.AutoCommit.Enable = false
+	if cfg.ReadCommitted {
+		config.Consumer.IsolationLevel = sarama.ReadCommitted
+	}
+	if err := cfg.ApplySecurity(config); err != nil {
+		return nil, fmt.Errorf("invalid Kafka security config: %w", err)
+	}
 
-	return config
+	return config, nil
 }
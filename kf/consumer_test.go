@@ -0,0 +1,76 @@
+package kf
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/IBM/sarama"
+)
+
+// claimSession is a markingSession with a live context
+type claimSession struct {
+	markingSession
+	ctx context.Context
+}
+
+func (s *claimSession) Context() context.Context { return s.ctx }
+
+// staticClaim delivers a fixed list of messages and then closes
+type staticClaim struct {
+	sarama.ConsumerGroupClaim
+	messages chan *sarama.ConsumerMessage
+}
+
+func newStaticClaim(offsets ...int64) *staticClaim {
+	c := &staticClaim{messages: make(chan *sarama.ConsumerMessage, len(offsets))}
+	for _, offset := range offsets {
+		c.messages <- &sarama.ConsumerMessage{Topic: "orders", Offset: offset}
+	}
+	close(c.messages)
+	return c
+}
+
+func (c *staticClaim) Messages() <-chan *sarama.ConsumerMessage { return c.messages }
+func (c *staticClaim) Partition() int32                         { return 0 }
+func (c *staticClaim) InitialOffset() int64                     { return 0 }
+
+func TestConsumeClaimWithoutRetryLeavesFailuresUnmarked(t *testing.T) {
+	errHandler := errors.New("handler failed")
+
+	tests := []struct {
+		name       string
+		workers    int
+		failing    int64 // offset whose handler fails; 0 for none
+		wantMarked []int64
+	}{
+		{name: "all handled", workers: 1, wantMarked: []int64{1, 2, 3}},
+		{name: "failure ends the claim", workers: 1, failing: 2, wantMarked: []int64{1}},
+		{name: "first message fails", workers: 1, failing: 1, wantMarked: nil},
+		{name: "workers, all handled", workers: 4, wantMarked: []int64{1, 2, 3}},
+		{name: "workers, failure holds back later offsets", workers: 4, failing: 1, wantMarked: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &ConsumerGroupHandler{
+				workers: tt.workers,
+				handler: func(_ context.Context, message *sarama.ConsumerMessage) error {
+					if message.Offset == tt.failing {
+						return errHandler
+					}
+					return nil
+				},
+			}
+			session := &claimSession{ctx: context.Background()}
+
+			if err := h.ConsumeClaim(session, newStaticClaim(1, 2, 3)); err != nil {
+				t.Fatalf("ConsumeClaim() = %v", err)
+			}
+			if !reflect.DeepEqual(session.marked, tt.wantMarked) {
+				t.Errorf("marked %v, want %v", session.marked, tt.wantMarked)
+			}
+		})
+	}
+}
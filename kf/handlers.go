@@ -1 +1,154 @@
 package kf
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/IBM/sarama"
+)
+
+// ErrInvalidEnvelope is returned for messages that are not a JSON envelope
+var ErrInvalidEnvelope = errors.New("invalid event envelope")
+
+// Envelope is the event structure written by PublishEvent. Events
+// published before schema versioning have no EventID and version 0.
+type Envelope struct {
+	EventID       string          `json:"event_id,omitempty"`
+	EventType     string          `json:"event_type"`
+	SchemaVersion int             `json:"schema_version,omitempty"`
+	AggregateID   string          `json:"aggregate_id"`
+	Payload       json.RawMessage `json:"payload"`
+	Timestamp     int64           `json:"timestamp"`
+}
+
+// Event is a decoded envelope together with the message it came in
+type Event struct {
+	Envelope
+	Message *sarama.ConsumerMessage
+}
+
+// EventHandler handles one event
+type EventHandler func(ctx context.Context, event *Event) error
+
+// Middleware wraps an EventHandler, for instance to log or recover
+type Middleware func(next EventHandler) EventHandler
+
+// Router dispatches envelopes to the handler registered for their
+// event_type. Its Dispatch method is a ContextHandlerFunc:
+//
+//	router := kf.NewRouter()
+//	router.Use(kf.Recovery(), kf.Logging())
+//	kf.On(router, "user.created", func(ctx context.Context, e *kf.Event, p UserCreated) error { ... })
+//	consumer, err := kf.NewContextConsumerManager(cfg, router.Dispatch)
+type Router struct {
+	mu         sync.RWMutex
+	handlers   map[string]EventHandler
+	middleware []Middleware
+	fallback   EventHandler
+	schemas    *SchemaRegistry
+}
+
+// NewRouter creates an empty router
+func NewRouter() *Router {
+	return &Router{handlers: map[string]EventHandler{}}
+}
+
+// Use appends middleware; the first one added is the outermost
+func (r *Router) Use(mw ...Middleware) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.middleware = append(r.middleware, mw...)
+}
+
+// Handle registers the handler of an event type, replacing any previous one
+func (r *Router) Handle(eventType string, h EventHandler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.handlers[eventType] = h
+}
+
+// UseSchemas makes the router upcast every event to the current schema
+// version of its type before dispatching it
+func (r *Router) UseSchemas(schemas *SchemaRegistry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.schemas = schemas
+}
+
+// Fallback registers the handler of event types nothing was registered
+// for. Without one, such events are logged and skipped.
+func (r *Router) Fallback(h EventHandler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.fallback = h
+}
+
+// On registers a handler receiving the payload decoded into T
+func On[T any](r *Router, eventType string, h func(ctx context.Context, event *Event, payload T) error) {
+	r.Handle(eventType, func(ctx context.Context, event *Event) error {
+		var payload T
+		if err := DecodePayload(event, &payload); err != nil {
+			return err
+		}
+		return h(ctx, event, payload)
+	})
+}
+
+// DecodePayload unmarshals the payload of an event into v
+func DecodePayload(event *Event, v any) error {
+	if len(event.Payload) == 0 {
+		return fmt.Errorf("event %s has no payload", event.EventType)
+	}
+	if err := json.Unmarshal(event.Payload, v); err != nil {
+		return fmt.Errorf("failed to decode %s payload: %w", event.EventType, err)
+	}
+	return nil
+}
+
+// HandleMessage is Dispatch as a HandlerFunc. Its handlers run under
+// context.Background and are not cancelled on rebalance; use Dispatch
+// with NewContextConsumerManager instead.
+func (r *Router) HandleMessage(message *sarama.ConsumerMessage) error {
+	return r.Dispatch(context.Background(), message)
+}
+
+// Dispatch decodes the envelope of a message and runs the handler of its
+// event type through the middleware chain
+func (r *Router) Dispatch(ctx context.Context, message *sarama.ConsumerMessage) error {
+	event := &Event{Message: message}
+	if err := json.Unmarshal(message.Value, &event.Envelope); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidEnvelope, err)
+	}
+	if event.EventType == "" {
+		return fmt.Errorf("%w: missing event_type", ErrInvalidEnvelope)
+	}
+
+	r.mu.RLock()
+	h, ok := r.handlers[event.EventType]
+	if !ok {
+		h = r.fallback
+	}
+	middleware := r.middleware
+	schemas := r.schemas
+	r.mu.RUnlock()
+
+	if schemas != nil {
+		if err := schemas.Upcast(event); err != nil {
+			return err
+		}
+	}
+
+	if h == nil {
+		log.Printf("⚠️ No handler for event type [%s] on topic [%s], skipping", event.EventType, message.Topic)
+		return nil
+	}
+
+	for i := len(middleware) - 1; i >= 0; i-- {
+		h = middleware[i](h)
+	}
+	return h(ctx, event)
+}
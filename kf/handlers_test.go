@@ -0,0 +1,95 @@
+package kf
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/IBM/sarama"
+)
+
+func TestRouterDispatch(t *testing.T) {
+	type userCreated struct {
+		Name string `json:"name"`
+	}
+
+	var trace []string
+	router := NewRouter()
+	router.Use(tracing("outer", &trace), tracing("inner", &trace))
+	On(router, "user.created", func(_ context.Context, event *Event, p userCreated) error {
+		trace = append(trace, "user.created "+event.AggregateID+" "+p.Name)
+		return nil
+	})
+
+	tests := []struct {
+		name      string
+		value     string
+		fallback  bool
+		wantErr   error
+		wantTrace []string
+	}{
+		{
+			name:      "registered type",
+			value:     `{"event_type":"user.created","aggregate_id":"u-1","payload":{"name":"Ada"}}`,
+			wantTrace: []string{"outer", "inner", "user.created u-1 Ada"},
+		},
+		{name: "unknown type is skipped", value: `{"event_type":"user.deleted","aggregate_id":"u-1"}`},
+		{
+			name:      "unknown type with a fallback",
+			value:     `{"event_type":"user.deleted","aggregate_id":"u-1"}`,
+			fallback:  true,
+			wantTrace: []string{"outer", "inner", "fallback user.deleted"},
+		},
+		{name: "not json", value: `user.created`, wantErr: ErrInvalidEnvelope},
+		{name: "no event type", value: `{"aggregate_id":"u-1"}`, wantErr: ErrInvalidEnvelope},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trace = nil
+			router.Fallback(nil)
+			if tt.fallback {
+				router.Fallback(func(_ context.Context, event *Event) error {
+					trace = append(trace, "fallback "+event.EventType)
+					return nil
+				})
+			}
+
+			err := router.Dispatch(context.Background(), &sarama.ConsumerMessage{Topic: "users", Value: []byte(tt.value)})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Dispatch() = %v, want %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(trace, tt.wantTrace) {
+				t.Errorf("ran %v, want %v", trace, tt.wantTrace)
+			}
+		})
+	}
+}
+
+func TestOnRejectsUndecodablePayloads(t *testing.T) {
+	router := NewRouter()
+	On(router, "user.created", func(context.Context, *Event, struct{ Name string }) error {
+		t.Error("handler ran without a payload")
+		return nil
+	})
+
+	for _, value := range []string{
+		`{"event_type":"user.created"}`,
+		`{"event_type":"user.created","payload":"Ada"}`,
+	} {
+		if err := router.HandleMessage(&sarama.ConsumerMessage{Value: []byte(value)}); err == nil {
+			t.Errorf("HandleMessage(%s) = nil, want a decode error", value)
+		}
+	}
+}
+
+// tracing is a middleware appending name to trace before the handler runs
+func tracing(name string, trace *[]string) Middleware {
+	return func(next EventHandler) EventHandler {
+		return func(ctx context.Context, event *Event) error {
+			*trace = append(*trace, name)
+			return next(ctx, event)
+		}
+	}
+}
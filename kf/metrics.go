@@ -0,0 +1,243 @@
+package kf
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+var (
+	// ErrNoSession is reported by Health while the consumer holds no
+	// consumer group session, e.g. before the first join or mid-rebalance
+	ErrNoSession = errors.New("no active kafka consumer group session")
+	// ErrLagTooHigh is reported by Health when a partition lags more than
+	// Config.MaxLag messages behind
+	ErrLagTooHigh = errors.New("kafka consumer lag too high")
+)
+
+// latencyBuckets are the upper bounds of the handler latency histogram
+var latencyBuckets = [...]time.Duration{
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+	10 * time.Second,
+}
+
+// ConsumerMetrics counts what went through a ConsumerManager
+type ConsumerMetrics struct {
+	consumed atomic.Int64
+	errors   atomic.Int64
+	retries  atomic.Int64
+	dlq      atomic.Int64
+	joins    atomic.Int64 // sessions started; every one after the first is a rebalance
+	sessions atomic.Int64
+	// routeFailures counts failed messages that could not be handed to
+	// their retry or dead-letter topic; alert on it
+	routeFailures atomic.Int64
+
+	// latency[i] counts handler calls up to latencyBuckets[i]; the last
+	// one counts the slower ones
+	latency      [len(latencyBuckets) + 1]atomic.Int64
+	latencyTotal atomic.Int64
+}
+
+// ConsumerStats is a point-in-time copy of ConsumerMetrics
+type ConsumerStats struct {
+	Consumed      int64            `json:"consumed"`
+	Errors        int64            `json:"errors"`
+	Retries       int64            `json:"retries"`
+	DLQ           int64            `json:"dlq"`
+	Rebalances    int64            `json:"rebalances"` // sessions started after the first
+	RouteFailures int64            `json:"route_failures"`
+	Active        bool             `json:"active"` // a session is running
+	Latency       LatencyHistogram `json:"latency"`
+}
+
+// LatencyHistogram is a cumulative histogram of handler latencies
+type LatencyHistogram struct {
+	Buckets []LatencyBucket `json:"buckets"`
+	Count   int64           `json:"count"`
+	Total   time.Duration   `json:"total"`
+}
+
+// LatencyBucket counts the handler calls that took at most LE; the last
+// bucket of a histogram has no bound and counts every call
+type LatencyBucket struct {
+	LE    time.Duration `json:"le"`
+	Count int64         `json:"count"`
+}
+
+func (m *ConsumerMetrics) observe(d time.Duration, err error) {
+	m.consumed.Add(1)
+	if err != nil {
+		m.errors.Add(1)
+	}
+	i := 0
+	for i < len(latencyBuckets) && d > latencyBuckets[i] {
+		i++
+	}
+	m.latency[i].Add(1)
+	m.latencyTotal.Add(int64(d))
+}
+
+func (m *ConsumerMetrics) Snapshot() ConsumerStats {
+	histogram := LatencyHistogram{
+		Buckets: make([]LatencyBucket, 0, len(m.latency)),
+		Total:   time.Duration(m.latencyTotal.Load()),
+	}
+	for i := range m.latency {
+		histogram.Count += m.latency[i].Load()
+		bucket := LatencyBucket{Count: histogram.Count}
+		if i < len(latencyBuckets) {
+			bucket.LE = latencyBuckets[i]
+		}
+		histogram.Buckets = append(histogram.Buckets, bucket)
+	}
+
+	return ConsumerStats{
+		Consumed:      m.consumed.Load(),
+		Errors:        m.errors.Load(),
+		Retries:       m.retries.Load(),
+		DLQ:           m.dlq.Load(),
+		Rebalances:    max(m.joins.Load()-1, 0),
+		RouteFailures: m.routeFailures.Load(),
+		Active:        m.sessions.Load() > 0,
+		Latency:       histogram,
+	}
+}
+
+// PartitionLag is how far the group is behind on one partition
+type PartitionLag struct {
+	Topic         string `json:"topic"`
+	Partition     int32  `json:"partition"`
+	HighWaterMark int64  `json:"high_water_mark"`
+	Committed     int64  `json:"committed"` // -1 when nothing was committed yet
+	Lag           int64  `json:"lag"`
+}
+
+// lagCacheTTL is how long Lag reuses its last result; every computation
+// asks the brokers for two offsets per partition
+const lagCacheTTL = 5 * time.Second
+
+// lagCache holds the last result of Lag
+type lagCache struct {
+	mu   sync.Mutex
+	at   time.Time
+	lags []PartitionLag
+}
+
+// Stats reports the counters of the consumer
+func (cm *ConsumerManager) Stats() ConsumerStats {
+	return cm.metrics.Snapshot()
+}
+
+// Lag returns, for every partition of the consumed topics and their retry
+// tiers, the high-water mark minus the offset committed by the group.
+// Partitions the group never committed lag from their oldest offset.
+// Results are cached for a few seconds, so probes can call it often.
+func (cm *ConsumerManager) Lag(ctx context.Context) ([]PartitionLag, error) {
+	cm.lag.mu.Lock()
+	defer cm.lag.mu.Unlock()
+
+	if cm.lag.lags != nil && time.Since(cm.lag.at) < lagCacheTTL {
+		return slices.Clone(cm.lag.lags), nil
+	}
+	lags, err := cm.fetchLag(ctx)
+	if err != nil {
+		return nil, err
+	}
+	cm.lag.at = time.Now()
+	cm.lag.lags = lags
+	return lags, nil
+}
+
+func (cm *ConsumerManager) fetchLag(ctx context.Context) ([]PartitionLag, error) {
+	topics := append(append([]string{}, cm.config.Topics...), cm.config.Retry.RetryTopics(cm.config.Topics)...)
+
+	partitions := map[string][]int32{}
+	for _, topic := range topics {
+		ps, err := cm.client.Partitions(topic)
+		if errors.Is(err, sarama.ErrUnknownTopicOrPartition) {
+			continue // retry tiers are created on first use
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to list partitions of %s: %w", topic, err)
+		}
+		partitions[topic] = ps
+	}
+
+	committed, err := cm.admin.ListConsumerGroupOffsets(cm.config.GroupID, partitions)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch committed offsets of group %s: %w", cm.config.GroupID, err)
+	}
+
+	lags := []PartitionLag{}
+	for topic, ps := range partitions {
+		for _, partition := range ps {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+
+			hwm, err := cm.client.GetOffset(topic, partition, sarama.OffsetNewest)
+			if err != nil {
+				return nil, fmt.Errorf("failed to fetch high-water mark of %s/%d: %w", topic, partition, err)
+			}
+			lag := PartitionLag{Topic: topic, Partition: partition, HighWaterMark: hwm, Committed: -1}
+
+			from := int64(-1)
+			if block := committed.GetBlock(topic, partition); block != nil && block.Offset >= 0 {
+				lag.Committed = block.Offset
+				from = block.Offset
+			}
+			if from < 0 {
+				if from, err = cm.client.GetOffset(topic, partition, sarama.OffsetOldest); err != nil {
+					return nil, fmt.Errorf("failed to fetch oldest offset of %s/%d: %w", topic, partition, err)
+				}
+			}
+			lag.Lag = max(hwm-from, 0)
+			lags = append(lags, lag)
+		}
+	}
+	return lags, nil
+}
+
+// Health returns nil while the consumer holds a session and no partition
+// lags too far behind: Config.MaxLag messages on the consumed topics and
+// Config.MaxRetryLag on their retry tiers, where messages wait on purpose.
+// A zero limit is not checked. It is meant for readiness probes.
+func (cm *ConsumerManager) Health(ctx context.Context) error {
+	if cm.metrics.sessions.Load() == 0 {
+		return ErrNoSession
+	}
+	if cm.config.MaxLag <= 0 && cm.config.MaxRetryLag <= 0 {
+		return nil
+	}
+
+	lags, err := cm.Lag(ctx)
+	if err != nil {
+		return err
+	}
+	for _, lag := range lags {
+		limit := cm.config.MaxLag
+		if !slices.Contains(cm.config.Topics, lag.Topic) {
+			limit = cm.config.MaxRetryLag
+		}
+		if limit > 0 && lag.Lag > limit {
+			return fmt.Errorf("%w: %s/%d is %d messages behind (max %d)", ErrLagTooHigh, lag.Topic, lag.Partition, lag.Lag, limit)
+		}
+	}
+	return nil
+}
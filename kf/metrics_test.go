@@ -0,0 +1,87 @@
+package kf
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestConsumerMetricsSnapshot(t *testing.T) {
+	var m ConsumerMetrics
+	m.observe(3*time.Millisecond, nil)
+	m.observe(5*time.Millisecond, nil)
+	m.observe(40*time.Millisecond, errors.New("db down"))
+	m.observe(time.Minute, nil)
+	m.joins.Add(3)
+	m.sessions.Add(1)
+
+	stats := m.Snapshot()
+	if stats.Consumed != 4 || stats.Errors != 1 || stats.Rebalances != 2 || !stats.Active {
+		t.Errorf("stats = %+v", stats)
+	}
+
+	histogram := stats.Latency
+	if histogram.Count != 4 || histogram.Total != 48*time.Millisecond+time.Minute {
+		t.Errorf("histogram count %d, total %s", histogram.Count, histogram.Total)
+	}
+	if n := len(histogram.Buckets); n != len(latencyBuckets)+1 {
+		t.Fatalf("%d buckets, want %d", n, len(latencyBuckets)+1)
+	}
+	// Buckets are cumulative and the last one has no bound
+	want := map[time.Duration]int64{5 * time.Millisecond: 2, 25 * time.Millisecond: 2, 50 * time.Millisecond: 3, 10 * time.Second: 3, 0: 4}
+	for _, bucket := range histogram.Buckets {
+		if n, ok := want[bucket.LE]; ok && bucket.Count != n {
+			t.Errorf("bucket le %s counts %d, want %d", bucket.LE, bucket.Count, n)
+		}
+	}
+}
+
+func TestConsumerMetricsNoRebalanceBeforeJoin(t *testing.T) {
+	var m ConsumerMetrics
+	if stats := m.Snapshot(); stats.Rebalances != 0 || stats.Active {
+		t.Errorf("stats = %+v, want no rebalance and no session", stats)
+	}
+}
+
+func TestHealth(t *testing.T) {
+	lags := []PartitionLag{
+		{Topic: "orders", Partition: 0, Lag: 50},
+		{Topic: "orders.retry.1", Partition: 0, Lag: 500},
+	}
+
+	tests := []struct {
+		name        string
+		noSession   bool
+		maxLag      int64
+		maxRetryLag int64
+		wantErr     error
+	}{
+		{name: "no session", noSession: true, wantErr: ErrNoSession},
+		{name: "no limits", wantErr: nil},
+		{name: "within limits", maxLag: 100, maxRetryLag: 1000, wantErr: nil},
+		{name: "consumed topic behind", maxLag: 10, maxRetryLag: 1000, wantErr: ErrLagTooHigh},
+		{name: "retry tier behind", maxLag: 100, maxRetryLag: 100, wantErr: ErrLagTooHigh},
+		// Retry tiers are held back on purpose and only have their own limit
+		{name: "retry tier not held to max lag", maxLag: 100, wantErr: nil},
+		{name: "only the retry limit", maxRetryLag: 100, wantErr: ErrLagTooHigh},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cm := &ConsumerManager{
+				config:  &Config{Topics: []string{"orders"}, MaxLag: tt.maxLag, MaxRetryLag: tt.maxRetryLag},
+				metrics: &ConsumerMetrics{},
+			}
+			if !tt.noSession {
+				cm.metrics.sessions.Add(1)
+			}
+			// A fresh cached result keeps Lag away from the brokers
+			cm.lag.lags, cm.lag.at = lags, time.Now()
+
+			if err := cm.Health(context.Background()); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Health() = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
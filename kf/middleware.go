@@ -0,0 +1,163 @@
+package kf
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Logging logs every event with its outcome and duration
+func Logging() Middleware {
+	return func(next EventHandler) EventHandler {
+		return func(ctx context.Context, event *Event) error {
+			start := time.Now()
+			err := next(ctx, event)
+			if err != nil {
+				log.Printf("❌ [EVENT] %s aggregate_id=%s failed after %s: %v", event.EventType, event.AggregateID, time.Since(start), err)
+			} else {
+				log.Printf("✅ [EVENT] %s aggregate_id=%s handled in %s", event.EventType, event.AggregateID, time.Since(start))
+			}
+			return err
+		}
+	}
+}
+
+// Recovery turns a panicking handler into an error, so the event goes to
+// the retry topics instead of crashing the consumer
+func Recovery() Middleware {
+	return func(next EventHandler) EventHandler {
+		return func(ctx context.Context, event *Event) (err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					log.Printf("❌ Panic handling event %s: %v\n%s", event.EventType, r, debug.Stack())
+					err = fmt.Errorf("panic handling event %s: %v", event.EventType, r)
+				}
+			}()
+			return next(ctx, event)
+		}
+	}
+}
+
+// RouterMetrics counts handled events per event type
+type RouterMetrics struct {
+	mu    sync.Mutex
+	types map[string]*EventTypeStats
+}
+
+// EventTypeStats is a point-in-time copy of the counters of one event type
+type EventTypeStats struct {
+	Handled       int64         `json:"handled"`
+	Failed        int64         `json:"failed"`
+	TotalDuration time.Duration `json:"total_duration"`
+}
+
+// Middleware records the outcome and duration of every event
+func (m *RouterMetrics) Middleware() Middleware {
+	return func(next EventHandler) EventHandler {
+		return func(ctx context.Context, event *Event) error {
+			start := time.Now()
+			err := next(ctx, event)
+			m.record(event.EventType, time.Since(start), err)
+			return err
+		}
+	}
+}
+
+func (m *RouterMetrics) record(eventType string, d time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.types == nil {
+		m.types = map[string]*EventTypeStats{}
+	}
+	s := m.types[eventType]
+	if s == nil {
+		s = &EventTypeStats{}
+		m.types[eventType] = s
+	}
+	if err != nil {
+		s.Failed++
+	} else {
+		s.Handled++
+	}
+	s.TotalDuration += d
+}
+
+func (m *RouterMetrics) Snapshot() map[string]EventTypeStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stats := make(map[string]EventTypeStats, len(m.types))
+	for t, s := range m.types {
+		stats[t] = *s
+	}
+	return stats
+}
+
+// ErrEventInFlight is returned by Dedupe for a copy of an event that is
+// still being handled elsewhere; the copy goes to the retry topics
+var ErrEventInFlight = errors.New("event is being handled by another consumer")
+
+const (
+	dedupeInFlight = "in-flight"
+	dedupeHandled  = "handled"
+	// dedupeLease is how long an event stays claimed while its handler
+	// runs, so a consumer that died mid-event does not block it for ttl
+	dedupeLease = 5 * time.Minute
+)
+
+// Dedupe skips events that were already handled successfully within ttl.
+// An event is claimed in Redis with SETNX before its handler runs and the
+// claim is dropped when the handler fails, so a failed event is still
+// retried.
+func Dedupe(client *redis.Client, ttl time.Duration) Middleware {
+	return func(next EventHandler) EventHandler {
+		return func(ctx context.Context, event *Event) error {
+			key := dedupeKey(event)
+			claimed, err := client.SetNX(ctx, key, dedupeInFlight, min(dedupeLease, ttl)).Result()
+			if err != nil {
+				return fmt.Errorf("dedupe claim failed: %w", err)
+			}
+			if !claimed {
+				state, err := client.Get(ctx, key).Result()
+				if err != nil && !errors.Is(err, redis.Nil) {
+					return fmt.Errorf("dedupe lookup failed: %w", err)
+				}
+				if state != dedupeHandled {
+					// in flight, or the claim just ran out; retry later
+					return fmt.Errorf("%w: %s", ErrEventInFlight, key)
+				}
+				log.Printf("⚠️ Skipping duplicate event %s (%s)", event.EventType, key)
+				return nil
+			}
+
+			if err := next(ctx, event); err != nil {
+				if delErr := client.Del(context.WithoutCancel(ctx), key).Err(); delErr != nil {
+					log.Printf("⚠️ Failed to release dedupe claim %s: %v", key, delErr)
+				}
+				return err
+			}
+			if err := client.Set(context.WithoutCancel(ctx), key, dedupeHandled, ttl).Err(); err != nil {
+				log.Printf("⚠️ Failed to remember handled event %s: %v", key, err)
+			}
+			return nil
+		}
+	}
+}
+
+// dedupeKey identifies an event by its event_id or, for events without
+// one, by the position it was first published at, which stays the same
+// across redeliveries and retry topics
+func dedupeKey(event *Event) string {
+	if event.EventID != "" {
+		return "kf:dedupe:" + event.EventID
+	}
+	origin := OriginOf(event.Message)
+	return fmt.Sprintf("kf:dedupe:%s:%d:%d", origin.Topic, origin.Partition, origin.Offset)
+}
@@ -0,0 +1,133 @@
+package kf
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+func TestDedupe(t *testing.T) {
+	ctx := context.Background()
+	store, client := newFakeRedis(t)
+
+	calls := 0
+	var fail error
+	handler := Dedupe(client, time.Hour)(func(context.Context, *Event) error {
+		calls++
+		return fail
+	})
+	event := &Event{Envelope: Envelope{EventID: "e-1", EventType: "user.created"}}
+	key := "kf:dedupe:e-1"
+
+	// A failed event releases its claim so the retry runs it again
+	fail = errors.New("db down")
+	if err := handler(ctx, event); !errors.Is(err, fail) {
+		t.Fatalf("first delivery = %v, want the handler error", err)
+	}
+	if _, claimed := store.get(key); claimed {
+		t.Fatalf("failed event left its claim %s", key)
+	}
+
+	fail = nil
+	if err := handler(ctx, event); err != nil {
+		t.Fatal(err)
+	}
+	if state, _ := store.get(key); state != dedupeHandled || store.ttl(key) != time.Hour {
+		t.Errorf("after success %s = %q for %s, want %q for the ttl", key, state, store.ttl(key), dedupeHandled)
+	}
+
+	if err := handler(ctx, event); err != nil {
+		t.Fatalf("duplicate = %v, want it skipped", err)
+	}
+	if calls != 2 {
+		t.Errorf("handler ran %d times, want 2", calls)
+	}
+
+	// A copy of an event still being handled elsewhere is retried later
+	store.set("kf:dedupe:e-2", dedupeInFlight)
+	err := handler(ctx, &Event{Envelope: Envelope{EventID: "e-2", EventType: "user.created"}})
+	if !errors.Is(err, ErrEventInFlight) || calls != 2 {
+		t.Errorf("in-flight copy = %v after %d calls, want %v without running the handler", err, calls, ErrEventInFlight)
+	}
+}
+
+func TestDedupeClaimLease(t *testing.T) {
+	store, client := newFakeRedis(t)
+
+	for _, ttl := range []time.Duration{time.Minute, time.Hour} {
+		var lease time.Duration
+		handler := Dedupe(client, ttl)(func(context.Context, *Event) error {
+			lease = store.ttl("kf:dedupe:" + ttl.String())
+			return nil
+		})
+		if err := handler(context.Background(), &Event{Envelope: Envelope{EventID: ttl.String()}}); err != nil {
+			t.Fatal(err)
+		}
+		if want := min(ttl, dedupeLease); lease != want {
+			t.Errorf("claim with ttl %s held for %s, want %s", ttl, lease, want)
+		}
+	}
+}
+
+func TestDedupeKey(t *testing.T) {
+	retried := &sarama.ConsumerMessage{Topic: "orders.retry.1", Partition: 0, Offset: 3, Headers: []*sarama.RecordHeader{
+		{Key: []byte(HeaderOriginalTopic), Value: []byte("orders")},
+		{Key: []byte(HeaderOriginalPartition), Value: []byte("2")},
+		{Key: []byte(HeaderOriginalOffset), Value: []byte("41")},
+	}}
+
+	tests := []struct {
+		name  string
+		event *Event
+		want  string
+	}{
+		{name: "event id", event: &Event{Envelope: Envelope{EventID: "e-1"}, Message: retried}, want: "kf:dedupe:e-1"},
+		{name: "first delivery", event: &Event{Message: &sarama.ConsumerMessage{Topic: "orders", Partition: 2, Offset: 41}}, want: "kf:dedupe:orders:2:41"},
+		{name: "retry topic keeps the origin", event: &Event{Message: retried}, want: "kf:dedupe:orders:2:41"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := dedupeKey(tt.event); got != tt.want {
+				t.Errorf("dedupeKey() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRecovery(t *testing.T) {
+	handler := Recovery()(func(context.Context, *Event) error {
+		panic("nil map")
+	})
+	err := handler(context.Background(), &Event{Envelope: Envelope{EventType: "user.created"}})
+	if err == nil || !strings.Contains(err.Error(), "panic handling event user.created: nil map") {
+		t.Errorf("Recovery() = %v, want the panic as an error", err)
+	}
+}
+
+func TestRouterMetrics(t *testing.T) {
+	var m RouterMetrics
+	handler := m.Middleware()(func(_ context.Context, event *Event) error {
+		if event.AggregateID == "bad" {
+			return errors.New("rejected")
+		}
+		return nil
+	})
+
+	for _, id := range []string{"a", "b", "bad"} {
+		handler(context.Background(), &Event{Envelope: Envelope{EventType: "user.created", AggregateID: id}})
+	}
+	handler(context.Background(), &Event{Envelope: Envelope{EventType: "user.deleted"}})
+
+	stats := m.Snapshot()
+	if got := stats["user.created"]; got.Handled != 2 || got.Failed != 1 {
+		t.Errorf("user.created = %+v, want 2 handled and 1 failed", got)
+	}
+	if got := stats["user.deleted"]; got.Handled != 1 || got.Failed != 0 {
+		t.Errorf("user.deleted = %+v, want 1 handled", got)
+	}
+}
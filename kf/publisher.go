@@ -8,6 +8,7 @@ import (
 	"github.com/IBM/sarama"
 	"github.com/redis/go-redis/v9"
 	"shared/constants"
+	"shared/pkgs/uuids"
 	"shared/utils"
 	"sync"
 	"time"
@@ -15,7 +16,34 @@ import (
 
 type KafkaPublisher struct {
 	producer sarama.SyncProducer
-	redis    *redis.Client
+	async    *AsyncProducer
+	spool    *Spool
+
+	stopReplayer context.CancelFunc
+	replayerDone chan struct{}
+}
+
+// PublisherOption configures InitKafkaPublisher
+type PublisherOption func(*publisherOptions)
+
+type publisherOptions struct {
+	async      *ProducerConfig
+	idempotent bool
+}
+
+// WithIdempotentProducer makes the sync producer idempotent, and the async
+// one when WithAsyncProducer is used too
+func WithIdempotentProducer() PublisherOption {
+	return func(o *publisherOptions) {
+		o.idempotent = true
+	}
+}
+
+// WithAsyncProducer adds an async batched producer used by PublishAsync
+func WithAsyncProducer(producerCfg ProducerConfig) PublisherOption {
+	return func(o *publisherOptions) {
+		o.async = &producerCfg
+	}
 }
 
 var (
@@ -25,21 +53,32 @@ var (
 	ctx          = context.Background()
 )
 
-const (
-	redisTTL = 24 * time.Hour
-)
+// closeFlushTimeout bounds the flush of async messages in CloseKafkaPublisher
+const closeFlushTimeout = 30 * time.Second
 
-// InitKafkaPublisher initializes the singleton publisher manually
-func InitKafkaPublisher(redisClient *redis.Client) error {
+// InitKafkaPublisher initializes the singleton publisher manually. It also
+// starts the replayer delivering spooled messages, which runs until
+// CloseKafkaPublisher.
+func InitKafkaPublisher(redisClient *redis.Client, opts ...PublisherOption) error {
 	pubInitOnce.Do(func() {
-		cfg := &Config{
-			Brokers:  utils.SplitAndTrim(constants.KafkaBrokers),
-			Username: constants.KafkaUser,
-			Password: constants.KafkaPass,
-			GroupID:  "", // not needed for publisher
+		cfg := SecurityFromEnv(constants.KafkaUser, constants.KafkaPass)
+		cfg.Brokers = utils.SplitAndTrim(constants.KafkaBrokers)
+		var options publisherOptions
+		for _, opt := range opts {
+			opt(&options)
 		}
 
-		saramaCfg := newSaramaConfig(cfg)
+		if options.async != nil {
+			cfg.Producer = *options.async
+		}
+		cfg.Producer.Idempotent = cfg.Producer.Idempotent || options.idempotent
+
+		saramaCfg, err := newSaramaConfig(cfg)
+		if err != nil {
+			pubInitErr = err
+			return
+		}
+		cfg.Producer.applyIdempotence(saramaCfg)
 		producer, err := sarama.NewSyncProducer(cfg.Brokers, saramaCfg)
 		if err != nil {
 			pubInitErr = fmt.Errorf("failed to create Kafka producer: %w", err)
@@ -48,12 +87,33 @@ func InitKafkaPublisher(redisClient *redis.Client) error {
 
 		singletonPub = &KafkaPublisher{
 			producer: producer,
-			redis:    redisClient,
+			spool:    NewSpool(redisClient),
+		}
+		if options.async != nil {
+			singletonPub.async, err = NewAsyncProducer(cfg, singletonPub.spool)
+			if err != nil {
+				producer.Close()
+				singletonPub = nil
+				pubInitErr = err
+				return
+			}
 		}
+		singletonPub.startReplayer()
 	})
 	return pubInitErr
 }
 
+// startReplayer runs the spool replayer in the background
+func (kp *KafkaPublisher) startReplayer() {
+	replayCtx, cancel := context.WithCancel(ctx)
+	kp.stopReplayer = cancel
+	kp.replayerDone = make(chan struct{})
+	go func() {
+		defer close(kp.replayerDone)
+		NewSpoolReplayer(kp.spool, kp.producer).Start(replayCtx)
+	}()
+}
+
 // GetKafkaPublisher returns the singleton instance (after Init)
 func GetKafkaPublisher() (*KafkaPublisher, error) {
 	if singletonPub == nil {
@@ -62,22 +122,22 @@ func GetKafkaPublisher() (*KafkaPublisher, error) {
 	return singletonPub, nil
 }
 
-// Publish sends a message to Kafka after caching it in Redis.
-// If Kafka fails, Redis retains the message as fallback for consumer use.
+// Publish sends a message to Kafka. If Kafka cannot be reached after the
+// given retries, the message is appended to the Redis spool and delivered
+// later by the replayer started in InitKafkaPublisher. An error is returned
+// when Kafka rejects the message for good (e.g. ErrMessageSizeTooLarge) or
+// the spool cannot be written either. While the spool holds messages, new
+// ones are spooled behind them to keep their order.
 func (kp *KafkaPublisher) Publish(topic string, key string, data any, retries int) error {
 	value, err := json.Marshal(data)
 	if err != nil {
 		return fmt.Errorf("❌ failed to marshal data: %w", err)
 	}
 
-	redisKey := fmt.Sprintf("kafka:%s:%s", topic, key)
-
-	// Step 1: Cache to Redis
-	if err := kp.redis.Set(ctx, redisKey, value, redisTTL).Err(); err != nil {
-		return fmt.Errorf("❌ failed to cache to Redis: %w", err)
+	if pending, err := kp.spool.Size(ctx); err == nil && pending > 0 {
+		return kp.spool.Add(ctx, topic, key, value, nil)
 	}
 
-	// Step 2: Try Kafka publish
 	for attempt := 0; attempt <= retries; attempt++ {
 		_, _, err = kp.producer.SendMessage(&sarama.ProducerMessage{
 			Topic: topic,
@@ -85,30 +145,83 @@ func (kp *KafkaPublisher) Publish(topic string, key string, data any, retries in
 			Value: sarama.ByteEncoder(value),
 		})
 		if err == nil {
-			// Optionally delete or shorten TTL after Kafka success
-			_ = kp.redis.Expire(ctx, redisKey, 1*time.Hour).Err()
 			return nil
 		}
+		if !spoolable(err) {
+			return fmt.Errorf("❌ failed to publish to Kafka: %w", err)
+		}
 
-		// Optional: backoff logic could go here
+		if attempt < retries {
+			time.Sleep(retryBackoff(attempt+1, 0, 0))
+		}
 	}
 
-	// Step 3: Fallback to DLQ if Kafka fails
-	dlxTopic := fmt.Sprintf("dlq.%s", topic)
-	_, _, dlqErr := kp.producer.SendMessage(&sarama.ProducerMessage{
-		Topic: dlxTopic,
-		Key:   sarama.StringEncoder(key),
-		Value: sarama.ByteEncoder(value),
-	})
-	if dlqErr != nil {
-		return fmt.Errorf("❌ failed to publish to DLQ topic %s: %v (original error: %v)", dlxTopic, dlqErr, err)
+	// Kafka is unreachable: keep the message for the replayer
+	if spoolErr := kp.spool.Add(ctx, topic, key, value, err); spoolErr != nil {
+		return fmt.Errorf("❌ failed to publish to Kafka (%v) and to spool: %w", err, spoolErr)
+	}
+	log.Printf("⚠️ Kafka publish to [%s] failed, message spooled for replay: %v", topic, err)
+	return nil
+}
+
+// PublishAsync queues a message on the async producer and returns at once.
+// The callback and the returned Future report its delivery; a message
+// Kafka rejects is spooled like in Publish. Without WithAsyncProducer the
+// message is published synchronously.
+func (kp *KafkaPublisher) PublishAsync(topic string, key string, data any, callback DeliveryFunc) *Future {
+	value, err := json.Marshal(data)
+	if err != nil {
+		f := newFuture()
+		d := Delivery{Topic: topic, Key: key, Err: fmt.Errorf("❌ failed to marshal data: %w", err)}
+		if callback != nil {
+			callback(d)
+		}
+		f.resolve(d)
+		return f
 	}
 
-	return fmt.Errorf("⚠️ max retries reached. Sent to DLQ topic %s: %w", dlxTopic, err)
+	if kp.async == nil {
+		f := newFuture()
+		d := Delivery{Topic: topic, Key: key, Err: kp.Publish(topic, key, json.RawMessage(value), 3)}
+		if callback != nil {
+			callback(d)
+		}
+		f.resolve(d)
+		return f
+	}
+	return kp.async.Send(topic, key, value, callback)
+}
+
+// Flush waits until every message queued with PublishAsync is delivered
+// or spooled
+func (kp *KafkaPublisher) Flush(ctx context.Context) error {
+	if kp.async == nil {
+		return nil
+	}
+	return kp.async.Flush(ctx)
+}
+
+// SpoolStats reports the spool of the publisher
+func (kp *KafkaPublisher) SpoolStats() SpoolStats {
+	return kp.spool.Metrics.Snapshot()
 }
 
 // CloseKafkaPublisher safely closes the producer
 func CloseKafkaPublisher() error {
+	if singletonPub != nil && singletonPub.async != nil {
+		flushCtx, cancel := context.WithTimeout(ctx, closeFlushTimeout)
+		defer cancel()
+		if err := singletonPub.Flush(flushCtx); err != nil {
+			log.Printf("⚠️ Kafka async messages still in flight at close: %v", err)
+		}
+		if err := singletonPub.async.Close(flushCtx); err != nil {
+			return fmt.Errorf("error closing async Kafka producer: %w", err)
+		}
+	}
+	if singletonPub != nil && singletonPub.stopReplayer != nil {
+		singletonPub.stopReplayer()
+		<-singletonPub.replayerDone
+	}
 	if singletonPub != nil && singletonPub.producer != nil {
 		err := singletonPub.producer.Close()
 		if err != nil {
@@ -130,11 +243,17 @@ func CloseKafkaPublisher() error {
 //
 // The event structure published to Kafka:
 //   {
+//     "event_id": "...",
 //     "event_type": "...",
+//     "schema_version": 1,
 //     "aggregate_id": "...",
 //     "payload": { ... },
 //     "timestamp": 1234567890
 //   }
+//
+// The payload is not checked against Schemas, so schema_version is always
+// 1 and consumers upcast it from there; publish newer versions with
+// PublishTypedEvent.
 func PublishEvent(ctx context.Context, eventType string, aggregateID string, payload map[string]interface{}) error {
 	log.Printf("═══════════════════════════════════════════════════════════════")
 	log.Printf("📤 [EVENT PUBLISH] Publishing event to Kafka")
@@ -170,10 +289,12 @@ func PublishEvent(ctx context.Context, eventType string, aggregateID string, pay
 
 	// Create event message with required structure
 	event := map[string]interface{}{
-		"event_type":   eventType,
-		"aggregate_id": aggregateID,
-		"payload":      payload,
-		"timestamp":    time.Now().Unix(),
+		"event_id":       uuids.NewUUID(),
+		"event_type":     eventType,
+		"schema_version": 1,
+		"aggregate_id":   aggregateID,
+		"payload":        payload,
+		"timestamp":      time.Now().Unix(),
 	}
 
 	// Publish to Kafka topic (event type is the topic name)
@@ -199,6 +320,38 @@ func PublishEvent(ctx context.Context, eventType string, aggregateID string, pay
 	return nil
 }
 
+// PublishTypedEvent publishes a payload whose Go type is registered in
+// Schemas; the event type and schema version come from the registry
+func PublishTypedEvent(ctx context.Context, aggregateID string, payload any) error {
+	eventType, version, err := Schemas.Lookup(payload)
+	if err != nil {
+		return err
+	}
+	if aggregateID == "" {
+		return fmt.Errorf("aggregate_id is required")
+	}
+
+	kafkaPublisher, err := GetKafkaPublisher()
+	if err != nil {
+		return fmt.Errorf("failed to get Kafka publisher: %w", err)
+	}
+
+	event := map[string]interface{}{
+		"event_id":       uuids.NewUUID(),
+		"event_type":     eventType,
+		"schema_version": version,
+		"aggregate_id":   aggregateID,
+		"payload":        payload,
+		"timestamp":      time.Now().Unix(),
+	}
+	if err := kafkaPublisher.Publish(eventType, aggregateID, event, 3); err != nil {
+		log.Printf("❌ [EVENT PUBLISH] Failed to publish %s v%d to Kafka: %v", eventType, version, err)
+		return fmt.Errorf("failed to publish to Kafka: %w", err)
+	}
+	log.Printf("✅ [EVENT PUBLISH] %s v%d published, aggregate_id=%s", eventType, version, aggregateID)
+	return nil
+}
+
 // getPayloadKeys is a helper function to extract keys from a map for debugging
 func getPayloadKeys(payload map[string]interface{}) []string {
 	if payload == nil {
@@ -0,0 +1,285 @@
+package kf
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// fakeRedis is an in-memory Redis speaking just enough RESP for the
+// commands kf sends. Expirations are recorded but never applied.
+type fakeRedis struct {
+	mu      sync.Mutex
+	strings map[string]string
+	ttls    map[string]time.Duration
+	streams map[string][]streamEntry
+	hashes  map[string]map[string]int64
+	lastID  int64
+}
+
+type streamEntry struct {
+	id     string
+	fields []string // field, value, field, value...
+}
+
+func newFakeRedis(t *testing.T) (*fakeRedis, *redis.Client) {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeRedis{
+		strings: map[string]string{},
+		ttls:    map[string]time.Duration{},
+		streams: map[string][]streamEntry{},
+		hashes:  map[string]map[string]int64{},
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+
+	client := redis.NewClient(&redis.Options{Addr: ln.Addr().String(), DisableIdentity: true})
+	t.Cleanup(func() {
+		client.Close()
+		ln.Close()
+	})
+	return f, client
+}
+
+func (f *fakeRedis) get(key string) (string, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	v, ok := f.strings[key]
+	return v, ok
+}
+
+func (f *fakeRedis) set(key, value string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.strings[key] = value
+}
+
+func (f *fakeRedis) ttl(key string) time.Duration {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.ttls[key]
+}
+
+// stream returns the entries of a stream with their fields as a map
+func (f *fakeRedis) stream(key string) []map[string]string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var entries []map[string]string
+	for _, e := range f.streams[key] {
+		fields := map[string]string{}
+		for i := 0; i < len(e.fields); i += 2 {
+			fields[e.fields[i]] = e.fields[i+1]
+		}
+		entries = append(entries, fields)
+	}
+	return entries
+}
+
+func (f *fakeRedis) hash(key string) map[string]int64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return maps.Clone(f.hashes[key])
+}
+
+func (f *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	var queued [][]string // commands of an open MULTI
+	for {
+		args, err := readRESPCommand(r)
+		if err != nil {
+			return
+		}
+
+		var reply string
+		switch {
+		case strings.EqualFold(args[0], "MULTI"):
+			queued = [][]string{}
+			reply = respOK()
+		case strings.EqualFold(args[0], "EXEC"):
+			f.mu.Lock()
+			reply = fmt.Sprintf("*%d\r\n", len(queued))
+			for _, cmd := range queued {
+				reply += f.do(cmd)
+			}
+			f.mu.Unlock()
+			queued = nil
+		case queued != nil:
+			queued = append(queued, args)
+			reply = "+QUEUED\r\n"
+		default:
+			f.mu.Lock()
+			reply = f.do(args)
+			f.mu.Unlock()
+		}
+		if _, err := io.WriteString(conn, reply); err != nil {
+			return
+		}
+	}
+}
+
+func readRESPCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "*")))
+	if err != nil {
+		return nil, err
+	}
+	args := make([]string, n)
+	for i := range args {
+		if line, err = r.ReadString('\n'); err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "$")))
+		if err != nil {
+			return nil, err
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		args[i] = string(buf[:size])
+	}
+	return args, nil
+}
+
+func respOK() string           { return "+OK\r\n" }
+func respNull() string         { return "$-1\r\n" }
+func respInt(n int64) string   { return fmt.Sprintf(":%d\r\n", n) }
+func respBulk(s string) string { return fmt.Sprintf("$%d\r\n%s\r\n", len(s), s) }
+func respError(format string, a ...any) string {
+	return "-ERR " + fmt.Sprintf(format, a...) + "\r\n"
+}
+
+// do runs one command with f.mu held
+func (f *fakeRedis) do(args []string) string {
+	switch strings.ToUpper(args[0]) {
+	case "SET":
+		key, value := args[1], args[2]
+		var nx bool
+		var ttl time.Duration
+		for i := 3; i < len(args); i++ {
+			switch strings.ToUpper(args[i]) {
+			case "NX":
+				nx = true
+			case "EX", "PX":
+				n, _ := strconv.ParseInt(args[i+1], 10, 64)
+				ttl = time.Duration(n) * time.Millisecond
+				if strings.EqualFold(args[i], "EX") {
+					ttl = time.Duration(n) * time.Second
+				}
+				i++
+			}
+		}
+		if _, exists := f.strings[key]; exists && nx {
+			return respNull()
+		}
+		f.strings[key] = value
+		f.ttls[key] = ttl
+		return respOK()
+	case "GET":
+		v, ok := f.strings[args[1]]
+		if !ok {
+			return respNull()
+		}
+		return respBulk(v)
+	case "DEL":
+		var n int64
+		for _, key := range args[1:] {
+			if _, ok := f.strings[key]; ok {
+				delete(f.strings, key)
+				delete(f.ttls, key)
+				n++
+			}
+		}
+		return respInt(n)
+	case "XADD":
+		f.lastID++
+		id := fmt.Sprintf("%d-0", f.lastID)
+		f.streams[args[1]] = append(f.streams[args[1]], streamEntry{id: id, fields: args[3:]})
+		return respBulk(id)
+	case "XLEN":
+		return respInt(int64(len(f.streams[args[1]])))
+	case "XRANGE":
+		entries := f.streams[args[1]]
+		if len(args) > 5 && strings.EqualFold(args[4], "COUNT") {
+			n, _ := strconv.Atoi(args[5])
+			entries = entries[:min(n, len(entries))]
+		}
+		reply := fmt.Sprintf("*%d\r\n", len(entries))
+		for _, e := range entries {
+			reply += "*2\r\n" + respBulk(e.id) + fmt.Sprintf("*%d\r\n", len(e.fields))
+			for _, field := range e.fields {
+				reply += respBulk(field)
+			}
+		}
+		return reply
+	case "XDEL":
+		var n int64
+		for _, id := range args[2:] {
+			entries := f.streams[args[1]]
+			if i := slices.IndexFunc(entries, func(e streamEntry) bool { return e.id == id }); i >= 0 {
+				f.streams[args[1]] = slices.Delete(entries, i, i+1)
+				n++
+			}
+		}
+		return respInt(n)
+	case "HINCRBY":
+		if f.hashes[args[1]] == nil {
+			f.hashes[args[1]] = map[string]int64{}
+		}
+		n, _ := strconv.ParseInt(args[3], 10, 64)
+		f.hashes[args[1]][args[2]] += n
+		return respInt(f.hashes[args[1]][args[2]])
+	case "HDEL":
+		var n int64
+		for _, field := range args[2:] {
+			if _, ok := f.hashes[args[1]][field]; ok {
+				delete(f.hashes[args[1]], field)
+				n++
+			}
+		}
+		return respInt(n)
+	case "EVALSHA":
+		return "-NOSCRIPT No matching script\r\n"
+	case "EVAL":
+		// Only the owner-checked lock scripts of the spool are known
+		script, key, owner := args[1], args[3], args[4]
+		if f.strings[key] != owner {
+			return respInt(0)
+		}
+		if strings.Contains(script, "PEXPIRE") {
+			n, _ := strconv.ParseInt(args[5], 10, 64)
+			f.ttls[key] = time.Duration(n) * time.Millisecond
+		} else {
+			delete(f.strings, key)
+			delete(f.ttls, key)
+		}
+		return respInt(1)
+	}
+	return respError("unknown command '%s'", args[0])
+}
@@ -1 +1,191 @@
 package kf
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// Headers set on messages sent to retry and dead-letter topics
+const (
+	HeaderOriginalTopic     = "x-original-topic"
+	HeaderOriginalPartition = "x-original-partition"
+	HeaderOriginalOffset    = "x-original-offset"
+	HeaderError             = "x-error"
+	HeaderAttempt           = "x-attempt"
+	HeaderNotBefore         = "x-not-before" // unix milliseconds
+)
+
+const defaultDLQPrefix = "dlq."
+
+// RetryTopic is the tier a message goes to after its attempt-th failure
+func RetryTopic(topic string, attempt int) string {
+	return fmt.Sprintf("%s.retry.%d", topic, attempt)
+}
+
+// Enabled reports whether failed messages are routed at all: retry tiers
+// or a dead-letter prefix must be configured. Otherwise a failed message is
+// left unmarked and delivered again once the consumer rejoins.
+func (r KafkaRetry) Enabled() bool {
+	return r.MaxRetryCount > 0 || r.DLQPrefix != ""
+}
+
+// DLQTopic is the dead-letter topic of topic
+func (r KafkaRetry) DLQTopic(topic string) string {
+	prefix := r.DLQPrefix
+	if prefix == "" {
+		prefix = defaultDLQPrefix
+	}
+	return prefix + topic
+}
+
+// Delay is how long a message waits in the tier of the given attempt.
+// It doubles with every tier, starting at DelayMs.
+func (r KafkaRetry) Delay(attempt int) time.Duration {
+	if r.DelayMs <= 0 || attempt < 1 {
+		return 0
+	}
+	return time.Duration(r.DelayMs) * time.Millisecond << (attempt - 1)
+}
+
+// RetryTopics lists the retry tiers of topics, which the consumer
+// subscribes to alongside them
+func (r KafkaRetry) RetryTopics(topics []string) []string {
+	var tiers []string
+	for _, topic := range topics {
+		for attempt := 1; attempt <= r.MaxRetryCount; attempt++ {
+			tiers = append(tiers, RetryTopic(topic, attempt))
+		}
+	}
+	return tiers
+}
+
+// Retrier routes messages whose handler failed to the next retry tier, or
+// to the dead-letter topic once MaxRetryCount retries have failed
+type Retrier struct {
+	producer sarama.SyncProducer
+	retry    KafkaRetry
+}
+
+// NewRetrier creates a retrier publishing through producer
+func NewRetrier(producer sarama.SyncProducer, retry KafkaRetry) *Retrier {
+	return &Retrier{producer: producer, retry: retry}
+}
+
+// Route sends a failed message to its next destination and returns the
+// topic it was sent to
+func (r *Retrier) Route(message *sarama.ConsumerMessage, handlerErr error) (string, error) {
+	origin := OriginOf(message)
+	attempt := Attempt(message) + 1
+
+	topic := r.retry.DLQTopic(origin.Topic)
+	var notBefore time.Time
+	if attempt <= r.retry.MaxRetryCount {
+		topic = RetryTopic(origin.Topic, attempt)
+		notBefore = time.Now().Add(r.retry.Delay(attempt))
+	}
+
+	headers := []sarama.RecordHeader{
+		{Key: []byte(HeaderOriginalTopic), Value: []byte(origin.Topic)},
+		{Key: []byte(HeaderOriginalPartition), Value: []byte(strconv.Itoa(int(origin.Partition)))},
+		{Key: []byte(HeaderOriginalOffset), Value: []byte(strconv.FormatInt(origin.Offset, 10))},
+		{Key: []byte(HeaderError), Value: []byte(handlerErr.Error())},
+		{Key: []byte(HeaderAttempt), Value: []byte(strconv.Itoa(attempt))},
+	}
+	if !notBefore.IsZero() {
+		headers = append(headers, sarama.RecordHeader{Key: []byte(HeaderNotBefore), Value: []byte(strconv.FormatInt(notBefore.UnixMilli(), 10))})
+	}
+	// Keep application headers, replacing the ones set above
+	for _, h := range message.Headers {
+		if h != nil && !isRetryHeader(string(h.Key)) {
+			headers = append(headers, *h)
+		}
+	}
+
+	_, _, err := r.producer.SendMessage(&sarama.ProducerMessage{
+		Topic:   topic,
+		Key:     sarama.ByteEncoder(message.Key),
+		Value:   sarama.ByteEncoder(message.Value),
+		Headers: headers,
+	})
+	if err != nil {
+		return topic, fmt.Errorf("failed to route message to %s: %w", topic, err)
+	}
+	return topic, nil
+}
+
+// Origin identifies where a message was first consumed from
+type Origin struct {
+	Topic     string
+	Partition int32
+	Offset    int64
+}
+
+// OriginOf returns the original position of a message taken from a retry
+// or dead-letter topic, or the message's own position otherwise
+func OriginOf(message *sarama.ConsumerMessage) Origin {
+	origin := Origin{Topic: message.Topic, Partition: message.Partition, Offset: message.Offset}
+	topic := Header(message, HeaderOriginalTopic)
+	if topic == "" {
+		return origin
+	}
+	origin.Topic = topic
+	if p, err := strconv.ParseInt(Header(message, HeaderOriginalPartition), 10, 32); err == nil {
+		origin.Partition = int32(p)
+	}
+	if o, err := strconv.ParseInt(Header(message, HeaderOriginalOffset), 10, 64); err == nil {
+		origin.Offset = o
+	}
+	return origin
+}
+
+// Attempt returns how many times a message has failed so far
+func Attempt(message *sarama.ConsumerMessage) int {
+	n, _ := strconv.Atoi(Header(message, HeaderAttempt))
+	return n
+}
+
+// Header returns the value of the first header named key
+func Header(message *sarama.ConsumerMessage, key string) string {
+	for _, h := range message.Headers {
+		if h != nil && string(h.Key) == key {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
+
+func isRetryHeader(key string) bool {
+	switch key {
+	case HeaderOriginalTopic, HeaderOriginalPartition, HeaderOriginalOffset, HeaderError, HeaderAttempt, HeaderNotBefore:
+		return true
+	}
+	return false
+}
+
+// waitNotBefore holds a retried message until its delay has passed. It
+// returns false when ctx ends first.
+func waitNotBefore(ctx context.Context, message *sarama.ConsumerMessage) bool {
+	ms, err := strconv.ParseInt(Header(message, HeaderNotBefore), 10, 64)
+	if err != nil {
+		return true
+	}
+	wait := time.Until(time.UnixMilli(ms))
+	if wait <= 0 {
+		return true
+	}
+
+	log.Printf("⏳ Delaying retry of topic [%s] offset %d for %s", message.Topic, message.Offset, wait)
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,180 @@
+package kf
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// ErrUnknownSchema is returned for payload types or versions that were
+// not registered
+var ErrUnknownSchema = errors.New("unknown event schema")
+
+// Upcaster migrates a payload from one schema version to the next
+type Upcaster func(payload map[string]interface{}) (map[string]interface{}, error)
+
+// SchemaRegistry maps event types and schema versions to Go types, and
+// holds the upcasters that bring old payloads to the current version.
+// The highest registered version of an event type is its current one.
+type SchemaRegistry struct {
+	mu        sync.RWMutex
+	types     map[string]map[int]reflect.Type
+	current   map[string]int
+	byGoType  map[reflect.Type]schemaKey
+	upcasters map[string]map[int]Upcaster
+}
+
+type schemaKey struct {
+	eventType string
+	version   int
+}
+
+// Schemas is the registry used by PublishTypedEvent
+var Schemas = NewSchemaRegistry()
+
+// NewSchemaRegistry creates an empty registry
+func NewSchemaRegistry() *SchemaRegistry {
+	return &SchemaRegistry{
+		types:     map[string]map[int]reflect.Type{},
+		current:   map[string]int{},
+		byGoType:  map[reflect.Type]schemaKey{},
+		upcasters: map[string]map[int]Upcaster{},
+	}
+}
+
+// RegisterSchema registers T as the payload of version of eventType.
+// A Go type identifies a single version, as PublishTypedEvent stamps it
+// from the type, so every version needs its own type.
+func RegisterSchema[T any](r *SchemaRegistry, eventType string, version int) error {
+	t := reflect.TypeFor[T]()
+	if version < 1 {
+		return fmt.Errorf("schema %s v%d: version must be 1 or greater", eventType, version)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if key, ok := r.byGoType[t]; ok {
+		return fmt.Errorf("schema %s v%d: %v is already registered for %s v%d", eventType, version, t, key.eventType, key.version)
+	}
+	if prev, ok := r.types[eventType][version]; ok {
+		return fmt.Errorf("schema %s v%d: already registered with %v", eventType, version, prev)
+	}
+	if r.types[eventType] == nil {
+		r.types[eventType] = map[int]reflect.Type{}
+	}
+	r.types[eventType][version] = t
+	r.byGoType[t] = schemaKey{eventType: eventType, version: version}
+	if version > r.current[eventType] {
+		r.current[eventType] = version
+	}
+	return nil
+}
+
+// AddUpcaster registers the migration of eventType payloads from version
+// from to from+1
+func (r *SchemaRegistry) AddUpcaster(eventType string, from int, up Upcaster) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.upcasters[eventType] == nil {
+		r.upcasters[eventType] = map[int]Upcaster{}
+	}
+	r.upcasters[eventType][from] = up
+}
+
+// Current returns the current schema version of eventType, 1 when none
+// was registered
+func (r *SchemaRegistry) Current(eventType string) int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if v := r.current[eventType]; v > 0 {
+		return v
+	}
+	return 1
+}
+
+// Lookup returns the event type and version payload was registered for
+func (r *SchemaRegistry) Lookup(payload any) (string, int, error) {
+	t := reflect.TypeOf(payload)
+	if t != nil && t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	key, ok := r.byGoType[t]
+	if !ok {
+		return "", 0, fmt.Errorf("%w: payload type %v", ErrUnknownSchema, t)
+	}
+	return key.eventType, key.version, nil
+}
+
+// Upcast migrates the payload of event to the current version of its
+// type. Events without schema_version are version 1; event types without
+// registered schemas are left alone.
+func (r *SchemaRegistry) Upcast(event *Event) error {
+	if event.SchemaVersion == 0 {
+		event.SchemaVersion = 1
+	}
+
+	r.mu.RLock()
+	current, known := r.current[event.EventType]
+	upcasters := r.upcasters[event.EventType]
+	r.mu.RUnlock()
+
+	if !known || event.SchemaVersion >= current {
+		return nil
+	}
+
+	var payload map[string]interface{}
+	if len(event.Payload) > 0 {
+		if err := json.Unmarshal(event.Payload, &payload); err != nil {
+			return fmt.Errorf("failed to decode %s v%d payload: %w", event.EventType, event.SchemaVersion, err)
+		}
+	}
+
+	for v := event.SchemaVersion; v < current; v++ {
+		up, ok := upcasters[v]
+		if !ok {
+			return fmt.Errorf("%w: no upcaster for %s v%d", ErrUnknownSchema, event.EventType, v)
+		}
+		var err error
+		if payload, err = up(payload); err != nil {
+			return fmt.Errorf("failed to upcast %s v%d: %w", event.EventType, v, err)
+		}
+	}
+
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	event.Payload = raw
+	event.SchemaVersion = current
+	return nil
+}
+
+// Decode upcasts event and decodes its payload into a new value of the
+// Go type registered for the current version
+func (r *SchemaRegistry) Decode(event *Event) (any, error) {
+	if err := r.Upcast(event); err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	t, ok := r.types[event.EventType][event.SchemaVersion]
+	r.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %s v%d", ErrUnknownSchema, event.EventType, event.SchemaVersion)
+	}
+
+	v := reflect.New(t)
+	if err := DecodePayload(event, v.Interface()); err != nil {
+		return nil, err
+	}
+	return v.Elem().Interface(), nil
+}
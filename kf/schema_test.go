@@ -0,0 +1,165 @@
+package kf
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+type userCreatedV1 struct {
+	Name string `json:"name"`
+}
+
+type userCreatedV2 struct {
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+}
+
+type userCreatedV3 struct {
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	Active    bool   `json:"active"`
+}
+
+func newUserSchemas(t *testing.T, withV2Upcaster bool) *SchemaRegistry {
+	t.Helper()
+
+	r := NewSchemaRegistry()
+	for _, err := range []error{
+		RegisterSchema[userCreatedV1](r, "user.created", 1),
+		RegisterSchema[userCreatedV2](r, "user.created", 2),
+		RegisterSchema[userCreatedV3](r, "user.created", 3),
+	} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r.AddUpcaster("user.created", 1, func(p map[string]interface{}) (map[string]interface{}, error) {
+		name, _ := p["name"].(string)
+		if name == "" {
+			return nil, errEmptyName
+		}
+		return map[string]interface{}{"first_name": name, "last_name": ""}, nil
+	})
+	if withV2Upcaster {
+		r.AddUpcaster("user.created", 2, func(p map[string]interface{}) (map[string]interface{}, error) {
+			p["active"] = true
+			return p, nil
+		})
+	}
+	return r
+}
+
+func TestSchemaRegistryUpcast(t *testing.T) {
+	tests := []struct {
+		name        string
+		noV2        bool
+		event       Envelope
+		wantVersion int
+		wantPayload map[string]interface{}
+		wantErr     error
+	}{
+		{
+			name:        "v1 through every upcaster",
+			event:       Envelope{EventType: "user.created", SchemaVersion: 1, Payload: json.RawMessage(`{"name":"ada"}`)},
+			wantVersion: 3,
+			wantPayload: map[string]interface{}{"first_name": "ada", "last_name": "", "active": true},
+		},
+		{
+			name:        "missing version is v1",
+			event:       Envelope{EventType: "user.created", Payload: json.RawMessage(`{"name":"ada"}`)},
+			wantVersion: 3,
+			wantPayload: map[string]interface{}{"first_name": "ada", "last_name": "", "active": true},
+		},
+		{
+			name:        "v2 skips the first upcaster",
+			event:       Envelope{EventType: "user.created", SchemaVersion: 2, Payload: json.RawMessage(`{"first_name":"ada","last_name":"l"}`)},
+			wantVersion: 3,
+			wantPayload: map[string]interface{}{"first_name": "ada", "last_name": "l", "active": true},
+		},
+		{
+			name:        "current version is left alone",
+			event:       Envelope{EventType: "user.created", SchemaVersion: 3, Payload: json.RawMessage(`{"first_name":"ada"}`)},
+			wantVersion: 3,
+			wantPayload: map[string]interface{}{"first_name": "ada"},
+		},
+		{
+			name:        "unregistered event type is left alone",
+			event:       Envelope{EventType: "order.placed", Payload: json.RawMessage(`{"id":"1"}`)},
+			wantVersion: 1,
+			wantPayload: map[string]interface{}{"id": "1"},
+		},
+		{
+			name:    "gap in the chain",
+			noV2:    true,
+			event:   Envelope{EventType: "user.created", SchemaVersion: 1, Payload: json.RawMessage(`{"name":"ada"}`)},
+			wantErr: ErrUnknownSchema,
+		},
+		{
+			name:    "failing upcaster",
+			event:   Envelope{EventType: "user.created", SchemaVersion: 1, Payload: json.RawMessage(`{}`)},
+			wantErr: errEmptyName,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newUserSchemas(t, !tt.noV2)
+			event := &Event{Envelope: tt.event}
+
+			err := r.Upcast(event)
+			switch {
+			case tt.wantErr != nil:
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Upcast() = %v, want %v", err, tt.wantErr)
+				}
+				return
+			case err != nil:
+				t.Fatalf("Upcast() = %v", err)
+			}
+
+			if event.SchemaVersion != tt.wantVersion {
+				t.Errorf("SchemaVersion = %d, want %d", event.SchemaVersion, tt.wantVersion)
+			}
+			var payload map[string]interface{}
+			if err := json.Unmarshal(event.Payload, &payload); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(payload, tt.wantPayload) {
+				t.Errorf("payload = %v, want %v", payload, tt.wantPayload)
+			}
+		})
+	}
+}
+
+var errEmptyName = errors.New("name is empty")
+
+func TestRegisterSchemaRejectsDuplicates(t *testing.T) {
+	r := NewSchemaRegistry()
+	if err := RegisterSchema[userCreatedV1](r, "user.created", 1); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		register func() error
+	}{
+		{"same type, other version", func() error { return RegisterSchema[userCreatedV1](r, "user.created", 2) }},
+		{"same type, other event", func() error { return RegisterSchema[userCreatedV1](r, "user.renamed", 1) }},
+		{"same version, other type", func() error { return RegisterSchema[userCreatedV2](r, "user.created", 1) }},
+		{"version 0", func() error { return RegisterSchema[userCreatedV3](r, "user.created", 0) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.register(); err == nil {
+				t.Fatal("RegisterSchema() = nil, want an error")
+			}
+		})
+	}
+
+	if eventType, version, err := r.Lookup(userCreatedV1{}); err != nil || eventType != "user.created" || version != 1 {
+		t.Errorf("Lookup() = %s, %d, %v; want user.created, 1", eventType, version, err)
+	}
+}
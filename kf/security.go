@@ -0,0 +1,138 @@
+package kf
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/IBM/sarama"
+	"github.com/xdg-go/scram"
+
+	"shared/constants"
+)
+
+// SASL mechanisms accepted in Config.SASLMechanism
+const (
+	SASLPlain       = sarama.SASLTypePlaintext
+	SASLScramSHA256 = sarama.SASLTypeSCRAMSHA256
+	SASLScramSHA512 = sarama.SASLTypeSCRAMSHA512
+)
+
+// SecurityFromEnv returns a Config holding the given credentials and the
+// SASL/TLS settings of the KAFKA_* environment. Without a username and
+// KAFKA_SASL_ENABLE the connection is unauthenticated (local mode).
+func SecurityFromEnv(username, password string) *Config {
+	cfg := &Config{
+		Username:   username,
+		Password:   password,
+		SASLEnable: username != "",
+	}
+	if v, err := strconv.ParseBool(constants.KafkaSASLEnable); err == nil {
+		cfg.SASLEnable = v
+	}
+	cfg.SASLMechanism = constants.KafkaSASLMechanism
+	cfg.TLSEnable, _ = strconv.ParseBool(constants.KafkaTLSEnable)
+	cfg.TLSCAFile = constants.KafkaTLSCAFile
+	cfg.TLSCertFile = constants.KafkaTLSCertFile
+	cfg.TLSKeyFile = constants.KafkaTLSKeyFile
+	cfg.TLSSkipVerify, _ = strconv.ParseBool(constants.KafkaTLSSkipVerify)
+	return cfg
+}
+
+// ApplySecurity sets the SASL and TLS options of c on a sarama config
+func (c *Config) ApplySecurity(sc *sarama.Config) error {
+	sc.Net.SASL.Enable = c.SASLEnable
+	if c.SASLEnable {
+		sc.Net.SASL.User = c.Username
+		sc.Net.SASL.Password = c.Password
+		sc.Net.SASL.Handshake = true
+
+		switch strings.ToUpper(c.SASLMechanism) {
+		case "", SASLPlain:
+			sc.Net.SASL.Mechanism = sarama.SASLTypePlaintext
+		case SASLScramSHA256:
+			sc.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA256
+			sc.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient {
+				return &scramClient{HashGeneratorFcn: sha256.New}
+			}
+		case SASLScramSHA512:
+			sc.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA512
+			sc.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient {
+				return &scramClient{HashGeneratorFcn: sha512.New}
+			}
+		default:
+			return fmt.Errorf("unsupported SASL mechanism %q", c.SASLMechanism)
+		}
+	}
+
+	sc.Net.TLS.Enable = c.TLSEnable
+	if c.TLSEnable {
+		tlsConfig, err := c.tlsConfig()
+		if err != nil {
+			return err
+		}
+		sc.Net.TLS.Config = tlsConfig
+	}
+	return nil
+}
+
+// tlsConfig builds the TLS client config from the CA and client
+// certificate files
+func (c *Config) tlsConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: c.TLSSkipVerify,
+	}
+
+	if c.TLSCAFile != "" {
+		pem, err := os.ReadFile(c.TLSCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read Kafka CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in Kafka CA file %s", c.TLSCAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if c.TLSCertFile != "" || c.TLSKeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.TLSCertFile, c.TLSKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load Kafka client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+// scramClient implements sarama.SCRAMClient on top of xdg-go/scram
+type scramClient struct {
+	*scram.Client
+	*scram.ClientConversation
+	scram.HashGeneratorFcn
+}
+
+func (x *scramClient) Begin(userName, password, authzID string) error {
+	client, err := x.HashGeneratorFcn.NewClient(userName, password, authzID)
+	if err != nil {
+		return err
+	}
+	x.Client = client
+	x.ClientConversation = client.NewConversation()
+	return nil
+}
+
+func (x *scramClient) Step(challenge string) (string, error) {
+	return x.ClientConversation.Step(challenge)
+}
+
+func (x *scramClient) Done() bool {
+	return x.ClientConversation.Done()
+}
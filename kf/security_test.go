@@ -0,0 +1,174 @@
+package kf
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+func TestApplySecuritySASL(t *testing.T) {
+	tests := []struct {
+		name          string
+		cfg           Config
+		wantMechanism sarama.SASLMechanism
+		wantSCRAM     bool
+		wantErr       string
+	}{
+		{name: "disabled", cfg: Config{SASLMechanism: "KERBEROS"}},
+		{name: "plain by default", cfg: Config{SASLEnable: true, Username: "u", Password: "p"}, wantMechanism: sarama.SASLTypePlaintext},
+		{name: "plain", cfg: Config{SASLEnable: true, Username: "u", Password: "p", SASLMechanism: "plain"}, wantMechanism: sarama.SASLTypePlaintext},
+		{name: "scram sha-256", cfg: Config{SASLEnable: true, Username: "u", Password: "p", SASLMechanism: "scram-sha-256"}, wantMechanism: sarama.SASLTypeSCRAMSHA256, wantSCRAM: true},
+		{name: "scram sha-512", cfg: Config{SASLEnable: true, Username: "u", Password: "p", SASLMechanism: SASLScramSHA512}, wantMechanism: sarama.SASLTypeSCRAMSHA512, wantSCRAM: true},
+		{name: "unknown mechanism", cfg: Config{SASLEnable: true, Username: "u", Password: "p", SASLMechanism: "GSSAPI"}, wantErr: `unsupported SASL mechanism "GSSAPI"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sc := sarama.NewConfig()
+			err := tt.cfg.ApplySecurity(sc)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ApplySecurity() = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if sc.Net.SASL.Enable != tt.cfg.SASLEnable {
+				t.Errorf("SASL enabled = %v, want %v", sc.Net.SASL.Enable, tt.cfg.SASLEnable)
+			}
+			if !tt.cfg.SASLEnable {
+				return
+			}
+			if sc.Net.SASL.Mechanism != tt.wantMechanism || sc.Net.SASL.User != "u" || sc.Net.SASL.Password != "p" || !sc.Net.SASL.Handshake {
+				t.Errorf("SASL = %+v", sc.Net.SASL)
+			}
+			if (sc.Net.SASL.SCRAMClientGeneratorFunc != nil) != tt.wantSCRAM {
+				t.Fatalf("SCRAM client generator set = %v, want %v", sc.Net.SASL.SCRAMClientGeneratorFunc != nil, tt.wantSCRAM)
+			}
+			if tt.wantSCRAM {
+				client := sc.Net.SASL.SCRAMClientGeneratorFunc()
+				if err := client.Begin("u", "p", ""); err != nil {
+					t.Fatal(err)
+				}
+				first, err := client.Step("")
+				if err != nil || !strings.HasPrefix(first, "n,,n=u,r=") || client.Done() {
+					t.Errorf("first SCRAM message = %q, %v", first, err)
+				}
+			}
+		})
+	}
+}
+
+func TestApplySecurityTLS(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeTestCertificate(t, dir)
+	emptyFile := filepath.Join(dir, "empty.pem")
+	if err := os.WriteFile(emptyFile, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		cfg        Config
+		wantRoots  bool
+		wantClient bool
+		wantErr    string
+	}{
+		{name: "disabled", cfg: Config{TLSCAFile: filepath.Join(dir, "missing.pem")}},
+		{name: "system roots", cfg: Config{TLSEnable: true}},
+		{name: "skip verify", cfg: Config{TLSEnable: true, TLSSkipVerify: true}},
+		{name: "custom CA", cfg: Config{TLSEnable: true, TLSCAFile: certFile}, wantRoots: true},
+		{name: "client certificate", cfg: Config{TLSEnable: true, TLSCAFile: certFile, TLSCertFile: certFile, TLSKeyFile: keyFile}, wantRoots: true, wantClient: true},
+		{name: "missing CA file", cfg: Config{TLSEnable: true, TLSCAFile: filepath.Join(dir, "missing.pem")}, wantErr: "failed to read Kafka CA file"},
+		{name: "CA file without certificates", cfg: Config{TLSEnable: true, TLSCAFile: emptyFile}, wantErr: "no certificates found in Kafka CA file"},
+		{name: "certificate without key", cfg: Config{TLSEnable: true, TLSCertFile: certFile}, wantErr: "failed to load Kafka client certificate"},
+		{name: "key not matching", cfg: Config{TLSEnable: true, TLSCertFile: certFile, TLSKeyFile: emptyFile}, wantErr: "failed to load Kafka client certificate"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sc := sarama.NewConfig()
+			err := tt.cfg.ApplySecurity(sc)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ApplySecurity() = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if sc.Net.TLS.Enable != tt.cfg.TLSEnable {
+				t.Errorf("TLS enabled = %v, want %v", sc.Net.TLS.Enable, tt.cfg.TLSEnable)
+			}
+			if !tt.cfg.TLSEnable {
+				if sc.Net.TLS.Config != nil {
+					t.Errorf("TLS config set while TLS is disabled")
+				}
+				return
+			}
+			tc := sc.Net.TLS.Config
+			if tc.MinVersion != tls.VersionTLS12 || tc.InsecureSkipVerify != tt.cfg.TLSSkipVerify {
+				t.Errorf("TLS min version %x, skip verify %v", tc.MinVersion, tc.InsecureSkipVerify)
+			}
+			if (tc.RootCAs != nil) != tt.wantRoots {
+				t.Errorf("custom roots set = %v, want %v", tc.RootCAs != nil, tt.wantRoots)
+			}
+			if (len(tc.Certificates) == 1) != tt.wantClient {
+				t.Errorf("client certificates = %d, want one %v", len(tc.Certificates), tt.wantClient)
+			}
+		})
+	}
+}
+
+// writeTestCertificate writes a self-signed certificate and its key to dir
+func writeTestCertificate(t *testing.T, dir string) (certFile, keyFile string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "kafka-test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile = filepath.Join(dir, "cert.pem")
+	keyFile = filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
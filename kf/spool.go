@@ -0,0 +1,332 @@
+package kf
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/redis/go-redis/v9"
+	"shared/pkgs/uuids"
+)
+
+const (
+	defaultSpoolStream      = "kafka:spool"
+	spoolLockTTL            = time.Minute
+	defaultSpoolMaxAttempts = 10
+)
+
+// errInvalidSpoolEntry is returned for entries no replay can deliver
+var errInvalidSpoolEntry = errors.New("invalid spooled entry")
+
+var (
+	// releaseSpoolLock deletes the replay lock only if the caller still owns it
+	releaseSpoolLock = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+	// extendSpoolLock renews the replay lock only if the caller still owns it
+	extendSpoolLock = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0`)
+)
+
+// spoolable reports whether a message that failed with err may be spooled:
+// Kafka was unreachable or answered with a retriable error. Errors a replay
+// would hit again, such as ErrMessageSizeTooLarge or an invalid topic, are
+// returned to the caller instead.
+func spoolable(err error) bool {
+	var kerr sarama.KError
+	if errors.As(err, &kerr) {
+		switch kerr {
+		case sarama.ErrUnknownTopicOrPartition,
+			sarama.ErrLeaderNotAvailable,
+			sarama.ErrNotLeaderForPartition,
+			sarama.ErrRequestTimedOut,
+			sarama.ErrBrokerNotAvailable,
+			sarama.ErrReplicaNotAvailable,
+			sarama.ErrNetworkException,
+			sarama.ErrNotEnoughReplicas,
+			sarama.ErrNotEnoughReplicasAfterAppend,
+			sarama.ErrKafkaStorageError,
+			sarama.ErrNotController:
+			return true
+		}
+		return false
+	}
+	var configErr sarama.ConfigurationError
+	var encodingErr sarama.PacketEncodingError
+	if errors.As(err, &configErr) || errors.As(err, &encodingErr) {
+		return false
+	}
+	// Out of brokers, closed connections, timeouts
+	return err != nil
+}
+
+// Spool keeps messages that could not be published to Kafka in a Redis
+// stream, in the order they were published, until SpoolReplayer has
+// delivered them. Entries that keep failing are moved to a dead-letter
+// stream named after the spool with a ":dead" suffix.
+type Spool struct {
+	client  *redis.Client
+	stream  string
+	Metrics *SpoolMetrics
+}
+
+// SpoolMetrics counts what went through the spool
+type SpoolMetrics struct {
+	spooled      atomic.Int64
+	replayed     atomic.Int64
+	replayErrors atomic.Int64
+	deadLettered atomic.Int64
+	size         atomic.Int64
+	lastReplayAt atomic.Int64
+}
+
+// SpoolStats is a point-in-time copy of SpoolMetrics
+type SpoolStats struct {
+	Spooled      int64     `json:"spooled"`
+	Replayed     int64     `json:"replayed"`
+	ReplayErrors int64     `json:"replay_errors"`
+	DeadLettered int64     `json:"dead_lettered"`
+	Size         int64     `json:"size"` // as seen by the last spool or replay
+	LastReplayAt time.Time `json:"last_replay_at"`
+}
+
+func (m *SpoolMetrics) Snapshot() SpoolStats {
+	return SpoolStats{
+		Spooled:      m.spooled.Load(),
+		Replayed:     m.replayed.Load(),
+		ReplayErrors: m.replayErrors.Load(),
+		DeadLettered: m.deadLettered.Load(),
+		Size:         m.size.Load(),
+		LastReplayAt: time.Unix(0, m.lastReplayAt.Load()),
+	}
+}
+
+// NewSpool creates a spool on the kafka:spool stream
+func NewSpool(client *redis.Client) *Spool {
+	return &Spool{client: client, stream: defaultSpoolStream, Metrics: &SpoolMetrics{}}
+}
+
+// Add appends a message that failed to reach Kafka
+func (s *Spool) Add(ctx context.Context, topic, key string, value []byte, cause error) error {
+	fields := map[string]interface{}{
+		"topic":      topic,
+		"key":        key,
+		"value":      value,
+		"spooled_at": time.Now().UnixMilli(),
+	}
+	if cause != nil {
+		fields["error"] = cause.Error()
+	}
+
+	if err := s.client.XAdd(ctx, &redis.XAddArgs{Stream: s.stream, Values: fields}).Err(); err != nil {
+		return fmt.Errorf("failed to spool message for topic %s: %w", topic, err)
+	}
+	s.Metrics.spooled.Add(1)
+	s.Metrics.size.Add(1)
+	return nil
+}
+
+// DeadLetterStream is the stream holding the messages the replayer gave up on
+func (s *Spool) DeadLetterStream() string {
+	return s.stream + ":dead"
+}
+
+// failuresKey is the hash counting failed replays per entry
+func (s *Spool) failuresKey() string {
+	return s.stream + ":failures"
+}
+
+// Size returns the number of messages waiting in the spool
+func (s *Spool) Size(ctx context.Context) (int64, error) {
+	n, err := s.client.XLen(ctx, s.stream).Result()
+	if err != nil {
+		return 0, err
+	}
+	s.Metrics.size.Store(n)
+	return n, nil
+}
+
+// SpoolReplayer re-publishes spooled messages once Kafka is reachable
+// again. Messages are sent oldest first and removed from the spool only
+// after Kafka acknowledged them; a failed send ends the pass so the order
+// is kept. An entry that failed MaxAttempts passes, or failed with an
+// error a replay cannot fix, is moved to the dead-letter stream. A Redis
+// lock lets a single instance replay at a time.
+type SpoolReplayer struct {
+	Spool       *Spool
+	Producer    sarama.SyncProducer
+	Interval    time.Duration
+	BatchSize   int64
+	MaxAttempts int64
+
+	owner string
+}
+
+// NewSpoolReplayer creates a replayer publishing through producer
+func NewSpoolReplayer(spool *Spool, producer sarama.SyncProducer) *SpoolReplayer {
+	return &SpoolReplayer{
+		Spool:       spool,
+		Producer:    producer,
+		Interval:    5 * time.Second,
+		BatchSize:   100,
+		MaxAttempts: defaultSpoolMaxAttempts,
+	}
+}
+
+// Start replays the spool until ctx is cancelled
+func (r *SpoolReplayer) Start(ctx context.Context) error {
+	if r.Interval <= 0 {
+		r.Interval = 5 * time.Second
+	}
+
+	for {
+		if n, err := r.ReplayOnce(ctx); err != nil {
+			log.Printf("❌ Kafka spool replay failed after %d messages: %v", n, err)
+		} else if n > 0 {
+			log.Printf("✅ Replayed %d spooled messages to Kafka", n)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(r.Interval):
+		}
+	}
+}
+
+// ReplayOnce drains the spool until it is empty or a send fails, and
+// returns how many messages were delivered
+func (r *SpoolReplayer) ReplayOnce(ctx context.Context) (int, error) {
+	if r.BatchSize <= 0 {
+		r.BatchSize = 100
+	}
+	if r.MaxAttempts <= 0 {
+		r.MaxAttempts = defaultSpoolMaxAttempts
+	}
+	if r.owner == "" {
+		r.owner = uuids.NewUUID()
+	}
+	s := r.Spool
+	s.Metrics.lastReplayAt.Store(time.Now().UnixNano())
+
+	size, err := s.Size(ctx)
+	if err != nil || size == 0 {
+		return 0, err
+	}
+
+	lockKey := s.stream + ":lock"
+	locked, err := s.client.SetNX(ctx, lockKey, r.owner, spoolLockTTL).Result()
+	if err != nil || !locked {
+		return 0, err // another instance is replaying
+	}
+	defer releaseSpoolLock.Run(context.WithoutCancel(ctx), s.client, []string{lockKey}, r.owner)
+
+	replayed := 0
+	for ctx.Err() == nil {
+		entries, err := s.client.XRangeN(ctx, s.stream, "-", "+", r.BatchSize).Result()
+		if err != nil {
+			return replayed, err
+		}
+		if len(entries) == 0 {
+			break
+		}
+
+		for _, entry := range entries {
+			if err := r.send(entry); err != nil {
+				s.Metrics.replayErrors.Add(1)
+				dead, dlqErr := r.failed(ctx, entry, err)
+				if dlqErr != nil {
+					return replayed, dlqErr
+				}
+				if dead {
+					continue
+				}
+				return replayed, err
+			}
+			if err := s.client.XDel(ctx, s.stream, entry.ID).Err(); err != nil {
+				// Sent but still spooled: it is sent again on the next pass
+				return replayed, err
+			}
+			s.client.HDel(ctx, s.failuresKey(), entry.ID)
+			replayed++
+			s.Metrics.replayed.Add(1)
+			s.Metrics.size.Add(-1)
+		}
+
+		owned, err := extendSpoolLock.Run(ctx, s.client, []string{lockKey}, r.owner, spoolLockTTL.Milliseconds()).Int()
+		if err != nil {
+			return replayed, err
+		}
+		if owned == 0 {
+			return replayed, errors.New("kafka spool replay lock lost")
+		}
+	}
+	return replayed, nil
+}
+
+// failed counts a failed send of entry and moves the entry to the
+// dead-letter stream once it cannot be delivered any more, reporting
+// whether it did
+func (r *SpoolReplayer) failed(ctx context.Context, entry redis.XMessage, sendErr error) (bool, error) {
+	s := r.Spool
+	attempts, err := s.client.HIncrBy(ctx, s.failuresKey(), entry.ID, 1).Result()
+	if err != nil {
+		return false, err
+	}
+	permanent := !spoolable(sendErr) || errors.Is(sendErr, errInvalidSpoolEntry)
+	if !permanent && attempts < r.MaxAttempts {
+		return false, nil
+	}
+
+	fields := make(map[string]interface{}, len(entry.Values)+4)
+	for k, v := range entry.Values {
+		fields[k] = v
+	}
+	fields["spool_id"] = entry.ID
+	fields["error"] = sendErr.Error()
+	fields["attempts"] = attempts
+	fields["dead_at"] = time.Now().UnixMilli()
+
+	_, err = s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.XAdd(ctx, &redis.XAddArgs{Stream: s.DeadLetterStream(), Values: fields})
+		pipe.XDel(ctx, s.stream, entry.ID)
+		pipe.HDel(ctx, s.failuresKey(), entry.ID)
+		return nil
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to dead-letter spooled message %s: %w", entry.ID, err)
+	}
+	s.Metrics.deadLettered.Add(1)
+	s.Metrics.size.Add(-1)
+	log.Printf("🚨 Spooled message %s for topic [%v] moved to %s after %d attempts: %v",
+		entry.ID, entry.Values["topic"], s.DeadLetterStream(), attempts, sendErr)
+	return true, nil
+}
+
+func (r *SpoolReplayer) send(entry redis.XMessage) error {
+	topic, _ := entry.Values["topic"].(string)
+	key, _ := entry.Values["key"].(string)
+	value, _ := entry.Values["value"].(string)
+	if topic == "" {
+		return fmt.Errorf("%w: %s has no topic", errInvalidSpoolEntry, entry.ID)
+	}
+
+	_, _, err := r.Producer.SendMessage(&sarama.ProducerMessage{
+		Topic: topic,
+		Key:   sarama.StringEncoder(key),
+		Value: sarama.ByteEncoder(value),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to replay spooled message %s to %s: %w", entry.ID, topic, err)
+	}
+	return nil
+}
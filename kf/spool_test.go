@@ -0,0 +1,249 @@
+package kf
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/IBM/sarama"
+)
+
+func TestSpoolable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "out of brokers", err: sarama.ErrOutOfBrokers, want: true},
+		{name: "closed connection", err: io.EOF, want: true},
+		{name: "leader moving", err: sarama.ErrNotLeaderForPartition, want: true},
+		{name: "broker timeout", err: sarama.ErrRequestTimedOut, want: true},
+		{name: "not enough replicas", err: sarama.ErrNotEnoughReplicas, want: true},
+		{name: "wrapped retriable error", err: fmt.Errorf("send: %w", sarama.ErrBrokerNotAvailable), want: true},
+		{name: "producer error", err: &sarama.ProducerError{Err: sarama.ErrLeaderNotAvailable}, want: true},
+		{name: "message too large", err: sarama.ErrMessageSizeTooLarge, want: false},
+		{name: "invalid topic", err: sarama.ErrInvalidTopic, want: false},
+		{name: "not authorized", err: sarama.ErrTopicAuthorizationFailed, want: false},
+		{name: "producer error too large", err: &sarama.ProducerError{Err: sarama.ErrMessageSizeTooLarge}, want: false},
+		{name: "configuration", err: sarama.ConfigurationError("bad config"), want: false},
+		{name: "encoding", err: sarama.PacketEncodingError{Info: "bad key"}, want: false},
+		{name: "no error", err: nil, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := spoolable(tt.err); got != tt.want {
+				t.Errorf("spoolable(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+// recordingProducer records what it sends; fail decides which sends fail
+type recordingProducer struct {
+	sarama.SyncProducer
+	fail func(msg *sarama.ProducerMessage) error
+	sent []string
+}
+
+func (p *recordingProducer) SendMessage(msg *sarama.ProducerMessage) (int32, int64, error) {
+	if p.fail != nil {
+		if err := p.fail(msg); err != nil {
+			return 0, 0, err
+		}
+	}
+	value, _ := msg.Value.Encode()
+	p.sent = append(p.sent, msg.Topic+":"+string(value))
+	return 0, int64(len(p.sent)), nil
+}
+
+// failValue fails the sends of value with err
+func failValue(value string, err error) func(msg *sarama.ProducerMessage) error {
+	return func(msg *sarama.ProducerMessage) error {
+		if v, _ := msg.Value.Encode(); string(v) == value {
+			return err
+		}
+		return nil
+	}
+}
+
+func TestSpoolReplayOnce(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name        string
+		fail        func(msg *sarama.ProducerMessage) error
+		maxAttempts int64
+		passes      int
+		wantSent    []string
+		wantLeft    int
+		wantDead    []string // values moved to the dead-letter stream
+	}{
+		{
+			name:     "all delivered in order",
+			passes:   1,
+			wantSent: []string{"orders:1", "orders:2", "orders:3"},
+		},
+		{
+			name:     "unreachable broker stops the pass",
+			fail:     failValue("2", sarama.ErrBrokerNotAvailable),
+			passes:   2,
+			wantSent: []string{"orders:1"},
+			wantLeft: 2,
+		},
+		{
+			name:        "retriable failure gives up after max attempts",
+			fail:        failValue("2", sarama.ErrBrokerNotAvailable),
+			maxAttempts: 2,
+			passes:      2,
+			wantSent:    []string{"orders:1", "orders:3"},
+			wantDead:    []string{"2"},
+		},
+		{
+			name:     "permanent failure is dead-lettered at once",
+			fail:     failValue("2", sarama.ErrMessageSizeTooLarge),
+			passes:   1,
+			wantSent: []string{"orders:1", "orders:3"},
+			wantDead: []string{"2"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, client := newFakeRedis(t)
+			spool := NewSpool(client)
+			for _, value := range []string{"1", "2", "3"} {
+				if err := spool.Add(ctx, "orders", "k", []byte(value), sarama.ErrOutOfBrokers); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			producer := &recordingProducer{fail: tt.fail}
+			replayer := NewSpoolReplayer(spool, producer)
+			replayer.MaxAttempts = tt.maxAttempts
+			for range tt.passes {
+				replayer.ReplayOnce(ctx)
+			}
+
+			if !reflect.DeepEqual(producer.sent, tt.wantSent) {
+				t.Errorf("sent %v, want %v", producer.sent, tt.wantSent)
+			}
+			if n, _ := spool.Size(ctx); n != int64(tt.wantLeft) {
+				t.Errorf("%d messages left in the spool, want %d", n, tt.wantLeft)
+			}
+			var dead []string
+			for _, entry := range store.stream(spool.DeadLetterStream()) {
+				dead = append(dead, entry["value"])
+				if entry["topic"] != "orders" || entry["spool_id"] == "" || entry["error"] == "" {
+					t.Errorf("dead-lettered entry = %v", entry)
+				}
+			}
+			if !reflect.DeepEqual(dead, tt.wantDead) {
+				t.Errorf("dead-lettered %v, want %v", dead, tt.wantDead)
+			}
+			if _, locked := store.get(defaultSpoolStream + ":lock"); locked {
+				t.Error("replay lock not released")
+			}
+
+			stats := spool.Metrics.Snapshot()
+			if stats.Replayed != int64(len(tt.wantSent)) || stats.DeadLettered != int64(len(tt.wantDead)) || stats.Size != int64(tt.wantLeft) {
+				t.Errorf("metrics = %+v", stats)
+			}
+		})
+	}
+}
+
+func TestSpoolReplayCountsFailuresPerEntry(t *testing.T) {
+	ctx := context.Background()
+	store, client := newFakeRedis(t)
+	spool := NewSpool(client)
+	spool.Add(ctx, "orders", "k", []byte("1"), nil)
+
+	replayer := NewSpoolReplayer(spool, &recordingProducer{fail: failValue("1", sarama.ErrOutOfBrokers)})
+	for range 3 {
+		if n, err := replayer.ReplayOnce(ctx); n != 0 || !errors.Is(err, sarama.ErrOutOfBrokers) {
+			t.Fatalf("ReplayOnce() = %d, %v; want the send error", n, err)
+		}
+	}
+
+	failures := store.hash(spool.failuresKey())
+	if len(failures) != 1 {
+		t.Fatalf("failures = %v, want one entry", failures)
+	}
+	for _, n := range failures {
+		if n != 3 {
+			t.Errorf("entry failed %d times, want 3", n)
+		}
+	}
+}
+
+func TestSpoolReplayWaitsForLockOwner(t *testing.T) {
+	ctx := context.Background()
+	store, client := newFakeRedis(t)
+	spool := NewSpool(client)
+	spool.Add(ctx, "orders", "k", []byte("1"), nil)
+	store.set(defaultSpoolStream+":lock", "other-instance")
+
+	producer := &recordingProducer{}
+	if n, err := NewSpoolReplayer(spool, producer).ReplayOnce(ctx); n != 0 || err != nil {
+		t.Errorf("ReplayOnce() = %d, %v; want nothing done", n, err)
+	}
+	if len(producer.sent) != 0 {
+		t.Errorf("sent %v while another instance holds the lock", producer.sent)
+	}
+	if owner, _ := store.get(defaultSpoolStream + ":lock"); owner != "other-instance" {
+		t.Errorf("lock owner = %q, want it left alone", owner)
+	}
+}
+
+func TestSpoolReplayDeadLettersEntriesWithoutTopic(t *testing.T) {
+	ctx := context.Background()
+	store, client := newFakeRedis(t)
+	spool := NewSpool(client)
+	spool.Add(ctx, "", "k", []byte("1"), nil)
+	spool.Add(ctx, "orders", "k", []byte("2"), nil)
+
+	producer := &recordingProducer{}
+	if n, err := NewSpoolReplayer(spool, producer).ReplayOnce(ctx); n != 1 || err != nil {
+		t.Fatalf("ReplayOnce() = %d, %v; want the valid entry replayed", n, err)
+	}
+	if dead := store.stream(spool.DeadLetterStream()); len(dead) != 1 || dead[0]["value"] != "1" {
+		t.Errorf("dead-lettered %v, want the entry without topic", dead)
+	}
+}
+
+func TestPublishSpoolsWhenKafkaIsUnreachable(t *testing.T) {
+	_, client := newFakeRedis(t)
+	producer := &recordingProducer{fail: failValue(`"first"`, sarama.ErrOutOfBrokers)}
+	kp := &KafkaPublisher{producer: producer, spool: NewSpool(client)}
+
+	if err := kp.Publish("orders", "k", "first", 0); err != nil {
+		t.Fatalf("Publish() = %v, want the message spooled", err)
+	}
+	// Later messages queue behind the spooled one to keep their order
+	if err := kp.Publish("orders", "k", "second", 0); err != nil {
+		t.Fatal(err)
+	}
+	if len(producer.sent) != 0 {
+		t.Errorf("sent %v past the spool", producer.sent)
+	}
+	if n, _ := kp.spool.Size(context.Background()); n != 2 {
+		t.Errorf("%d messages spooled, want 2", n)
+	}
+}
+
+func TestPublishReturnsPermanentErrors(t *testing.T) {
+	_, client := newFakeRedis(t)
+	producer := &recordingProducer{fail: failValue(`"huge"`, sarama.ErrMessageSizeTooLarge)}
+	kp := &KafkaPublisher{producer: producer, spool: NewSpool(client)}
+
+	if err := kp.Publish("orders", "k", "huge", 0); !errors.Is(err, sarama.ErrMessageSizeTooLarge) {
+		t.Errorf("Publish() = %v, want %v", err, sarama.ErrMessageSizeTooLarge)
+	}
+	if n, _ := kp.spool.Size(context.Background()); n != 0 {
+		t.Errorf("%d messages spooled, want none", n)
+	}
+}
@@ -0,0 +1,199 @@
+package kf
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/IBM/sarama"
+)
+
+var (
+	// ErrTxnDone is returned when a finished transaction is used again
+	ErrTxnDone = errors.New("kafka transaction already committed or aborted")
+	// ErrTxnFatal is returned once the producer is in a fatal transaction
+	// state, e.g. fenced by another producer with the same TransactionalID.
+	// It cannot start transactions any more: close it and create a new one.
+	ErrTxnFatal = errors.New("kafka transactional producer failed fatally and must be recreated")
+)
+
+// applyIdempotence makes the producer idempotent. Only
+// NewTransactionalProducer uses TransactionalID.
+func (p ProducerConfig) applyIdempotence(sc *sarama.Config) {
+	if !p.Idempotent && p.TransactionalID == "" {
+		return
+	}
+	sc.Producer.Idempotent = true
+	sc.Producer.RequiredAcks = sarama.WaitForAll
+	sc.Net.MaxOpenRequests = 1
+	if sc.Producer.Retry.Max < 1 {
+		sc.Producer.Retry.Max = 1
+	}
+}
+
+// TransactionalProducer writes to several topics atomically, optionally
+// together with the offsets of the consumed messages, for exactly-once
+// consume-transform-produce pipelines. Consumers of its output should set
+// Config.ReadCommitted. One transaction runs at a time.
+type TransactionalProducer struct {
+	producer sarama.SyncProducer
+	groupID  string
+	mu       sync.Mutex
+}
+
+// NewTransactionalProducer creates a producer for cfg.Producer.TransactionalID.
+// cfg.GroupID is the consumer group whose offsets transactions commit.
+func NewTransactionalProducer(cfg *Config) (*TransactionalProducer, error) {
+	if cfg.Producer.TransactionalID == "" {
+		return nil, errors.New("transactional producer needs Producer.TransactionalID")
+	}
+
+	saramaCfg, err := newSaramaConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	cfg.Producer.applyIdempotence(saramaCfg)
+	saramaCfg.Producer.Transaction.ID = cfg.Producer.TransactionalID
+
+	producer, err := sarama.NewSyncProducer(cfg.Brokers, saramaCfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create transactional Kafka producer: %w", err)
+	}
+	return &TransactionalProducer{producer: producer, groupID: cfg.GroupID}, nil
+}
+
+// Txn is an open transaction. It must end with Commit or Abort: until then
+// the producer is held and every other BeginTxn blocks. RunInTxn takes
+// care of that.
+type Txn struct {
+	p    *TransactionalProducer
+	done bool
+}
+
+// BeginTxn starts a transaction, waiting for the previous one to end
+func (p *TransactionalProducer) BeginTxn() (*Txn, error) {
+	p.mu.Lock()
+	if err := p.producer.BeginTxn(); err != nil {
+		p.mu.Unlock()
+		return nil, p.txnError("failed to begin Kafka transaction", err)
+	}
+	return &Txn{p: p}, nil
+}
+
+// RunInTxn runs fn in a transaction and commits it, or aborts it when fn
+// or the commit fails or fn panics
+func (p *TransactionalProducer) RunInTxn(fn func(txn *Txn) error) error {
+	txn, err := p.BeginTxn()
+	if err != nil {
+		return err
+	}
+	// Also runs when fn panics, which would otherwise keep the producer held
+	defer func() {
+		if !txn.done {
+			if abortErr := txn.Abort(); abortErr != nil {
+				log.Printf("❌ %v", abortErr)
+			}
+		}
+	}()
+
+	if err := fn(txn); err != nil {
+		return err
+	}
+	return txn.Commit()
+}
+
+// txnError wraps a failed transaction call, adding ErrTxnFatal when the
+// producer cannot be used any more
+func (p *TransactionalProducer) txnError(msg string, err error) error {
+	if p.producer.TxnStatus()&sarama.ProducerTxnFlagFatalError != 0 {
+		return fmt.Errorf("%s: %w: %w", msg, ErrTxnFatal, err)
+	}
+	return fmt.Errorf("%s: %w", msg, err)
+}
+
+// Close closes the producer
+func (p *TransactionalProducer) Close() error {
+	return p.producer.Close()
+}
+
+// Send writes a message as part of the transaction
+func (t *Txn) Send(topic, key string, value []byte, headers ...sarama.RecordHeader) error {
+	if t.done {
+		return ErrTxnDone
+	}
+	_, _, err := t.p.producer.SendMessage(&sarama.ProducerMessage{
+		Topic:   topic,
+		Key:     sarama.StringEncoder(key),
+		Value:   sarama.ByteEncoder(value),
+		Headers: headers,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to send to %s in Kafka transaction: %w", topic, err)
+	}
+	return nil
+}
+
+// AddMessage commits the offset after a consumed message with the
+// transaction. message must carry the topic it was consumed from.
+func (t *Txn) AddMessage(message *sarama.ConsumerMessage) error {
+	if t.done {
+		return ErrTxnDone
+	}
+	if err := t.p.producer.AddMessageToTxn(message, t.p.groupID, nil); err != nil {
+		return fmt.Errorf("failed to add consumed offset to Kafka transaction: %w", err)
+	}
+	return nil
+}
+
+// AddOffsets commits the given consumer offsets, per topic, with the
+// transaction
+func (t *Txn) AddOffsets(offsets map[string][]*sarama.PartitionOffsetMetadata) error {
+	if t.done {
+		return ErrTxnDone
+	}
+	if err := t.p.producer.AddOffsetsToTxn(offsets, t.p.groupID); err != nil {
+		return fmt.Errorf("failed to add offsets to Kafka transaction: %w", err)
+	}
+	return nil
+}
+
+// Commit commits the transaction. A commit failing with an abortable
+// error aborts the transaction; one failing with ErrTxnFatal leaves a
+// producer that must be recreated.
+func (t *Txn) Commit() error {
+	if t.done {
+		return ErrTxnDone
+	}
+	defer t.finish()
+
+	err := t.p.producer.CommitTxn()
+	if err == nil {
+		return nil
+	}
+	if t.p.producer.TxnStatus()&sarama.ProducerTxnFlagAbortableError != 0 {
+		if abortErr := t.p.producer.AbortTxn(); abortErr != nil {
+			log.Printf("❌ Failed to abort Kafka transaction: %v", abortErr)
+		}
+	}
+	return t.p.txnError("failed to commit Kafka transaction", err)
+}
+
+// Abort aborts the transaction; nothing it sent becomes visible to
+// read-committed consumers
+func (t *Txn) Abort() error {
+	if t.done {
+		return ErrTxnDone
+	}
+	defer t.finish()
+
+	if err := t.p.producer.AbortTxn(); err != nil {
+		return t.p.txnError("failed to abort Kafka transaction", err)
+	}
+	return nil
+}
+
+func (t *Txn) finish() {
+	t.done = true
+	t.p.mu.Unlock()
+}
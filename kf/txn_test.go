@@ -0,0 +1,136 @@
+package kf
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/IBM/sarama"
+)
+
+// txnProducer records the transaction calls made on it
+type txnProducer struct {
+	sarama.SyncProducer
+	calls     []string
+	commitErr error
+	status    sarama.ProducerTxnStatusFlag
+}
+
+func (p *txnProducer) BeginTxn() error {
+	p.calls = append(p.calls, "begin")
+	return nil
+}
+
+func (p *txnProducer) SendMessage(msg *sarama.ProducerMessage) (int32, int64, error) {
+	p.calls = append(p.calls, "send "+msg.Topic)
+	return 0, 0, nil
+}
+
+func (p *txnProducer) CommitTxn() error {
+	p.calls = append(p.calls, "commit")
+	return p.commitErr
+}
+
+func (p *txnProducer) AbortTxn() error {
+	p.calls = append(p.calls, "abort")
+	return nil
+}
+
+func (p *txnProducer) TxnStatus() sarama.ProducerTxnStatusFlag {
+	return p.status
+}
+
+func TestRunInTxn(t *testing.T) {
+	failure := errors.New("transform failed")
+
+	tests := []struct {
+		name      string
+		fn        func(txn *Txn) error
+		commitErr error
+		status    sarama.ProducerTxnStatusFlag
+		wantErr   error
+		wantCalls []string
+	}{
+		{
+			name:      "commits",
+			fn:        func(txn *Txn) error { return txn.Send("orders", "k", []byte("v")) },
+			wantCalls: []string{"begin", "send orders", "commit"},
+		},
+		{
+			name: "aborts when fn fails",
+			fn: func(txn *Txn) error {
+				txn.Send("orders", "k", []byte("v"))
+				return failure
+			},
+			wantErr:   failure,
+			wantCalls: []string{"begin", "send orders", "abort"},
+		},
+		{
+			name:      "aborts an abortable commit failure",
+			fn:        func(*Txn) error { return nil },
+			commitErr: sarama.ErrProducerFenced,
+			status:    sarama.ProducerTxnFlagAbortableError,
+			wantErr:   sarama.ErrProducerFenced,
+			wantCalls: []string{"begin", "commit", "abort"},
+		},
+		{
+			name:      "fatal commit failure",
+			fn:        func(*Txn) error { return nil },
+			commitErr: sarama.ErrProducerFenced,
+			status:    sarama.ProducerTxnFlagFatalError,
+			wantErr:   ErrTxnFatal,
+			wantCalls: []string{"begin", "commit"},
+		},
+		{
+			name: "finished transaction cannot be reused",
+			fn: func(txn *Txn) error {
+				if err := txn.Commit(); err != nil {
+					return err
+				}
+				return txn.Send("orders", "k", []byte("v"))
+			},
+			wantErr:   ErrTxnDone,
+			wantCalls: []string{"begin", "commit"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			producer := &txnProducer{commitErr: tt.commitErr, status: tt.status}
+			p := &TransactionalProducer{producer: producer}
+
+			if err := p.RunInTxn(tt.fn); !errors.Is(err, tt.wantErr) {
+				t.Errorf("RunInTxn() = %v, want %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(producer.calls, tt.wantCalls) {
+				t.Errorf("calls = %v, want %v", producer.calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestRunInTxnAbortsOnPanic(t *testing.T) {
+	producer := &txnProducer{}
+	p := &TransactionalProducer{producer: producer}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("RunInTxn() swallowed the panic")
+			}
+		}()
+		p.RunInTxn(func(txn *Txn) error {
+			txn.Send("orders", "k", []byte("v"))
+			panic("nil map")
+		})
+	}()
+
+	// The producer was released: the next transaction does not block
+	if err := p.RunInTxn(func(*Txn) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"begin", "send orders", "abort", "begin", "commit"}
+	if !reflect.DeepEqual(producer.calls, want) {
+		t.Errorf("calls = %v, want %v", producer.calls, want)
+	}
+}
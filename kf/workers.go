@@ -0,0 +1,135 @@
+package kf
+
+import (
+	"context"
+	"hash/fnv"
+	"log"
+	"sync"
+
+	"github.com/IBM/sarama"
+)
+
+const workerQueueSize = 64
+
+// consumeConcurrently handles the messages of a claim on h.workers workers.
+// Messages are assigned to workers by key hash, so messages sharing a key
+// are handled one after the other in offset order while different keys
+// proceed in parallel. Offsets are marked contiguously: an offset is only
+// marked once every earlier offset of the partition is done.
+func (h *ConsumerGroupHandler) consumeConcurrently(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	// A message that cannot be marked holds back every later offset, so
+	// its worker ends the claim to have it delivered again
+	ctx, cancel := context.WithCancel(session.Context())
+	defer cancel()
+	tracker := &offsetTracker{session: session, byOffset: map[int64]*trackedMessage{}}
+
+	var wg sync.WaitGroup
+	queues := make([]chan *sarama.ConsumerMessage, h.workers)
+	for i := range queues {
+		queues[i] = make(chan *sarama.ConsumerMessage, workerQueueSize)
+		wg.Add(1)
+		go func(queue <-chan *sarama.ConsumerMessage) {
+			defer wg.Done()
+			for message := range queue {
+				if ctx.Err() != nil {
+					continue // left unmarked, delivered again after the rebalance
+				}
+				if h.process(ctx, message) {
+					tracker.done(message)
+				} else {
+					cancel()
+				}
+			}
+		}(queues[i])
+	}
+
+	// Drain the workers before the claim ends, so Cleanup commits what they finished
+	defer func() {
+		for _, queue := range queues {
+			close(queue)
+		}
+		wg.Wait()
+		log.Printf("🛑 Workers for partition %d drained", claim.Partition())
+	}()
+
+	for {
+		select {
+		case message, ok := <-claim.Messages():
+			if !ok {
+				log.Printf("🛑 Claim for partition %d closed", claim.Partition())
+				return nil
+			}
+			if message == nil {
+				continue
+			}
+
+			log.Printf("📨 Consuming message: topic=[%s], partition=%d, offset=%d, key=%s, value_len=%d",
+				message.Topic, message.Partition, message.Offset, string(message.Key), len(message.Value))
+
+			tracker.add(message)
+			select {
+			case queues[workerFor(message, len(queues))] <- message:
+			case <-ctx.Done():
+				return nil
+			}
+
+		case <-ctx.Done():
+			log.Printf("🛑 Consumer claim context done for partition %d", claim.Partition())
+			return nil
+		}
+	}
+}
+
+// workerFor picks the worker of a message; keyless messages have no order
+// to keep and are spread by offset
+func workerFor(message *sarama.ConsumerMessage, workers int) int {
+	if len(message.Key) == 0 {
+		return int(message.Offset % int64(workers))
+	}
+	h := fnv.New32a()
+	h.Write(message.Key)
+	return int(h.Sum32() % uint32(workers))
+}
+
+// offsetTracker marks the offsets of a partition in order as their
+// messages complete out of order
+type offsetTracker struct {
+	session sarama.ConsumerGroupSession
+
+	mu       sync.Mutex
+	pending  []*trackedMessage // in offset order
+	byOffset map[int64]*trackedMessage
+}
+
+type trackedMessage struct {
+	message *sarama.ConsumerMessage
+	done    bool
+}
+
+func (t *offsetTracker) add(message *sarama.ConsumerMessage) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	tm := &trackedMessage{message: message}
+	t.pending = append(t.pending, tm)
+	t.byOffset[message.Offset] = tm
+}
+
+// done records a finished message and marks every leading finished offset
+func (t *offsetTracker) done(message *sarama.ConsumerMessage) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	tm, ok := t.byOffset[message.Offset]
+	if !ok {
+		return
+	}
+	tm.done = true
+	delete(t.byOffset, message.Offset)
+
+	for len(t.pending) > 0 && t.pending[0].done {
+		t.session.MarkMessage(t.pending[0].message, "")
+		t.pending[0] = nil
+		t.pending = t.pending[1:]
+	}
+}
@@ -0,0 +1,52 @@
+package kf
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/IBM/sarama"
+)
+
+// markingSession records the offsets marked on it
+type markingSession struct {
+	sarama.ConsumerGroupSession
+	marked []int64
+}
+
+func (s *markingSession) MarkMessage(message *sarama.ConsumerMessage, _ string) {
+	s.marked = append(s.marked, message.Offset)
+}
+
+func TestOffsetTrackerMarksContiguously(t *testing.T) {
+	tests := []struct {
+		name       string
+		offsets    []int64 // added in this order
+		done       []int64 // completed in this order
+		wantMarked []int64
+	}{
+		{name: "in order", offsets: []int64{1, 2, 3}, done: []int64{1, 2, 3}, wantMarked: []int64{1, 2, 3}},
+		{name: "reversed", offsets: []int64{1, 2, 3}, done: []int64{3, 2, 1}, wantMarked: []int64{1, 2, 3}},
+		{name: "gap holds back later offsets", offsets: []int64{1, 2, 3, 4}, done: []int64{2, 4, 3}, wantMarked: nil},
+		{name: "gap filled", offsets: []int64{1, 2, 3, 4}, done: []int64{2, 4, 1}, wantMarked: []int64{1, 2}},
+		{name: "unknown offset is ignored", offsets: []int64{1}, done: []int64{7, 1}, wantMarked: []int64{1}},
+		{name: "done twice", offsets: []int64{1, 2}, done: []int64{2, 2, 1}, wantMarked: []int64{1, 2}},
+		{name: "sparse offsets", offsets: []int64{10, 15, 40}, done: []int64{40, 10, 15}, wantMarked: []int64{10, 15, 40}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			session := &markingSession{}
+			tracker := &offsetTracker{session: session, byOffset: map[int64]*trackedMessage{}}
+			for _, offset := range tt.offsets {
+				tracker.add(&sarama.ConsumerMessage{Offset: offset})
+			}
+			for _, offset := range tt.done {
+				tracker.done(&sarama.ConsumerMessage{Offset: offset})
+			}
+
+			if !reflect.DeepEqual(session.marked, tt.wantMarked) {
+				t.Errorf("marked %v, want %v", session.marked, tt.wantMarked)
+			}
+		})
+	}
+}
@@ -32,7 +32,7 @@ func InitKeycloakMiddleware(keycloakURL, realm string) error {
 	})
 
 	if err != nil {
-		fmt.Printf("Err ", err)
+		fmt.Printf("Err %v\n", err)
 		return fmt.Errorf("❌ Failed to initialize JWKS from %s: %v\n", jwksURL, err)
 	} else {
 		return nil
@@ -3,13 +3,17 @@ package sagakit
 import (
 	"time"
 
+	"shared/kf"
+
 	"github.com/IBM/sarama"
 	"github.com/ThreeDotsLabs/watermill"
 	"github.com/ThreeDotsLabs/watermill-kafka/v3/pkg/kafka"
 	"github.com/ThreeDotsLabs/watermill/message"
 )
 
-func newUnifiedSaramaConfig(username, password string) *sarama.Config {
+// newUnifiedSaramaConfig takes its SASL/TLS settings from the KAFKA_*
+// environment, like the kf package
+func newUnifiedSaramaConfig(username, password string) (*sarama.Config, error) {
 	sc := sarama.NewConfig()
 
 	sc.Version = sarama.V2_8_0_0
@@ -18,22 +22,22 @@ func newUnifiedSaramaConfig(username, password string) *sarama.Config {
 	sc.Consumer.Return.Errors = true
 	sc.Consumer.Offsets.Initial = sarama.OffsetOldest
 
-	sc.Net.SASL.Enable = true
-	sc.Net.SASL.User = username
-	sc.Net.SASL.Password = password
-	sc.Net.SASL.Mechanism = sarama.SASLTypePlaintext
-	sc.Net.SASL.Handshake = true
-	sc.Net.TLS.Enable = false
+	if err := kf.SecurityFromEnv(username, password).ApplySecurity(sc); err != nil {
+		return nil, err
+	}
 
 	sc.Net.DialTimeout = 10 * time.Second
 	sc.Net.ReadTimeout = 10 * time.Second
 	sc.Net.WriteTimeout = 10 * time.Second
 
-	return sc
+	return sc, nil
 }
 
 func NewKafkaPublisher(logger watermill.LoggerAdapter, brokers []string, username, password string) (message.Publisher, error) {
-	sc := newUnifiedSaramaConfig(username, password)
+	sc, err := newUnifiedSaramaConfig(username, password)
+	if err != nil {
+		return nil, err
+	}
 
 	cfg := kafka.PublisherConfig{
 		Brokers:               brokers,
@@ -45,7 +49,10 @@ func NewKafkaPublisher(logger watermill.LoggerAdapter, brokers []string, usernam
 }
 
 func NewKafkaSubscriber(logger watermill.LoggerAdapter, brokers []string, group, username, password string) (message.Subscriber, error) {
-	sc := newUnifiedSaramaConfig(username, password)
+	sc, err := newUnifiedSaramaConfig(username, password)
+	if err != nil {
+		return nil, err
+	}
 
 	cfg := kafka.SubscriberConfig{
 		Brokers:               brokers,
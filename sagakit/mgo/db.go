@@ -0,0 +1,86 @@
+package mgo
+
+import (
+	"context"
+	"errors"
+	"shared/sagakit/db"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrSQLUnsupported is returned by Tx.Exec and Tx.Query. Mongo-backed
+// stores reach the transaction through FromTx instead.
+var ErrSQLUnsupported = errors.New("mgo: SQL statements are not supported in a Mongo transaction")
+
+// DB adapts a mongo.Client to db.DB so that sagakit.RunInTx runs inside a
+// multi-document transaction (replica set or sharded cluster required)
+type DB struct {
+	Client   *mongo.Client
+	Database string
+}
+
+func NewDB(client *mongo.Client, database string) *DB {
+	return &DB{Client: client, Database: database}
+}
+
+func (d *DB) BeginTx(ctx context.Context) (db.Tx, error) {
+	session, err := d.Client.StartSession()
+	if err != nil {
+		return nil, err
+	}
+	if err := session.StartTransaction(); err != nil {
+		session.EndSession(ctx)
+		return nil, err
+	}
+	return &Tx{session: session, db: d.Client.Database(d.Database)}, nil
+}
+
+type Tx struct {
+	session mongo.Session
+	db      *mongo.Database
+}
+
+func (t *Tx) Exec(ctx context.Context, query string, args ...any) error {
+	return ErrSQLUnsupported
+}
+
+func (t *Tx) Query(ctx context.Context, query string, args ...any) (db.Rows, error) {
+	return nil, ErrSQLUnsupported
+}
+
+func (t *Tx) Commit() error {
+	defer t.session.EndSession(context.Background())
+	return t.session.CommitTransaction(context.Background())
+}
+
+func (t *Tx) Rollback() error {
+	defer t.session.EndSession(context.Background())
+	return t.session.AbortTransaction(context.Background())
+}
+
+// Context binds ctx to the transaction; pass it to every collection call
+// that must be part of it
+func (t *Tx) Context(ctx context.Context) context.Context {
+	return mongo.NewSessionContext(ctx, t.session)
+}
+
+// Database is the database the transaction was started for
+func (t *Tx) Database() *mongo.Database { return t.db }
+
+// Collection returns a collection of Database. Documents nested in
+// interface{} values decode as maps rather than bson.D.
+func (t *Tx) Collection(name string) *mongo.Collection {
+	return t.db.Collection(name, options.Collection().SetBSONOptions(&options.BSONOptions{
+		DefaultDocumentM: true,
+	}))
+}
+
+// FromTx returns the Mongo transaction behind tx
+func FromTx(tx db.Tx) (*Tx, error) {
+	t, ok := tx.(*Tx)
+	if !ok {
+		return nil, errors.New("mgo: not a Mongo transaction")
+	}
+	return t, nil
+}
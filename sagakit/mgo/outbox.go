@@ -0,0 +1,133 @@
+package mgo
+
+import (
+	"context"
+	"shared/sagakit/db"
+	"shared/sagakit/outbox"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill/message"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Outbox implements outbox.Store on a Mongo collection, written in the
+// same transaction as the caller's documents
+type Outbox struct {
+	Collection string
+}
+
+func NewOutbox() *Outbox { return &Outbox{Collection: "outbox"} }
+
+type outboxDoc struct {
+	ID            primitive.ObjectID `bson:"_id"`
+	Topic         string             `bson:"topic"`
+	Payload       []byte             `bson:"payload"`
+	Headers       map[string]string  `bson:"headers"`
+	CreatedAt     time.Time          `bson:"created_at"`
+	NextAttemptAt *time.Time         `bson:"next_attempt_at"`
+	SentAt        *time.Time         `bson:"sent_at"`
+}
+
+// EnsureIndexes creates the index used to find pending messages. Indexes
+// cannot be created inside a transaction, so call it once at startup.
+func (o *Outbox) EnsureIndexes(ctx context.Context, database *mongo.Database) error {
+	_, err := database.Collection(o.Collection).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "sent_at", Value: 1}, {Key: "_id", Value: 1}},
+	})
+	return err
+}
+
+func (o *Outbox) InsertTx(ctx context.Context, tx db.Tx, topic string, msg *message.Message) error {
+	return o.insert(ctx, tx, topic, msg, nil)
+}
+
+func (o *Outbox) ScheduleTx(ctx context.Context, tx db.Tx, topic string, msg *message.Message, at time.Time) error {
+	return o.insert(ctx, tx, topic, msg, &at)
+}
+
+func (o *Outbox) insert(ctx context.Context, tx db.Tx, topic string, msg *message.Message, at *time.Time) error {
+	t, err := FromTx(tx)
+	if err != nil {
+		return err
+	}
+
+	_, err = t.Collection(o.Collection).InsertOne(t.Context(ctx), outboxDoc{
+		ID:            primitive.NewObjectID(),
+		Topic:         topic,
+		Payload:       msg.Payload,
+		Headers:       msg.Metadata,
+		CreatedAt:     time.Now(),
+		NextAttemptAt: at,
+	})
+	return err
+}
+
+func (o *Outbox) GetPendingTx(ctx context.Context, tx db.Tx, limit int) ([]outbox.Entry, error) {
+	t, err := FromTx(tx)
+	if err != nil {
+		return nil, err
+	}
+	sc := t.Context(ctx)
+
+	filter := bson.M{
+		"sent_at": nil,
+		"$or": bson.A{
+			bson.M{"next_attempt_at": nil},
+			bson.M{"next_attempt_at": bson.M{"$lte": time.Now()}},
+		},
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}).SetLimit(int64(limit))
+
+	cur, err := t.Collection(o.Collection).Find(sc, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(sc)
+
+	var res []outbox.Entry
+	for cur.Next(sc) {
+		var doc outboxDoc
+		if err := cur.Decode(&doc); err != nil {
+			return nil, err
+		}
+		entry := outbox.Entry{
+			ID:      doc.ID.Hex(),
+			Topic:   doc.Topic,
+			Payload: doc.Payload,
+			Headers: doc.Headers,
+		}
+		if entry.Headers == nil {
+			entry.Headers = map[string]string{}
+		}
+		res = append(res, entry)
+	}
+	return res, cur.Err()
+}
+
+func (o *Outbox) MarkSentTx(ctx context.Context, tx db.Tx, ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	t, err := FromTx(tx)
+	if err != nil {
+		return err
+	}
+
+	oids := make([]primitive.ObjectID, 0, len(ids))
+	for _, id := range ids {
+		oid, err := primitive.ObjectIDFromHex(id)
+		if err != nil {
+			return err
+		}
+		oids = append(oids, oid)
+	}
+
+	_, err = t.Collection(o.Collection).UpdateMany(t.Context(ctx),
+		bson.M{"_id": bson.M{"$in": oids}},
+		bson.M{"$set": bson.M{"sent_at": time.Now()}},
+	)
+	return err
+}
@@ -3,6 +3,7 @@ package outbox
 import (
 	"context"
 	"shared/sagakit/db"
+	"time"
 
 	"github.com/ThreeDotsLabs/watermill/message"
 )
@@ -16,6 +17,8 @@ type Entry struct {
 
 type Store interface {
 	InsertTx(ctx context.Context, tx db.Tx, topic string, msg *message.Message) error
+	// ScheduleTx inserts a message that must not be dispatched before at
+	ScheduleTx(ctx context.Context, tx db.Tx, topic string, msg *message.Message, at time.Time) error
 	GetPendingTx(ctx context.Context, tx db.Tx, limit int) ([]Entry, error)
 	MarkSentTx(ctx context.Context, tx db.Tx, ids []string) error
 }
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"shared/sagakit/db"
 	"shared/sagakit/outbox"
+	"time"
 
 	"github.com/ThreeDotsLabs/watermill/message"
 )
@@ -17,6 +18,7 @@ import (
 //   payload BYTEA NOT NULL,
 //   headers JSONB,
 //   created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+//   next_attempt_at TIMESTAMPTZ,
 //   sent_at TIMESTAMPTZ
 // );
 
@@ -25,14 +27,22 @@ type Outbox struct{}
 func NewOutbox() *Outbox { return &Outbox{} }
 
 func (o *Outbox) InsertTx(ctx context.Context, tx db.Tx, topic string, msg *message.Message) error {
+	return o.insert(ctx, tx, topic, msg, nil)
+}
+
+func (o *Outbox) ScheduleTx(ctx context.Context, tx db.Tx, topic string, msg *message.Message, at time.Time) error {
+	return o.insert(ctx, tx, topic, msg, &at)
+}
+
+func (o *Outbox) insert(ctx context.Context, tx db.Tx, topic string, msg *message.Message, at *time.Time) error {
 	headers, err := json.Marshal(msg.Metadata)
 	if err != nil {
 		return err
 	}
 
 	return tx.Exec(ctx,
-		`INSERT INTO outbox(topic, payload, headers) VALUES ($1, $2, $3)`,
-		topic, msg.Payload, headers,
+		`INSERT INTO outbox(topic, payload, headers, next_attempt_at) VALUES ($1, $2, $3, $4)`,
+		topic, msg.Payload, headers, at,
 	)
 }
 
@@ -41,6 +51,7 @@ func (o *Outbox) GetPendingTx(ctx context.Context, tx db.Tx, limit int) ([]outbo
 		`SELECT id, topic, payload, headers
            FROM outbox
           WHERE sent_at IS NULL
+            AND (next_attempt_at IS NULL OR next_attempt_at <= now())
           ORDER BY id
           LIMIT $1`,
 		limit,
@@ -0,0 +1,155 @@
+package sagakit
+
+import (
+	"context"
+	"net/http"
+
+	"shared/constants"
+	"shared/middleware"
+	"shared/pkgs/jwtmanager"
+
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// Outbox header keys used to carry request-scoped values from the
+// producing request to the handler that eventually consumes the message.
+const (
+	HeaderTraceParent = "traceparent"
+	HeaderTraceState  = "tracestate"
+	HeaderRequestID   = "request_id"
+	HeaderCompanyCode = "company_code"
+	HeaderGroupCode   = "group_code"
+	HeaderUserID      = "user_id"
+)
+
+type ctxKey int
+
+const (
+	requestIDCtxKey ctxKey = iota
+	tenantCtxKey
+)
+
+var traceContext = propagation.TraceContext{}
+
+// Tenant identifies the company/group a message was produced for
+type Tenant struct {
+	CompanyCode string
+	GroupCode   string
+	UserID      string
+}
+
+func (t Tenant) IsZero() bool {
+	return t.CompanyCode == "" && t.GroupCode == "" && t.UserID == ""
+}
+
+// WithRequestID stores a request ID in ctx
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDCtxKey, id)
+}
+
+// RequestIDFromContext returns the request ID set by WithRequestID or by
+// middleware.RequestIdMiddleware when ctx is a *gin.Context
+func RequestIDFromContext(ctx context.Context) string {
+	if id, ok := ctx.Value(requestIDCtxKey).(string); ok && id != "" {
+		return id
+	}
+	if id, ok := ctx.Value(middleware.RequestIDKey).(string); ok {
+		return id
+	}
+	return ""
+}
+
+// WithTenant stores tenant identifiers in ctx
+func WithTenant(ctx context.Context, t Tenant) context.Context {
+	return context.WithValue(ctx, tenantCtxKey, t)
+}
+
+// TenantFromContext returns the tenant set by WithTenant, falling back to
+// the JWT claims placed in a *gin.Context by middleware.AuthcMiddleware
+func TenantFromContext(ctx context.Context) Tenant {
+	if t, ok := ctx.Value(tenantCtxKey).(Tenant); ok {
+		return t
+	}
+
+	claims, ok := ctx.Value(constants.KeyClaims).(*jwtmanager.CustomClaims)
+	if !ok || claims == nil {
+		return Tenant{}
+	}
+
+	t := Tenant{}
+	t.CompanyCode, _ = claims.Custom["company_code"].(string)
+	t.GroupCode, _ = claims.Custom["group_code"].(string)
+	t.UserID, _ = claims.Custom["user_id"].(string)
+	return t
+}
+
+// InjectMetadata copies trace context, request ID and tenant identifiers
+// from ctx into md. Keys already present in md are left untouched.
+func InjectMetadata(ctx context.Context, md map[string]string) {
+	captured := propagation.MapCarrier{}
+	traceContext.Inject(ctx, captured)
+
+	// No active span: forward the caller's traceparent header as-is
+	if captured.Get(HeaderTraceParent) == "" {
+		if req := requestFromContext(ctx); req != nil {
+			traceContext.Inject(traceContext.Extract(ctx, propagation.HeaderCarrier(req.Header)), captured)
+		}
+	}
+
+	if id := RequestIDFromContext(ctx); id != "" {
+		captured[HeaderRequestID] = id
+	}
+
+	t := TenantFromContext(ctx)
+	if t.CompanyCode != "" {
+		captured[HeaderCompanyCode] = t.CompanyCode
+	}
+	if t.GroupCode != "" {
+		captured[HeaderGroupCode] = t.GroupCode
+	}
+	if t.UserID != "" {
+		captured[HeaderUserID] = t.UserID
+	}
+
+	for k, v := range captured {
+		if _, exists := md[k]; !exists {
+			md[k] = v
+		}
+	}
+}
+
+// ExtractContext restores the values written by InjectMetadata into ctx
+func ExtractContext(ctx context.Context, md map[string]string) context.Context {
+	ctx = traceContext.Extract(ctx, propagation.MapCarrier(md))
+
+	if id := md[HeaderRequestID]; id != "" {
+		ctx = WithRequestID(ctx, id)
+	}
+
+	t := Tenant{
+		CompanyCode: md[HeaderCompanyCode],
+		GroupCode:   md[HeaderGroupCode],
+		UserID:      md[HeaderUserID],
+	}
+	if !t.IsZero() {
+		ctx = WithTenant(ctx, t)
+	}
+
+	return ctx
+}
+
+// ContextMiddleware restores trace context, request ID and tenant from
+// message metadata into msg.Context() before calling the handler
+func ContextMiddleware(h message.HandlerFunc) message.HandlerFunc {
+	return func(msg *message.Message) ([]*message.Message, error) {
+		msg.SetContext(ExtractContext(msg.Context(), msg.Metadata))
+		return h(msg)
+	}
+}
+
+func requestFromContext(ctx context.Context) *http.Request {
+	req, _ := ctx.Value(gin.ContextRequestKey).(*http.Request)
+	return req
+}
@@ -0,0 +1,281 @@
+package sagaflow
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"shared/pkgs/uuids"
+	"shared/sagakit"
+)
+
+// ErrActionNotAllowed is returned when an admin action does not apply to
+// the current state of the saga or step
+var ErrActionNotAllowed = errors.New("action not allowed in current state")
+
+// ErrStepNotFound is returned for a step index outside the saga
+var ErrStepNotFound = errors.New("step not found")
+
+// AdminActionType names an operator intervention
+type AdminActionType string
+
+const (
+	AdminRetryStep         AdminActionType = "RETRY_STEP"
+	AdminSkipStep          AdminActionType = "SKIP_STEP"
+	AdminForceCompensation AdminActionType = "FORCE_COMPENSATION"
+	AdminAbort             AdminActionType = "ABORT"
+	AdminSignal            AdminActionType = "SIGNAL"
+)
+
+// AdminAction is an audit log entry for an operator intervention
+type AdminAction struct {
+	ID        string                 `bson:"_id" json:"id"`
+	SagaID    string                 `bson:"saga_id" json:"saga_id"`
+	Actor     string                 `bson:"actor" json:"actor"`
+	Action    AdminActionType        `bson:"action" json:"action"`
+	StepIndex *int                   `bson:"step_index,omitempty" json:"step_index,omitempty"`
+	Reason    string                 `bson:"reason,omitempty" json:"reason,omitempty"`
+	Payload   map[string]interface{} `bson:"payload,omitempty" json:"payload,omitempty"`
+	CreatedAt time.Time              `bson:"created_at" json:"created_at"`
+}
+
+// ExecutionFilter selects executions for ListExecutions. Zero fields match
+// everything; CreatedTo is exclusive.
+type ExecutionFilter struct {
+	States      []SagaState
+	Name        string
+	BusinessKey string
+	CreatedFrom time.Time
+	CreatedTo   time.Time
+	Limit       int
+	Offset      int
+}
+
+// TimelineEntry summarises one step of an execution
+type TimelineEntry struct {
+	StepIndex    int           `json:"step_index"`
+	StepID       string        `json:"step_id"`
+	Service      string        `json:"service"`
+	Command      string        `json:"command"`
+	State        StepState     `json:"state"`
+	Attempts     int           `json:"attempts"`
+	GroupID      string        `json:"group_id,omitempty"`
+	StartedAt    *time.Time    `json:"started_at,omitempty"`
+	CompletedAt  *time.Time    `json:"completed_at,omitempty"`
+	Duration     time.Duration `json:"duration,omitempty"`
+	ErrorMessage string        `json:"error_message,omitempty"`
+}
+
+// ListExecutions returns executions matching filter, newest first
+func (o *Orchestrator) ListExecutions(ctx context.Context, filter ExecutionFilter) ([]SagaExecution, error) {
+	var execs []SagaExecution
+	err := o.runInTx(ctx, func(uow sagakit.UnitOfWork) error {
+		var err error
+		execs, err = o.StateStore.ListExecutions(ctx, uow.Tx(), filter)
+		return err
+	})
+	return execs, err
+}
+
+// GetExecution returns an execution with its steps
+func (o *Orchestrator) GetExecution(ctx context.Context, sagaID string) (*SagaExecution, error) {
+	var exec *SagaExecution
+	err := o.runInTx(ctx, func(uow sagakit.UnitOfWork) error {
+		var err error
+		exec, err = o.StateStore.GetExecution(ctx, uow.Tx(), sagaID)
+		return err
+	})
+	return exec, err
+}
+
+// AdminActions returns the audit log of a saga, oldest first
+func (o *Orchestrator) AdminActions(ctx context.Context, sagaID string) ([]AdminAction, error) {
+	var actions []AdminAction
+	err := o.runInTx(ctx, func(uow sagakit.UnitOfWork) error {
+		var err error
+		actions, err = o.StateStore.ListAdminActions(ctx, uow.Tx(), sagaID)
+		return err
+	})
+	return actions, err
+}
+
+// StepTimeline returns the steps of a saga in execution order
+func (o *Orchestrator) StepTimeline(ctx context.Context, sagaID string) ([]TimelineEntry, error) {
+	exec, err := o.GetExecution(ctx, sagaID)
+	if err != nil {
+		return nil, err
+	}
+
+	timeline := make([]TimelineEntry, 0, len(exec.Steps))
+	for _, step := range exec.Steps {
+		entry := TimelineEntry{
+			StepIndex:    step.StepIndex,
+			StepID:       step.StepID,
+			Service:      step.Service,
+			Command:      step.Command,
+			State:        step.State,
+			Attempts:     step.Attempts,
+			GroupID:      step.GroupID,
+			StartedAt:    step.StartedAt,
+			CompletedAt:  step.CompletedAt,
+			ErrorMessage: step.ErrorMessage,
+		}
+		if step.StartedAt != nil && step.CompletedAt != nil {
+			entry.Duration = step.CompletedAt.Sub(*step.StartedAt)
+		}
+		timeline = append(timeline, entry)
+	}
+	return timeline, nil
+}
+
+// RetryStep re-sends the command of a failed or stuck step of an
+// in-progress saga, or the compensation of a step stuck compensating.
+//
+// A failed step moves the saga to COMPENSATING right away. As long as no
+// step has been compensated or sent its compensation, e.g. while other
+// members of its group are still running, the step can still be retried
+// and the saga goes back to IN_PROGRESS. After that the failure is final,
+// since participants may already have undone their work; ForceCompensation
+// then finishes compensating.
+//
+// On a FAILED saga it re-sends a compensation that gave up and resumes
+// compensating; that compensation gets one more attempt.
+func (o *Orchestrator) RetryStep(ctx context.Context, actor, sagaID string, stepIndex int, reason string) error {
+	return o.runAdminAction(ctx, actor, sagaID, AdminRetryStep, &stepIndex, reason, nil, func(uow sagakit.UnitOfWork, exec *SagaExecution) error {
+		step := exec.Steps[stepIndex]
+		switch {
+		case exec.State == StateInProgress && (step.State == StepFailed || step.State == StepInProgress):
+			return o.dispatchStep(ctx, uow, exec, stepIndex, time.Time{})
+		case exec.State == StateCompensating && step.State == StepFailed:
+			if exec.compensationStarted() {
+				return fmt.Errorf("%w: saga %s has started compensating, failed step %d can no longer be retried", ErrActionNotAllowed, exec.SagaID, stepIndex)
+			}
+			if err := o.setSagaState(ctx, uow, exec, StateInProgress, exec.CurrentStep, ""); err != nil {
+				return err
+			}
+			return o.dispatchStep(ctx, uow, exec, stepIndex, time.Time{})
+		case exec.State == StateCompensating && step.State == StepCompensating:
+			return o.compensateStep(ctx, uow, exec, stepIndex, time.Time{})
+		case exec.State == StateFailed && step.State == StepCompensationFailed:
+			if err := o.setSagaState(ctx, uow, exec, StateCompensating, exec.CurrentStep, exec.ErrorMessage); err != nil {
+				return err
+			}
+			return o.compensateStep(ctx, uow, exec, stepIndex, time.Time{})
+		}
+		return fmt.Errorf("%w: saga %s, step %s", ErrActionNotAllowed, exec.State, step.State)
+	})
+}
+
+// compensationStarted reports whether any step was compensated or sent its
+// compensation
+func (e *SagaExecution) compensationStarted() bool {
+	for _, step := range e.Steps {
+		switch step.State {
+		case StepCompensating, StepCompensated, StepCompensationFailed:
+			return true
+		}
+	}
+	return false
+}
+
+// SkipStep completes a failed or stuck step of an in-progress saga with the
+// given output, as if its service had returned it. A skipped step is not
+// compensated.
+func (o *Orchestrator) SkipStep(ctx context.Context, actor, sagaID string, stepIndex int, output map[string]interface{}, reason string) error {
+	return o.runAdminAction(ctx, actor, sagaID, AdminSkipStep, &stepIndex, reason, output, func(uow sagakit.UnitOfWork, exec *SagaExecution) error {
+		step := exec.Steps[stepIndex]
+		if exec.State != StateInProgress || (step.State != StepFailed && step.State != StepInProgress) {
+			return fmt.Errorf("%w: saga %s, step %s", ErrActionNotAllowed, exec.State, step.State)
+		}
+
+		exec.Steps[stepIndex].Output = output
+		if err := o.setStepState(ctx, uow, exec, stepIndex, StepSkipped, ""); err != nil {
+			return err
+		}
+		return o.proceed(ctx, uow, exec, stepIndex, output)
+	})
+}
+
+// ForceCompensation compensates an in-progress saga, failing its in-flight
+// steps. On a saga already compensating it re-sends the compensations that
+// have not been confirmed yet; on a FAILED saga it re-sends those that gave
+// up.
+func (o *Orchestrator) ForceCompensation(ctx context.Context, actor, sagaID, reason string) error {
+	return o.runAdminAction(ctx, actor, sagaID, AdminForceCompensation, nil, reason, nil, func(uow sagakit.UnitOfWork, exec *SagaExecution) error {
+		switch exec.State {
+		case StateInProgress:
+			errMsg := fmt.Sprintf("compensation forced by %s: %s", actor, reason)
+			if err := o.failInFlight(ctx, uow, exec, errMsg); err != nil {
+				return err
+			}
+			return o.startCompensating(ctx, uow, exec, errMsg)
+		case StateCompensating, StateFailed:
+			return o.resumeCompensation(ctx, uow, exec)
+		}
+		return fmt.Errorf("%w: saga %s", ErrActionNotAllowed, exec.State)
+	})
+}
+
+// Abort stops a saga where it is. Nothing is compensated and events that
+// arrive later are ignored.
+func (o *Orchestrator) Abort(ctx context.Context, actor, sagaID, reason string) error {
+	return o.runAdminAction(ctx, actor, sagaID, AdminAbort, nil, reason, nil, func(uow sagakit.UnitOfWork, exec *SagaExecution) error {
+		switch exec.State {
+		case StateCompleted, StateCompensated, StateAborted:
+			return fmt.Errorf("%w: saga %s", ErrActionNotAllowed, exec.State)
+		}
+		errMsg := fmt.Sprintf("aborted by %s: %s", actor, reason)
+		return o.setSagaState(ctx, uow, exec, StateAborted, exec.CurrentStep, errMsg)
+	})
+}
+
+// runAdminAction loads the saga, applies fn and records the action in the
+// audit log, all in one transaction
+func (o *Orchestrator) runAdminAction(ctx context.Context, actor, sagaID string, action AdminActionType, stepIndex *int, reason string, payload map[string]interface{}, fn func(uow sagakit.UnitOfWork, exec *SagaExecution) error) error {
+	if actor == "" {
+		return errors.New("admin action requires an actor")
+	}
+
+	return o.runInTx(ctx, func(uow sagakit.UnitOfWork) error {
+		exec, err := o.StateStore.LockExecution(ctx, uow.Tx(), sagaID)
+		if err != nil {
+			return err
+		}
+		if stepIndex != nil && (*stepIndex < 0 || *stepIndex >= len(exec.Steps)) {
+			return fmt.Errorf("%w: saga %s has no step %d", ErrStepNotFound, sagaID, *stepIndex)
+		}
+
+		if err := fn(uow, exec); err != nil {
+			return err
+		}
+
+		entry := &AdminAction{
+			ID:        uuids.NewUUID(),
+			SagaID:    sagaID,
+			Actor:     actor,
+			Action:    action,
+			StepIndex: stepIndex,
+			Reason:    reason,
+			Payload:   payload,
+			CreatedAt: time.Now(),
+		}
+		if err := o.StateStore.RecordAdminAction(ctx, uow.Tx(), entry); err != nil {
+			return err
+		}
+
+		event := &SagaEvent{
+			SagaID:    sagaID,
+			Type:      EventAdminAction,
+			StepIndex: stepIndex,
+			ToState:   string(action),
+			Payload:   map[string]interface{}{"actor": actor, "reason": reason, "admin_action_id": entry.ID},
+			CreatedAt: entry.CreatedAt,
+		}
+		if stepIndex != nil {
+			event.StepID = exec.Steps[*stepIndex].StepID
+			event.Service = exec.Steps[*stepIndex].Service
+		}
+		return o.StateStore.AppendEvent(ctx, uow.Tx(), event)
+	})
+}
@@ -0,0 +1,236 @@
+package sagaflow
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// grouped puts the steps at indexes into one parallel group
+func grouped(exec *SagaExecution, indexes ...int) *SagaExecution {
+	for _, i := range indexes {
+		exec.Steps[i].GroupID = "g"
+		exec.Steps[i].Join = JoinAll
+	}
+	return exec
+}
+
+func TestAdminActionStateRules(t *testing.T) {
+	ctx := context.Background()
+	retry := func(step int) func(o *Orchestrator) error {
+		return func(o *Orchestrator) error { return o.RetryStep(ctx, "ops", "saga-1", step, "fixed upstream") }
+	}
+	skip := func(step int) func(o *Orchestrator) error {
+		return func(o *Orchestrator) error {
+			return o.SkipStep(ctx, "ops", "saga-1", step, map[string]interface{}{"manual": true}, "done by hand")
+		}
+	}
+	force := func(o *Orchestrator) error { return o.ForceCompensation(ctx, "ops", "saga-1", "customer cancelled") }
+	abort := func(o *Orchestrator) error { return o.Abort(ctx, "ops", "saga-1", "test data") }
+
+	tests := []struct {
+		name      string
+		exec      *SagaExecution
+		action    func(o *Orchestrator) error
+		wantErr   error
+		wantSaga  SagaState
+		wantSteps []StepState
+	}{
+		{
+			name:      "retry a failed step",
+			exec:      execution(StateInProgress, StepCompleted, StepFailed),
+			action:    retry(1),
+			wantSaga:  StateInProgress,
+			wantSteps: []StepState{StepCompleted, StepInProgress},
+		},
+		{
+			name:      "retry a stuck step",
+			exec:      execution(StateInProgress, StepInProgress),
+			action:    retry(0),
+			wantSaga:  StateInProgress,
+			wantSteps: []StepState{StepInProgress},
+		},
+		{
+			name:    "retry a completed step",
+			exec:    execution(StateInProgress, StepCompleted, StepInProgress),
+			action:  retry(0),
+			wantErr: ErrActionNotAllowed,
+		},
+		{
+			name:      "retry a failed group member while its sibling runs",
+			exec:      grouped(execution(StateCompensating, StepFailed, StepInProgress), 0, 1),
+			action:    retry(0),
+			wantSaga:  StateInProgress,
+			wantSteps: []StepState{StepInProgress, StepInProgress},
+		},
+		{
+			name:    "retry a failed step once compensation was sent",
+			exec:    execution(StateCompensating, StepCompensating, StepFailed),
+			action:  retry(1),
+			wantErr: ErrActionNotAllowed,
+		},
+		{
+			name:    "retry a failed step once a step was compensated",
+			exec:    grouped(execution(StateCompensating, StepCompensated, StepFailed, StepInProgress), 1, 2),
+			action:  retry(1),
+			wantErr: ErrActionNotAllowed,
+		},
+		{
+			name:      "retry a stuck compensation",
+			exec:      execution(StateCompensating, StepCompensating, StepFailed),
+			action:    retry(0),
+			wantSaga:  StateCompensating,
+			wantSteps: []StepState{StepCompensating, StepFailed},
+		},
+		{
+			name:      "retry a compensation that gave up",
+			exec:      execution(StateFailed, StepCompensationFailed, StepFailed),
+			action:    retry(0),
+			wantSaga:  StateCompensating,
+			wantSteps: []StepState{StepCompensating, StepFailed},
+		},
+		{
+			name:    "retry on a completed saga",
+			exec:    execution(StateCompleted, StepCompleted),
+			action:  retry(0),
+			wantErr: ErrActionNotAllowed,
+		},
+		{
+			name:    "retry an unknown step",
+			exec:    execution(StateInProgress, StepFailed),
+			action:  retry(3),
+			wantErr: ErrStepNotFound,
+		},
+		{
+			name:      "skip a failed step",
+			exec:      execution(StateInProgress, StepCompleted, StepFailed),
+			action:    skip(1),
+			wantSaga:  StateCompleted,
+			wantSteps: []StepState{StepCompleted, StepSkipped},
+		},
+		{
+			name:    "skip a failed step of a compensating saga",
+			exec:    grouped(execution(StateCompensating, StepFailed, StepInProgress), 0, 1),
+			action:  skip(0),
+			wantErr: ErrActionNotAllowed,
+		},
+		{
+			name:    "skip a completed step",
+			exec:    execution(StateInProgress, StepCompleted, StepInProgress),
+			action:  skip(0),
+			wantErr: ErrActionNotAllowed,
+		},
+		{
+			name:      "force compensation of an in-progress saga",
+			exec:      execution(StateInProgress, StepCompleted, StepInProgress),
+			action:    force,
+			wantSaga:  StateCompensating,
+			wantSteps: []StepState{StepCompensating, StepFailed},
+		},
+		{
+			name:      "force compensation of a failed saga",
+			exec:      execution(StateFailed, StepCompensationFailed, StepFailed),
+			action:    force,
+			wantSaga:  StateCompensating,
+			wantSteps: []StepState{StepCompensating, StepFailed},
+		},
+		{
+			name:    "force compensation of a completed saga",
+			exec:    execution(StateCompleted, StepCompleted),
+			action:  force,
+			wantErr: ErrActionNotAllowed,
+		},
+		{
+			name:      "abort a compensating saga",
+			exec:      execution(StateCompensating, StepCompensating, StepFailed),
+			action:    abort,
+			wantSaga:  StateAborted,
+			wantSteps: []StepState{StepCompensating, StepFailed},
+		},
+		{
+			name:    "abort a compensated saga",
+			exec:    execution(StateCompensated, StepCompensated),
+			action:  abort,
+			wantErr: ErrActionNotAllowed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o, _ := newMemOrchestrator()
+			saveExecution(t, o, tt.exec)
+
+			err := tt.action(o)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("got %v, want %v", err, tt.wantErr)
+				}
+				// Nothing was changed or audited
+				if got := loadExecution(t, o, "saga-1"); got.State != tt.exec.State {
+					t.Errorf("saga state = %s, want %s", got.State, tt.exec.State)
+				}
+				if actions, _ := o.AdminActions(ctx, "saga-1"); len(actions) != 0 {
+					t.Errorf("recorded %d admin actions, want none", len(actions))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := loadExecution(t, o, "saga-1")
+			if got.State != tt.wantSaga {
+				t.Errorf("saga state = %s, want %s", got.State, tt.wantSaga)
+			}
+			for i, want := range tt.wantSteps {
+				if got.Steps[i].State != want {
+					t.Errorf("step %d state = %s, want %s", i, got.Steps[i].State, want)
+				}
+			}
+		})
+	}
+}
+
+func TestAdminActionAudit(t *testing.T) {
+	ctx := context.Background()
+	o, database := newMemOrchestrator()
+	saveExecution(t, o, execution(StateInProgress, StepCompleted, StepFailed))
+
+	if err := o.RetryStep(ctx, "", "saga-1", 1, "no actor"); err == nil {
+		t.Fatal("RetryStep() without an actor = nil, want an error")
+	}
+	if err := o.RetryStep(ctx, "alice", "saga-1", 1, "fixed upstream"); err != nil {
+		t.Fatal(err)
+	}
+
+	actions, err := o.AdminActions(ctx, "saga-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(actions) != 1 {
+		t.Fatalf("recorded %d admin actions, want 1", len(actions))
+	}
+	action := actions[0]
+	if action.Actor != "alice" || action.Action != AdminRetryStep || action.Reason != "fixed upstream" || action.StepIndex == nil || *action.StepIndex != 1 {
+		t.Errorf("admin action = %+v", action)
+	}
+
+	events, err := o.History(ctx, "saga-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var audited bool
+	for _, e := range events {
+		if e.Type == EventAdminAction {
+			audited = e.Payload["admin_action_id"] == action.ID && e.StepID == "step-1" && e.ToState == string(AdminRetryStep)
+		}
+	}
+	if !audited {
+		t.Errorf("journal has no matching %s event: %+v", EventAdminAction, events)
+	}
+
+	commands := database.published(CommandTopic("svc"))
+	if len(commands) != 1 || commands[0].Payload["command"] != "do-1" || commands[0].Payload["attempt"] != float64(2) {
+		t.Errorf("commands sent = %+v, want do-1 attempt 2", commands)
+	}
+}
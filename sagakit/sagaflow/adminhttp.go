@@ -0,0 +1,221 @@
+package sagaflow
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"shared/pkgs/rhttp"
+	"shared/sagakit"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AdminHandler exposes the Orchestrator admin operations over HTTP. Mount
+// it behind authentication; every write is attributed to Actor(c).
+type AdminHandler struct {
+	Orchestrator *Orchestrator
+	// Actor names the operator making the request. Defaults to the user ID
+	// from the JWT claims set by middleware.AuthcMiddleware.
+	Actor func(c *gin.Context) string
+}
+
+func NewAdminHandler(o *Orchestrator) *AdminHandler {
+	return &AdminHandler{
+		Orchestrator: o,
+		Actor: func(c *gin.Context) string {
+			return sagakit.TenantFromContext(c).UserID
+		},
+	}
+}
+
+// Register adds the admin routes to r
+func (h *AdminHandler) Register(r gin.IRoutes) {
+	r.GET("/sagas", h.list)
+	r.GET("/sagas/:id", h.get)
+	r.GET("/sagas/:id/timeline", h.timeline)
+	r.GET("/sagas/:id/history", h.history)
+	r.POST("/sagas/:id/steps/:index/retry", h.retryStep)
+	r.POST("/sagas/:id/steps/:index/skip", h.skipStep)
+	r.POST("/sagas/:id/compensate", h.forceCompensation)
+	r.POST("/sagas/:id/abort", h.abort)
+	r.POST("/sagas/:id/signals/:step", h.signal)
+}
+
+type adminRequest struct {
+	Reason string                 `json:"reason"`
+	Output map[string]interface{} `json:"output"`
+}
+
+func (h *AdminHandler) list(c *gin.Context) {
+	filter := ExecutionFilter{Name: c.Query("name"), BusinessKey: c.Query("business_key")}
+	if states := c.Query("state"); states != "" {
+		for _, st := range strings.Split(states, ",") {
+			filter.States = append(filter.States, SagaState(strings.ToUpper(strings.TrimSpace(st))))
+		}
+	}
+
+	var err error
+	if filter.CreatedFrom, err = parseQueryTime(c.Query("from")); err != nil {
+		rhttp.BadRequest(c, err)
+		return
+	}
+	if filter.CreatedTo, err = parseQueryTime(c.Query("to")); err != nil {
+		rhttp.BadRequest(c, err)
+		return
+	}
+	if filter.Limit, err = parseQueryInt(c.Query("limit")); err != nil {
+		rhttp.BadRequest(c, err)
+		return
+	}
+	if filter.Offset, err = parseQueryInt(c.Query("offset")); err != nil {
+		rhttp.BadRequest(c, err)
+		return
+	}
+
+	execs, err := h.Orchestrator.ListExecutions(c, filter)
+	if err != nil {
+		h.fail(c, err)
+		return
+	}
+	rhttp.OK(c, execs)
+}
+
+func (h *AdminHandler) get(c *gin.Context) {
+	exec, err := h.Orchestrator.GetExecution(c, c.Param("id"))
+	if err != nil {
+		h.fail(c, err)
+		return
+	}
+	actions, err := h.Orchestrator.AdminActions(c, exec.SagaID)
+	if err != nil {
+		h.fail(c, err)
+		return
+	}
+	rhttp.OK(c, gin.H{"execution": exec, "admin_actions": actions})
+}
+
+func (h *AdminHandler) timeline(c *gin.Context) {
+	timeline, err := h.Orchestrator.StepTimeline(c, c.Param("id"))
+	if err != nil {
+		h.fail(c, err)
+		return
+	}
+	rhttp.OK(c, timeline)
+}
+
+// history returns the execution journal, or a Mermaid sequence diagram
+// with ?format=mermaid
+func (h *AdminHandler) history(c *gin.Context) {
+	if c.Query("format") == "mermaid" {
+		diagram, err := h.Orchestrator.SequenceDiagram(c, c.Param("id"))
+		if err != nil {
+			h.fail(c, err)
+			return
+		}
+		c.String(http.StatusOK, diagram)
+		return
+	}
+
+	events, err := h.Orchestrator.History(c, c.Param("id"))
+	if err != nil {
+		h.fail(c, err)
+		return
+	}
+	rhttp.OK(c, events)
+}
+
+func (h *AdminHandler) retryStep(c *gin.Context) {
+	h.stepAction(c, func(actor string, index int, req adminRequest) error {
+		return h.Orchestrator.RetryStep(c, actor, c.Param("id"), index, req.Reason)
+	})
+}
+
+func (h *AdminHandler) skipStep(c *gin.Context) {
+	h.stepAction(c, func(actor string, index int, req adminRequest) error {
+		return h.Orchestrator.SkipStep(c, actor, c.Param("id"), index, req.Output, req.Reason)
+	})
+}
+
+func (h *AdminHandler) forceCompensation(c *gin.Context) {
+	h.sagaAction(c, func(actor string, req adminRequest) error {
+		return h.Orchestrator.ForceCompensation(c, actor, c.Param("id"), req.Reason)
+	})
+}
+
+func (h *AdminHandler) abort(c *gin.Context) {
+	h.sagaAction(c, func(actor string, req adminRequest) error {
+		return h.Orchestrator.Abort(c, actor, c.Param("id"), req.Reason)
+	})
+}
+
+// signal completes a wait step; the output of the request is merged into
+// the saga context
+func (h *AdminHandler) signal(c *gin.Context) {
+	h.sagaAction(c, func(actor string, req adminRequest) error {
+		return h.Orchestrator.Signal(c, actor, c.Param("id"), c.Param("step"), req.Output, req.Reason)
+	})
+}
+
+func (h *AdminHandler) stepAction(c *gin.Context, fn func(actor string, index int, req adminRequest) error) {
+	index, err := strconv.Atoi(c.Param("index"))
+	if err != nil {
+		rhttp.BadRequest(c, errors.New("step index must be an integer"))
+		return
+	}
+	h.sagaAction(c, func(actor string, req adminRequest) error {
+		return fn(actor, index, req)
+	})
+}
+
+func (h *AdminHandler) sagaAction(c *gin.Context, fn func(actor string, req adminRequest) error) {
+	actor := h.Actor(c)
+	if actor == "" {
+		rhttp.Unauthorized(c, errors.New("admin actions require an authenticated actor"))
+		return
+	}
+
+	var req adminRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			rhttp.BadRequest(c, err)
+			return
+		}
+	}
+
+	if err := fn(actor, req); err != nil {
+		h.fail(c, err)
+		return
+	}
+	rhttp.OK(c, nil)
+}
+
+func (h *AdminHandler) fail(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, ErrSagaNotFound), errors.Is(err, ErrStepNotFound):
+		rhttp.NotFound(c, err)
+	case errors.Is(err, ErrActionNotAllowed):
+		rhttp.Conflict(c, err)
+	default:
+		rhttp.Internal(c, err)
+	}
+}
+
+func parseQueryTime(v string) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.DateOnly, v)
+}
+
+func parseQueryInt(v string) (int, error) {
+	if v == "" {
+		return 0, nil
+	}
+	return strconv.Atoi(v)
+}
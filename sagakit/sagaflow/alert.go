@@ -0,0 +1,67 @@
+package sagaflow
+
+import (
+	"time"
+
+	"shared/sagakit"
+)
+
+// DefaultCompensationRetry is used when Orchestrator.CompensationRetry is nil
+var DefaultCompensationRetry = &RetryPolicy{
+	MaxAttempts:    5,
+	InitialBackoff: 2 * time.Second,
+	MaxBackoff:     5 * time.Minute,
+	Multiplier:     2,
+	Jitter:         0.2,
+}
+
+// DefaultCompensationTimeout bounds a compensation attempt when neither
+// Orchestrator.CompensationTimeout nor the step Timeout is set
+const DefaultCompensationTimeout = 5 * time.Minute
+
+// SagaAlert reports a saga that ended FAILED because some compensations
+// could not be completed
+type SagaAlert struct {
+	SagaID       string       `json:"saga_id"`
+	SagaName     string       `json:"saga_name"`
+	ErrorMessage string       `json:"error_message,omitempty"`
+	FailedSteps  []FailedStep `json:"failed_steps"`
+	FailedAt     time.Time    `json:"failed_at"`
+}
+
+// FailedStep is a step whose compensation gave up
+type FailedStep struct {
+	StepIndex int    `json:"step_index"`
+	StepID    string `json:"step_id"`
+	Service   string `json:"service"`
+	// Compensate is the compensation command that kept failing
+	Compensate string `json:"compensate"`
+	Attempts   int    `json:"attempts"`
+	Error      string `json:"error"`
+}
+
+// raiseAlert publishes a SagaAlert on TopicSagaFailed in the transaction
+// that failed the saga
+func (o *Orchestrator) raiseAlert(uow sagakit.UnitOfWork, exec *SagaExecution, failed []int) error {
+	alert := SagaAlert{
+		SagaID:       exec.SagaID,
+		SagaName:     exec.SagaName,
+		ErrorMessage: exec.ErrorMessage,
+		FailedAt:     time.Now(),
+	}
+	for _, i := range failed {
+		step := exec.Steps[i]
+		alert.FailedSteps = append(alert.FailedSteps, FailedStep{
+			StepIndex:  i,
+			StepID:     step.StepID,
+			Service:    step.Service,
+			Compensate: step.Compensate,
+			Attempts:   step.CompensationAttempts,
+			Error:      step.ErrorMessage,
+		})
+	}
+
+	return uow.Publish(TopicSagaFailed, alert, map[string]string{
+		"saga_id": exec.SagaID,
+	})
+}
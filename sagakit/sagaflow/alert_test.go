@@ -0,0 +1,106 @@
+package sagaflow
+
+import (
+	"context"
+	"testing"
+)
+
+func TestCompensationGivesUpWithAlert(t *testing.T) {
+	ctx := context.Background()
+	o, database := newMemOrchestrator()
+	o.CompensationRetry = &RetryPolicy{MaxAttempts: 2}
+
+	exec := execution(StateCompensating, StepCompensating, StepFailed)
+	exec.Steps[0].CompensationAttempts = 1
+	exec.ErrorMessage = "step-1 failed"
+	saveExecution(t, o, exec)
+
+	if err := o.HandleCompensationError(ctx, "saga-1", 0, StepError{Class: "unavailable", Message: "refunds down"}); err != nil {
+		t.Fatal(err)
+	}
+	got := loadExecution(t, o, "saga-1")
+	if got.State != StateCompensating || got.Steps[0].State != StepCompensating || got.Steps[0].CompensationAttempts != 2 {
+		t.Fatalf("after the first failure: saga %s, step %s attempt %d; want the compensation sent again", got.State, got.Steps[0].State, got.Steps[0].CompensationAttempts)
+	}
+	if n := len(database.published(CompensateTopic("svc"))); n != 1 {
+		t.Errorf("sent %d compensations, want 1", n)
+	}
+	if n := len(database.published(TopicSagaFailed)); n != 0 {
+		t.Errorf("raised %d alerts while retrying, want none", n)
+	}
+
+	if err := o.HandleCompensationError(ctx, "saga-1", 0, StepError{Class: "unavailable", Message: "refunds still down"}); err != nil {
+		t.Fatal(err)
+	}
+	got = loadExecution(t, o, "saga-1")
+	if got.State != StateFailed || got.Steps[0].State != StepCompensationFailed {
+		t.Fatalf("after giving up: saga %s, step %s; want %s and %s", got.State, got.Steps[0].State, StateFailed, StepCompensationFailed)
+	}
+
+	alerts := database.published(TopicSagaFailed)
+	if len(alerts) != 1 {
+		t.Fatalf("raised %d alerts, want 1", len(alerts))
+	}
+	alert := alerts[0]
+	if alert.Payload["saga_id"] != "saga-1" || alert.Payload["error_message"] != "step-1 failed" || alert.Metadata["saga_id"] != "saga-1" {
+		t.Errorf("alert = %+v", alert)
+	}
+	failed := alert.Payload["failed_steps"].([]interface{})
+	if len(failed) != 1 {
+		t.Fatalf("alert lists %d failed steps, want 1", len(failed))
+	}
+	step := failed[0].(map[string]interface{})
+	if step["step_id"] != "step-0" || step["compensate"] != "undo-0" || step["attempts"] != float64(2) || step["error"] != "unavailable: refunds still down" {
+		t.Errorf("failed step = %+v", step)
+	}
+}
+
+func TestCompensationAlertWaitsForOtherCompensations(t *testing.T) {
+	ctx := context.Background()
+	o, database := newMemOrchestrator()
+	o.CompensationRetry = &RetryPolicy{MaxAttempts: 1}
+	exec := execution(StateCompensating, StepCompensating, StepCompensating, StepFailed)
+	exec.Steps[0].CompensationAttempts = 1
+	exec.Steps[1].CompensationAttempts = 1
+	saveExecution(t, o, exec)
+
+	if err := o.HandleCompensationFailure(ctx, "saga-1", 1, "cannot undo"); err != nil {
+		t.Fatal(err)
+	}
+	if got := loadExecution(t, o, "saga-1"); got.State != StateCompensating {
+		t.Fatalf("saga %s while step 0 is compensating, want %s", got.State, StateCompensating)
+	}
+	if n := len(database.published(TopicSagaFailed)); n != 0 {
+		t.Fatalf("raised %d alerts before compensation ended, want none", n)
+	}
+
+	if err := o.HandleCompensationSuccess(ctx, "saga-1", 0); err != nil {
+		t.Fatal(err)
+	}
+	if got := loadExecution(t, o, "saga-1"); got.State != StateFailed {
+		t.Fatalf("saga %s, want %s", got.State, StateFailed)
+	}
+	alerts := database.published(TopicSagaFailed)
+	if len(alerts) != 1 {
+		t.Fatalf("raised %d alerts, want 1", len(alerts))
+	}
+	failed := alerts[0].Payload["failed_steps"].([]interface{})
+	if len(failed) != 1 || failed[0].(map[string]interface{})["step_index"] != float64(1) {
+		t.Errorf("failed steps = %+v, want step 1 only", failed)
+	}
+}
+
+func TestCompensationWithoutFailuresRaisesNoAlert(t *testing.T) {
+	o, database := newMemOrchestrator()
+	saveExecution(t, o, execution(StateCompensating, StepCompensating, StepFailed))
+
+	if err := o.HandleCompensationSuccess(context.Background(), "saga-1", 0); err != nil {
+		t.Fatal(err)
+	}
+	if got := loadExecution(t, o, "saga-1"); got.State != StateCompensated {
+		t.Errorf("saga %s, want %s", got.State, StateCompensated)
+	}
+	if n := len(database.published(TopicSagaFailed)); n != 0 {
+		t.Errorf("raised %d alerts, want none", n)
+	}
+}
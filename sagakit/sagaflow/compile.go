@@ -0,0 +1,78 @@
+package sagaflow
+
+import "shared/pkgs/evaluate"
+
+// compileSteps flattens a saga definition into the step executions stored
+// for a run. Members of a parallel group become consecutive steps sharing
+// the group's ID; the cases of a branch become consecutive steps sharing
+// the branch's membership.
+func compileSteps(steps []Step, initialContext map[string]interface{}) []StepExecution {
+	c := &compiler{initialContext: initialContext}
+	for _, step := range steps {
+		c.step(step, nil)
+	}
+	return c.execs
+}
+
+type compiler struct {
+	initialContext map[string]interface{}
+	execs          []StepExecution
+}
+
+func (c *compiler) step(step Step, branch *BranchMembership) {
+	switch {
+	case len(step.Branch) > 0:
+		conditions := make([]*evaluate.ConditionNode, len(step.Branch))
+		for i, bc := range step.Branch {
+			conditions[i] = bc.When
+		}
+		for i, bc := range step.Branch {
+			membership := &BranchMembership{ID: step.ID, Case: i, Conditions: conditions}
+			for _, s := range bc.Steps {
+				c.step(s, membership)
+			}
+		}
+
+	case len(step.Parallel) > 0:
+		join := step.Join
+		if join == "" {
+			join = JoinAll
+		}
+		for _, member := range step.Parallel {
+			c.add(member, step.ID, join, branch)
+		}
+
+	default:
+		c.add(step, "", "", branch)
+	}
+}
+
+func (c *compiler) add(step Step, groupID string, join JoinPolicy, branch *BranchMembership) {
+	// Merge saga context with step payload
+	stepInput := make(map[string]interface{})
+	for k, v := range c.initialContext {
+		stepInput[k] = v
+	}
+	for k, v := range step.Payload {
+		stepInput[k] = v
+	}
+
+	c.execs = append(c.execs, StepExecution{
+		StepID:      step.ID,
+		StepIndex:   len(c.execs),
+		State:       StepPending,
+		Service:     step.Service,
+		Command:     step.Command,
+		Compensate:  step.Compensate,
+		Input:       stepInput,
+		Attempts:    0,
+		GroupID:     groupID,
+		Join:        join,
+		Condition:   step.When,
+		Branch:      branch,
+		RetryPolicy: step.retryPolicy(),
+		Timeout:     step.Timeout,
+		Wait:        step.WaitFor,
+		SubSaga:     step.SubSaga,
+	})
+}
@@ -0,0 +1,49 @@
+package sagaflow
+
+import (
+	"fmt"
+
+	"shared/pkgs/evaluate"
+)
+
+// BranchCase is one alternative of a branch step. A case without When
+// always matches, which makes it the default when listed last.
+type BranchCase struct {
+	When  *evaluate.ConditionNode
+	Steps []Step
+}
+
+// BranchMembership is stored on every step compiled from a branch case
+type BranchMembership struct {
+	ID   string `bson:"id" json:"id"`
+	Case int    `bson:"case" json:"case"`
+	// Conditions holds the When of every case of the branch, in order
+	Conditions []*evaluate.ConditionNode `bson:"conditions" json:"conditions"`
+}
+
+// evalCondition evaluates a guard against the saga context; a nil guard holds
+func evalCondition(cond *evaluate.ConditionNode, sagaContext map[string]interface{}) (bool, error) {
+	if cond == nil {
+		return true, nil
+	}
+	ok, err := evaluate.Evaluate(*cond, sagaContext)
+	if err != nil {
+		return false, fmt.Errorf("condition: %w", err)
+	}
+	return ok, nil
+}
+
+// chooseBranchCase returns the first case of the branch whose condition
+// holds, or -1 when none does
+func chooseBranchCase(b *BranchMembership, sagaContext map[string]interface{}) (int, error) {
+	for i, cond := range b.Conditions {
+		ok, err := evalCondition(cond, sagaContext)
+		if err != nil {
+			return -1, err
+		}
+		if ok {
+			return i, nil
+		}
+	}
+	return -1, nil
+}
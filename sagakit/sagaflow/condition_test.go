@@ -0,0 +1,98 @@
+package sagaflow
+
+import (
+	"testing"
+
+	"shared/pkgs/evaluate"
+)
+
+func cond(lhs, op string, rhs interface{}) *evaluate.ConditionNode {
+	return &evaluate.ConditionNode{LHS: lhs, Op: op, RHS: rhs}
+}
+
+func TestEvalCondition(t *testing.T) {
+	sagaContext := map[string]interface{}{"amount": 150, "country": "FR"}
+
+	tests := []struct {
+		name    string
+		cond    *evaluate.ConditionNode
+		want    bool
+		wantErr bool
+	}{
+		{name: "no guard", cond: nil, want: true},
+		{name: "holds", cond: cond("amount", ">", 100), want: true},
+		{name: "does not hold", cond: cond("country", "==", "DE"), want: false},
+		{
+			name: "all",
+			cond: &evaluate.ConditionNode{All: []evaluate.ConditionNode{*cond("amount", ">", 100), *cond("country", "==", "FR")}},
+			want: true,
+		},
+		{
+			name: "any",
+			cond: &evaluate.ConditionNode{Any: []evaluate.ConditionNode{*cond("amount", ">", 1000), *cond("country", "==", "DE")}},
+			want: false,
+		},
+		{name: "unknown variable", cond: cond("missing", "==", 1), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := evalCondition(tt.cond, sagaContext)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("evalCondition() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("evalCondition() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChooseBranchCase(t *testing.T) {
+	steps := []Step{{
+		ID: "payment",
+		Branch: []BranchCase{
+			{When: cond("amount", ">", 1000), Steps: []Step{action("manual-review"), action("charge-large")}},
+			{When: cond("method", "==", "card"), Steps: []Step{action("charge-card")}},
+			{Steps: []Step{action("invoice")}},
+		},
+	}}
+	execs := compileSteps(steps, nil)
+	if len(execs) != 4 {
+		t.Fatalf("compileSteps() returned %d steps, want 4", len(execs))
+	}
+	for i, wantCase := range []int{0, 0, 1, 2} {
+		if b := execs[i].Branch; b == nil || b.ID != "payment" || b.Case != wantCase {
+			t.Fatalf("step %d branch = %+v, want case %d of payment", i, b, wantCase)
+		}
+	}
+
+	tests := []struct {
+		name        string
+		sagaContext map[string]interface{}
+		want        int
+	}{
+		{"first case", map[string]interface{}{"amount": 5000, "method": "card"}, 0},
+		{"second case", map[string]interface{}{"amount": 10, "method": "card"}, 1},
+		{"default case", map[string]interface{}{"amount": 10, "method": "wire"}, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := chooseBranchCase(execs[0].Branch, tt.sagaContext)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("chooseBranchCase() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("no default", func(t *testing.T) {
+		b := &BranchMembership{ID: "b", Conditions: []*evaluate.ConditionNode{cond("amount", ">", 1000)}}
+		got, err := chooseBranchCase(b, map[string]interface{}{"amount": 1})
+		if err != nil || got != -1 {
+			t.Errorf("chooseBranchCase() = %d, %v; want -1, nil", got, err)
+		}
+	})
+}
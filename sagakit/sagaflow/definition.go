@@ -0,0 +1,272 @@
+package sagaflow
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"shared/pkgs/evaluate"
+
+	"github.com/goccy/go-yaml"
+)
+
+// definitionDoc is the file/DB representation of a Saga. Durations are
+// written as Go duration strings ("30s", "5m").
+type definitionDoc struct {
+	Name    string    `json:"name"`
+	Version int       `json:"version"`
+	Timeout string    `json:"timeout,omitempty"`
+	Steps   []stepDoc `json:"steps"`
+}
+
+type stepDoc struct {
+	ID           string                  `json:"id"`
+	Service      string                  `json:"service,omitempty"`
+	Command      string                  `json:"command,omitempty"`
+	Payload      map[string]any          `json:"payload,omitempty"`
+	Compensate   string                  `json:"compensate,omitempty"`
+	NoCompensate bool                    `json:"no_compensate,omitempty"`
+	MaxRetries   int                     `json:"max_retries,omitempty"`
+	Retry        *retryDoc               `json:"retry,omitempty"`
+	Timeout      string                  `json:"timeout,omitempty"`
+	Parallel     []stepDoc               `json:"parallel,omitempty"`
+	Join         JoinPolicy              `json:"join,omitempty"`
+	When         *evaluate.ConditionNode `json:"when,omitempty"`
+	Branch       []caseDoc               `json:"branch,omitempty"`
+	WaitFor      *WaitSpec               `json:"wait_for,omitempty"`
+	SubSaga      *SubSagaSpec            `json:"sub_saga,omitempty"`
+}
+
+type retryDoc struct {
+	MaxAttempts     int      `json:"max_attempts"`
+	InitialBackoff  string   `json:"initial_backoff,omitempty"`
+	MaxBackoff      string   `json:"max_backoff,omitempty"`
+	Multiplier      float64  `json:"multiplier,omitempty"`
+	Jitter          float64  `json:"jitter,omitempty"`
+	RetryableErrors []string `json:"retryable_errors,omitempty"`
+}
+
+type caseDoc struct {
+	When  *evaluate.ConditionNode `json:"when,omitempty"`
+	Steps []stepDoc               `json:"steps"`
+}
+
+// ParseDefinition decodes a saga definition written in YAML or JSON.
+// Unknown fields are rejected; the result is not validated.
+func ParseDefinition(data []byte) (Saga, error) {
+	jsonData, err := yaml.YAMLToJSON(data)
+	if err != nil {
+		return Saga{}, fmt.Errorf("invalid saga definition: %w", err)
+	}
+
+	var doc definitionDoc
+	dec := json.NewDecoder(bytes.NewReader(jsonData))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&doc); err != nil {
+		return Saga{}, fmt.Errorf("invalid saga definition: %w", err)
+	}
+
+	return doc.toSaga()
+}
+
+// MarshalDefinition encodes a saga definition as JSON, the inverse of
+// ParseDefinition
+func MarshalDefinition(s Saga) ([]byte, error) {
+	doc := definitionDoc{
+		Name:    s.Name,
+		Version: s.Version,
+		Timeout: formatDuration(s.Timeout),
+	}
+	for _, st := range s.Steps {
+		doc.Steps = append(doc.Steps, stepToDoc(st))
+	}
+	return json.Marshal(doc)
+}
+
+// LoadDefinitionFile reads, parses and validates a single definition file
+func LoadDefinitionFile(path string, knownServices ...string) (Saga, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Saga{}, err
+	}
+
+	s, err := ParseDefinition(data)
+	if err != nil {
+		return Saga{}, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := s.Validate(knownServices...); err != nil {
+		return Saga{}, fmt.Errorf("%s: %w", path, err)
+	}
+	return s, nil
+}
+
+// LoadDefinitionDir loads every .yaml, .yml and .json file in dir
+func LoadDefinitionDir(dir string, knownServices ...string) ([]Saga, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var sagas []Saga
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		switch strings.ToLower(filepath.Ext(e.Name())) {
+		case ".yaml", ".yml", ".json":
+		default:
+			continue
+		}
+
+		s, err := LoadDefinitionFile(filepath.Join(dir, e.Name()), knownServices...)
+		if err != nil {
+			return nil, err
+		}
+		sagas = append(sagas, s)
+	}
+	return sagas, nil
+}
+
+func (d definitionDoc) toSaga() (Saga, error) {
+	timeout, err := parseDuration("timeout", d.Timeout)
+	if err != nil {
+		return Saga{}, err
+	}
+
+	s := Saga{Name: d.Name, Version: d.Version, Timeout: timeout}
+	for _, sd := range d.Steps {
+		st, err := sd.toStep()
+		if err != nil {
+			return Saga{}, err
+		}
+		s.Steps = append(s.Steps, st)
+	}
+	return s, nil
+}
+
+func (d stepDoc) toStep() (Step, error) {
+	timeout, err := parseDuration(d.ID+".timeout", d.Timeout)
+	if err != nil {
+		return Step{}, err
+	}
+
+	st := Step{
+		ID:           d.ID,
+		Service:      d.Service,
+		Command:      d.Command,
+		Payload:      d.Payload,
+		Compensate:   d.Compensate,
+		NoCompensate: d.NoCompensate,
+		MaxRetries:   d.MaxRetries,
+		Timeout:      timeout,
+		Join:         d.Join,
+		When:         d.When,
+		WaitFor:      d.WaitFor,
+		SubSaga:      d.SubSaga,
+	}
+
+	if d.Retry != nil {
+		initial, err := parseDuration(d.ID+".retry.initial_backoff", d.Retry.InitialBackoff)
+		if err != nil {
+			return Step{}, err
+		}
+		maxBackoff, err := parseDuration(d.ID+".retry.max_backoff", d.Retry.MaxBackoff)
+		if err != nil {
+			return Step{}, err
+		}
+		st.Retry = &RetryPolicy{
+			MaxAttempts:     d.Retry.MaxAttempts,
+			InitialBackoff:  initial,
+			MaxBackoff:      maxBackoff,
+			Multiplier:      d.Retry.Multiplier,
+			Jitter:          d.Retry.Jitter,
+			RetryableErrors: d.Retry.RetryableErrors,
+		}
+	}
+
+	for _, md := range d.Parallel {
+		member, err := md.toStep()
+		if err != nil {
+			return Step{}, err
+		}
+		st.Parallel = append(st.Parallel, member)
+	}
+
+	for _, cd := range d.Branch {
+		bc := BranchCase{When: cd.When}
+		for _, sd := range cd.Steps {
+			cs, err := sd.toStep()
+			if err != nil {
+				return Step{}, err
+			}
+			bc.Steps = append(bc.Steps, cs)
+		}
+		st.Branch = append(st.Branch, bc)
+	}
+
+	return st, nil
+}
+
+func stepToDoc(st Step) stepDoc {
+	d := stepDoc{
+		ID:           st.ID,
+		Service:      st.Service,
+		Command:      st.Command,
+		Payload:      st.Payload,
+		Compensate:   st.Compensate,
+		NoCompensate: st.NoCompensate,
+		MaxRetries:   st.MaxRetries,
+		Timeout:      formatDuration(st.Timeout),
+		Join:         st.Join,
+		When:         st.When,
+		WaitFor:      st.WaitFor,
+		SubSaga:      st.SubSaga,
+	}
+
+	if st.Retry != nil {
+		d.Retry = &retryDoc{
+			MaxAttempts:     st.Retry.MaxAttempts,
+			InitialBackoff:  formatDuration(st.Retry.InitialBackoff),
+			MaxBackoff:      formatDuration(st.Retry.MaxBackoff),
+			Multiplier:      st.Retry.Multiplier,
+			Jitter:          st.Retry.Jitter,
+			RetryableErrors: st.Retry.RetryableErrors,
+		}
+	}
+
+	for _, member := range st.Parallel {
+		d.Parallel = append(d.Parallel, stepToDoc(member))
+	}
+
+	for _, bc := range st.Branch {
+		cd := caseDoc{When: bc.When}
+		for _, cs := range bc.Steps {
+			cd.Steps = append(cd.Steps, stepToDoc(cs))
+		}
+		d.Branch = append(d.Branch, cd)
+	}
+
+	return d
+}
+
+func parseDuration(field, v string) (time.Duration, error) {
+	if v == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", field, err)
+	}
+	return d, nil
+}
+
+func formatDuration(d time.Duration) string {
+	if d == 0 {
+		return ""
+	}
+	return d.String()
+}
@@ -0,0 +1,55 @@
+package sagaflow
+
+// JoinPolicy decides when a parallel step group is done
+type JoinPolicy string
+
+const (
+	// JoinAll completes the group once every member succeeded. The first
+	// member that fails (after its retries) fails the group right away;
+	// members still in flight are compensated when they report back.
+	JoinAll JoinPolicy = "ALL"
+	// JoinAllSettled waits for every member to finish before failing the
+	// group when one of them failed
+	JoinAllSettled JoinPolicy = "ALL_SETTLED"
+)
+
+// groupRange returns the half-open range of steps that are dispatched
+// together with the step at index: the members of its group, or just the
+// step itself
+func (e *SagaExecution) groupRange(index int) (start, end int) {
+	start, end = index, index+1
+	groupID := e.Steps[index].GroupID
+	if groupID == "" {
+		return start, end
+	}
+	for start > 0 && e.Steps[start-1].GroupID == groupID {
+		start--
+	}
+	for end < len(e.Steps) && e.Steps[end].GroupID == groupID {
+		end++
+	}
+	return start, end
+}
+
+// allCompleted reports whether every step in [start, end) completed or was skipped
+func (e *SagaExecution) allCompleted(start, end int) bool {
+	for i := start; i < end; i++ {
+		switch e.Steps[i].State {
+		case StepCompleted, StepSkipped:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// settled reports whether no step in [start, end) is still pending or in flight
+func (e *SagaExecution) settled(start, end int) bool {
+	for i := start; i < end; i++ {
+		switch e.Steps[i].State {
+		case StepPending, StepInProgress:
+			return false
+		}
+	}
+	return true
+}
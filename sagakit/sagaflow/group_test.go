@@ -0,0 +1,111 @@
+package sagaflow
+
+import "testing"
+
+func action(id string) Step {
+	return Step{ID: id, Service: "svc", Command: id, NoCompensate: true}
+}
+
+func TestCompileStepsGroups(t *testing.T) {
+	steps := []Step{
+		action("reserve"),
+		{ID: "notify", Parallel: []Step{action("email"), action("sms")}},
+		{ID: "ship", Join: JoinAllSettled, Parallel: []Step{action("label"), action("pickup")}},
+		action("close"),
+	}
+
+	want := []struct {
+		stepID  string
+		groupID string
+		join    JoinPolicy
+	}{
+		{"reserve", "", ""},
+		{"email", "notify", JoinAll},
+		{"sms", "notify", JoinAll},
+		{"label", "ship", JoinAllSettled},
+		{"pickup", "ship", JoinAllSettled},
+		{"close", "", ""},
+	}
+
+	execs := compileSteps(steps, map[string]interface{}{"order_id": "o-1"})
+	if len(execs) != len(want) {
+		t.Fatalf("compileSteps() returned %d steps, want %d", len(execs), len(want))
+	}
+	for i, w := range want {
+		got := execs[i]
+		if got.StepID != w.stepID || got.GroupID != w.groupID || got.Join != w.join || got.StepIndex != i {
+			t.Errorf("step %d = {%s %q %q index %d}, want {%s %q %q index %d}",
+				i, got.StepID, got.GroupID, got.Join, got.StepIndex, w.stepID, w.groupID, w.join, i)
+		}
+		if got.State != StepPending || got.Input["order_id"] != "o-1" {
+			t.Errorf("step %d: state %s, input %v", i, got.State, got.Input)
+		}
+	}
+}
+
+func groupExecution(states ...StepState) *SagaExecution {
+	// step 0 stands alone, the others form group "g"
+	exec := &SagaExecution{}
+	for i, state := range states {
+		step := StepExecution{StepIndex: i, State: state}
+		if i > 0 {
+			step.GroupID = "g"
+		}
+		exec.Steps = append(exec.Steps, step)
+	}
+	return exec
+}
+
+func TestGroupRange(t *testing.T) {
+	exec := groupExecution(StepCompleted, StepPending, StepPending, StepPending)
+	exec.Steps = append(exec.Steps, StepExecution{StepIndex: 4, State: StepPending})
+
+	tests := []struct {
+		index      int
+		start, end int
+	}{
+		{0, 0, 1},
+		{1, 1, 4},
+		{2, 1, 4},
+		{3, 1, 4},
+		{4, 4, 5},
+	}
+	for _, tt := range tests {
+		start, end := exec.groupRange(tt.index)
+		if start != tt.start || end != tt.end {
+			t.Errorf("groupRange(%d) = [%d, %d), want [%d, %d)", tt.index, start, end, tt.start, tt.end)
+		}
+	}
+}
+
+func TestGroupJoin(t *testing.T) {
+	tests := []struct {
+		name          string
+		members       []StepState
+		wantCompleted bool
+		wantSettled   bool
+	}{
+		{"all in flight", []StepState{StepInProgress, StepInProgress}, false, false},
+		{"one done", []StepState{StepCompleted, StepInProgress}, false, false},
+		{"all done", []StepState{StepCompleted, StepCompleted}, true, true},
+		{"done or skipped", []StepState{StepCompleted, StepSkipped}, true, true},
+		{"failed while another runs", []StepState{StepFailed, StepInProgress}, false, false},
+		{"failed and done", []StepState{StepFailed, StepCompleted}, false, true},
+		{"not dispatched yet", []StepState{StepCompleted, StepPending}, false, false},
+		{"compensated", []StepState{StepCompensated, StepFailed}, false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exec := groupExecution(append([]StepState{StepCompleted}, tt.members...)...)
+			start, end := exec.groupRange(1)
+
+			if got := exec.allCompleted(start, end); got != tt.wantCompleted {
+				t.Errorf("allCompleted() = %v, want %v", got, tt.wantCompleted)
+			}
+			if got := exec.settled(start, end); got != tt.wantSettled {
+				t.Errorf("settled() = %v, want %v", got, tt.wantSettled)
+			}
+		})
+	}
+}
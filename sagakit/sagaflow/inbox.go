@@ -0,0 +1,93 @@
+package sagaflow
+
+import (
+	"context"
+	"time"
+
+	"shared/sagakit/db"
+	"shared/sagakit/mgo"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Inbox records the saga messages a participant has already handled
+type Inbox interface {
+	InitSchema(ctx context.Context, tx db.Tx) error
+	// ClaimTx records key for service and reports false when it was
+	// already recorded, i.e. the message is a redelivery
+	ClaimTx(ctx context.Context, tx db.Tx, service, key string) (bool, error)
+}
+
+// PostgresInbox implements Inbox for PostgreSQL
+type PostgresInbox struct{}
+
+func NewPostgresInbox() *PostgresInbox {
+	return &PostgresInbox{}
+}
+
+func (i *PostgresInbox) InitSchema(ctx context.Context, tx db.Tx) error {
+	return tx.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS saga_participant_inbox (
+			service TEXT NOT NULL,
+			message_key TEXT NOT NULL,
+			processed_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+			PRIMARY KEY (service, message_key)
+		)
+	`)
+}
+
+func (i *PostgresInbox) ClaimTx(ctx context.Context, tx db.Tx, service, key string) (bool, error) {
+	rows, err := tx.Query(ctx, `
+		INSERT INTO saga_participant_inbox (service, message_key)
+		VALUES ($1, $2)
+		ON CONFLICT DO NOTHING
+		RETURNING message_key
+	`, service, key)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	return rows.Next(), nil
+}
+
+// MongoInbox implements Inbox on a Mongo collection keyed by service and
+// message key. It must be used with transactions from mgo.DB.
+type MongoInbox struct {
+	Collection string
+}
+
+func NewMongoInbox() *MongoInbox {
+	return &MongoInbox{Collection: "saga_participant_inbox"}
+}
+
+// InitSchema does nothing: the document _id already enforces uniqueness
+func (i *MongoInbox) InitSchema(ctx context.Context, tx db.Tx) error {
+	return nil
+}
+
+func (i *MongoInbox) ClaimTx(ctx context.Context, tx db.Tx, service, key string) (bool, error) {
+	t, err := mgo.FromTx(tx)
+	if err != nil {
+		return false, err
+	}
+	sc := t.Context(ctx)
+	coll := t.Collection(i.Collection)
+	id := service + "/" + key
+
+	// Look before inserting: a duplicate key error would abort the
+	// transaction. A concurrent insert still fails the loser, whose message
+	// is redelivered and then found here.
+	n, err := coll.CountDocuments(sc, bson.M{"_id": id})
+	if err != nil || n > 0 {
+		return false, err
+	}
+
+	_, err = coll.InsertOne(sc, bson.M{
+		"_id":          id,
+		"service":      service,
+		"message_key":  key,
+		"processed_at": time.Now(),
+	})
+	return err == nil, err
+}
@@ -0,0 +1,163 @@
+package sagaflow
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"shared/sagakit"
+)
+
+// SagaEventType classifies a journal entry
+type SagaEventType string
+
+const (
+	EventSagaState        SagaEventType = "SAGA_STATE"
+	EventStepState        SagaEventType = "STEP_STATE"
+	EventCommandSent      SagaEventType = "COMMAND_SENT"
+	EventCompensationSent SagaEventType = "COMPENSATION_SENT"
+	EventWaitStarted      SagaEventType = "WAIT_STARTED"
+	EventSubSagaStarted   SagaEventType = "SUB_SAGA_STARTED"
+	EventAdminAction      SagaEventType = "ADMIN_ACTION"
+)
+
+// SagaEvent is one entry of the append-only execution journal. Step fields
+// are empty for saga-level events.
+type SagaEvent struct {
+	ID        int64                  `bson:"id" json:"id"`
+	SagaID    string                 `bson:"saga_id" json:"saga_id"`
+	Type      SagaEventType          `bson:"type" json:"type"`
+	StepIndex *int                   `bson:"step_index,omitempty" json:"step_index,omitempty"`
+	StepID    string                 `bson:"step_id,omitempty" json:"step_id,omitempty"`
+	Service   string                 `bson:"service,omitempty" json:"service,omitempty"`
+	FromState string                 `bson:"from_state,omitempty" json:"from_state,omitempty"`
+	ToState   string                 `bson:"to_state,omitempty" json:"to_state,omitempty"`
+	Attempt   int                    `bson:"attempt,omitempty" json:"attempt,omitempty"`
+	Payload   map[string]interface{} `bson:"payload,omitempty" json:"payload,omitempty"`
+	Error     string                 `bson:"error,omitempty" json:"error,omitempty"`
+	CreatedAt time.Time              `bson:"created_at" json:"created_at"`
+}
+
+// History returns the journal of a saga in the order it was written
+func (o *Orchestrator) History(ctx context.Context, sagaID string) ([]SagaEvent, error) {
+	var events []SagaEvent
+	err := o.runInTx(ctx, func(uow sagakit.UnitOfWork) error {
+		if _, err := o.StateStore.GetExecution(ctx, uow.Tx(), sagaID); err != nil {
+			return err
+		}
+		var err error
+		events, err = o.StateStore.ListEvents(ctx, uow.Tx(), sagaID)
+		return err
+	})
+	return events, err
+}
+
+// SequenceDiagram returns the journal of a saga as a Mermaid sequence diagram
+func (o *Orchestrator) SequenceDiagram(ctx context.Context, sagaID string) (string, error) {
+	events, err := o.History(ctx, sagaID)
+	if err != nil {
+		return "", err
+	}
+	return MermaidSequence(events), nil
+}
+
+// recordStepEvent journals a change of the step at stepIndex
+func (o *Orchestrator) recordStepEvent(ctx context.Context, uow sagakit.UnitOfWork, exec *SagaExecution, stepIndex int, eventType SagaEventType, from StepState, attempt int, payload map[string]interface{}, errMsg string) error {
+	step := exec.Steps[stepIndex]
+	return o.StateStore.AppendEvent(ctx, uow.Tx(), &SagaEvent{
+		SagaID:    exec.SagaID,
+		Type:      eventType,
+		StepIndex: &stepIndex,
+		StepID:    step.StepID,
+		Service:   step.Service,
+		FromState: string(from),
+		ToState:   string(step.State),
+		Attempt:   attempt,
+		Payload:   payload,
+		Error:     errMsg,
+		CreatedAt: time.Now(),
+	})
+}
+
+// MermaidSequence renders events as a Mermaid sequence diagram between the
+// orchestrator and the participating services
+func MermaidSequence(events []SagaEvent) string {
+	const orchestrator = "Orchestrator"
+
+	var b strings.Builder
+	b.WriteString("sequenceDiagram\n")
+	b.WriteString("    participant " + orchestrator + "\n")
+
+	seen := map[string]bool{}
+	for _, e := range events {
+		if e.Service != "" && !seen[e.Service] {
+			seen[e.Service] = true
+			fmt.Fprintf(&b, "    participant %s\n", mermaidID(e.Service))
+		}
+	}
+
+	for _, e := range events {
+		svc := mermaidID(e.Service)
+		switch e.Type {
+		case EventCommandSent:
+			if e.Service == "" {
+				continue
+			}
+			fmt.Fprintf(&b, "    %s->>%s: %s (attempt %d)\n", orchestrator, svc, mermaidText(e.StepID), e.Attempt)
+		case EventCompensationSent:
+			if e.Service == "" {
+				child, _ := e.Payload["child_saga_id"].(string)
+				fmt.Fprintf(&b, "    Note over %s: compensate %s (sub-saga %s)\n", orchestrator, mermaidText(e.StepID), mermaidText(child))
+				continue
+			}
+			fmt.Fprintf(&b, "    %s->>%s: compensate %s (attempt %d)\n", orchestrator, svc, mermaidText(e.StepID), e.Attempt)
+		case EventWaitStarted:
+			event, _ := e.Payload["event"].(string)
+			if event == "" {
+				event = "signal"
+			}
+			fmt.Fprintf(&b, "    Note over %s: %s waiting for %s\n", orchestrator, mermaidText(e.StepID), mermaidText(event))
+		case EventSubSagaStarted:
+			name, _ := e.Payload["saga_name"].(string)
+			child, _ := e.Payload["child_saga_id"].(string)
+			fmt.Fprintf(&b, "    Note over %s: %s started sub-saga %s (%s)\n", orchestrator, mermaidText(e.StepID), mermaidText(name), mermaidText(child))
+		case EventStepState:
+			if e.Service == "" {
+				fmt.Fprintf(&b, "    Note over %s: %s %s\n", orchestrator, mermaidText(e.StepID), e.ToState)
+				continue
+			}
+			switch StepState(e.ToState) {
+			case StepCompleted, StepCompensated:
+				fmt.Fprintf(&b, "    %s-->>%s: %s %s\n", svc, orchestrator, mermaidText(e.StepID), strings.ToLower(e.ToState))
+			case StepFailed, StepCompensationFailed:
+				fmt.Fprintf(&b, "    %s--x%s: %s %s: %s\n", svc, orchestrator, mermaidText(e.StepID), strings.ToLower(e.ToState), mermaidText(e.Error))
+			default:
+				fmt.Fprintf(&b, "    Note over %s: %s %s\n", orchestrator, mermaidText(e.StepID), e.ToState)
+			}
+		case EventSagaState:
+			fmt.Fprintf(&b, "    Note over %s: saga %s\n", orchestrator, e.ToState)
+		case EventAdminAction:
+			actor, _ := e.Payload["actor"].(string)
+			fmt.Fprintf(&b, "    Note over %s: %s by %s\n", orchestrator, e.ToState, mermaidText(actor))
+		}
+	}
+
+	return b.String()
+}
+
+// mermaidID turns a service name into a participant identifier
+func mermaidID(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_':
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+// mermaidText strips characters that end a Mermaid message
+func mermaidText(s string) string {
+	return strings.NewReplacer("\n", " ", ";", ",", "#", "").Replace(s)
+}
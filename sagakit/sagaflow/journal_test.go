@@ -0,0 +1,104 @@
+package sagaflow
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestMermaidSequence(t *testing.T) {
+	step := func(i int) *int { return &i }
+	events := []SagaEvent{
+		{Type: EventSagaState, ToState: string(StateInProgress)},
+		{Type: EventCommandSent, StepIndex: step(0), StepID: "reserve", Service: "inventory-svc", Attempt: 1},
+		{Type: EventStepState, StepIndex: step(0), StepID: "reserve", Service: "inventory-svc", ToState: string(StepCompleted)},
+		{Type: EventWaitStarted, StepIndex: step(1), StepID: "approve", Payload: map[string]interface{}{"event": "approved"}},
+		{Type: EventStepState, StepIndex: step(1), StepID: "approve", ToState: string(StepCompleted)},
+		{Type: EventCommandSent, StepIndex: step(2), StepID: "charge", Service: "payments", Attempt: 2},
+		{Type: EventStepState, StepIndex: step(2), StepID: "charge", Service: "payments", ToState: string(StepFailed), Error: "card declined; #2"},
+		{Type: EventCompensationSent, StepIndex: step(0), StepID: "reserve", Service: "inventory-svc", Attempt: 1},
+		{Type: EventAdminAction, StepIndex: step(0), StepID: "reserve", ToState: string(AdminSkipStep), Payload: map[string]interface{}{"actor": "alice"}},
+		{Type: EventSagaState, ToState: string(StateCompensated)},
+	}
+
+	want := `sequenceDiagram
+    participant Orchestrator
+    participant inventory_svc
+    participant payments
+    Note over Orchestrator: saga IN_PROGRESS
+    Orchestrator->>inventory_svc: reserve (attempt 1)
+    inventory_svc-->>Orchestrator: reserve completed
+    Note over Orchestrator: approve waiting for approved
+    Note over Orchestrator: approve COMPLETED
+    Orchestrator->>payments: charge (attempt 2)
+    payments--xOrchestrator: charge failed: card declined, 2
+    Orchestrator->>inventory_svc: compensate reserve (attempt 1)
+    Note over Orchestrator: SKIP_STEP by alice
+    Note over Orchestrator: saga COMPENSATED
+`
+	if got := MermaidSequence(events); got != want {
+		t.Errorf("MermaidSequence() =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestHistoryJournalsARun(t *testing.T) {
+	ctx := context.Background()
+	o, _ := newMemOrchestrator()
+
+	sagaID, err := o.StartSaga(ctx, Saga{Name: "order", Version: 1, Steps: []Step{
+		{ID: "reserve", Service: "svc", Command: "reserve", Compensate: "release"},
+		{ID: "charge", Service: "svc", Command: "charge", Compensate: "refund"},
+	}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := o.HandleStepSuccess(ctx, sagaID, 0, map[string]interface{}{"reservation": "r-1"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := o.HandleStepFailure(ctx, sagaID, 1, "card declined"); err != nil {
+		t.Fatal(err)
+	}
+	if err := o.HandleCompensationSuccess(ctx, sagaID, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	events, err := o.History(ctx, sagaID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for i, e := range events {
+		if e.SagaID != sagaID || i > 0 && e.ID <= events[i-1].ID {
+			t.Errorf("event %d = %+v, out of order or of another saga", i, e)
+		}
+		got = append(got, string(e.Type)+" "+e.StepID+" "+e.ToState)
+	}
+	want := []string{
+		"SAGA_STATE  IN_PROGRESS",
+		"COMMAND_SENT reserve IN_PROGRESS",
+		"STEP_STATE reserve COMPLETED",
+		"COMMAND_SENT charge IN_PROGRESS",
+		"STEP_STATE charge FAILED",
+		"SAGA_STATE  COMPENSATING",
+		"COMPENSATION_SENT reserve COMPENSATING",
+		"STEP_STATE reserve COMPENSATED",
+		"SAGA_STATE  COMPENSATED",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("journal =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	diagram, err := o.SequenceDiagram(ctx, sagaID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(diagram, "svc--xOrchestrator: charge failed: card declined") {
+		t.Errorf("diagram misses the failure:\n%s", diagram)
+	}
+
+	if _, err := o.History(ctx, "missing"); !errors.Is(err, ErrSagaNotFound) {
+		t.Errorf("History() of an unknown saga = %v, want %v", err, ErrSagaNotFound)
+	}
+}
@@ -0,0 +1,381 @@
+package sagaflow
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"testing"
+	"time"
+
+	"shared/sagakit"
+	"shared/sagakit/db"
+	"shared/sagakit/outbox"
+
+	"github.com/ThreeDotsLabs/watermill/message"
+)
+
+// memDB is an in-memory db.DB for orchestrator tests. Executions are kept
+// as JSON like a real store keeps rows, and a rolled back transaction
+// restores the state it began with.
+type memDB struct {
+	state memState
+}
+
+type memState struct {
+	executions map[string][]byte
+	actions    []AdminAction
+	events     []SagaEvent
+	inbox      map[string]bool
+	messages   []memMessage
+}
+
+// memMessage is a message written to the outbox
+type memMessage struct {
+	Topic     string
+	Payload   map[string]interface{}
+	Metadata  map[string]string
+	NotBefore time.Time
+}
+
+func newMemDB() *memDB {
+	return &memDB{state: memState{executions: map[string][]byte{}, inbox: map[string]bool{}}}
+}
+
+func (s memState) clone() memState {
+	return memState{
+		executions: maps.Clone(s.executions),
+		actions:    slices.Clone(s.actions),
+		events:     slices.Clone(s.events),
+		inbox:      maps.Clone(s.inbox),
+		messages:   slices.Clone(s.messages),
+	}
+}
+
+func (d *memDB) BeginTx(context.Context) (db.Tx, error) {
+	return &memTx{db: d, snapshot: d.state.clone()}, nil
+}
+
+// published returns the messages written to topic, oldest first
+func (d *memDB) published(topic string) []memMessage {
+	var msgs []memMessage
+	for _, m := range d.state.messages {
+		if m.Topic == topic {
+			msgs = append(msgs, m)
+		}
+	}
+	return msgs
+}
+
+type memTx struct {
+	db       *memDB
+	snapshot memState
+}
+
+func (t *memTx) Exec(context.Context, string, ...any) error {
+	return errors.New("memTx: SQL is not supported")
+}
+
+func (t *memTx) Query(context.Context, string, ...any) (db.Rows, error) {
+	return nil, errors.New("memTx: SQL is not supported")
+}
+
+func (t *memTx) Commit() error   { return nil }
+func (t *memTx) Rollback() error { t.db.state = t.snapshot; return nil }
+
+func memStateOf(tx db.Tx) *memState {
+	return &tx.(*memTx).db.state
+}
+
+// memOutbox implements outbox.Store on memDB
+type memOutbox struct{}
+
+func (memOutbox) InsertTx(ctx context.Context, tx db.Tx, topic string, msg *message.Message) error {
+	return memOutbox{}.ScheduleTx(ctx, tx, topic, msg, time.Time{})
+}
+
+func (memOutbox) ScheduleTx(_ context.Context, tx db.Tx, topic string, msg *message.Message, at time.Time) error {
+	var payload map[string]interface{}
+	if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+		return err
+	}
+	state := memStateOf(tx)
+	state.messages = append(state.messages, memMessage{Topic: topic, Payload: payload, Metadata: msg.Metadata, NotBefore: at})
+	return nil
+}
+
+func (memOutbox) GetPendingTx(context.Context, db.Tx, int) ([]outbox.Entry, error) {
+	return nil, nil
+}
+
+func (memOutbox) MarkSentTx(context.Context, db.Tx, []string) error {
+	return nil
+}
+
+// memInbox implements Inbox on memDB
+type memInbox struct{}
+
+func (memInbox) InitSchema(context.Context, db.Tx) error { return nil }
+
+func (memInbox) ClaimTx(_ context.Context, tx db.Tx, service, key string) (bool, error) {
+	state := memStateOf(tx)
+	if state.inbox[service+"/"+key] {
+		return false, nil
+	}
+	state.inbox[service+"/"+key] = true
+	return true, nil
+}
+
+// memStore implements StateStore on memDB
+type memStore struct{}
+
+func (memStore) SaveExecution(_ context.Context, tx db.Tx, exec *SagaExecution) error {
+	b, err := json.Marshal(exec)
+	if err != nil {
+		return err
+	}
+	memStateOf(tx).executions[exec.SagaID] = b
+	return nil
+}
+
+func (memStore) GetExecution(_ context.Context, tx db.Tx, sagaID string) (*SagaExecution, error) {
+	b, ok := memStateOf(tx).executions[sagaID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrSagaNotFound, sagaID)
+	}
+	var exec SagaExecution
+	if err := json.Unmarshal(b, &exec); err != nil {
+		return nil, err
+	}
+	return &exec, nil
+}
+
+func (s memStore) LockExecution(ctx context.Context, tx db.Tx, sagaID string) (*SagaExecution, error) {
+	return s.GetExecution(ctx, tx, sagaID)
+}
+
+// update loads an execution, applies fn and saves it
+func (s memStore) update(ctx context.Context, tx db.Tx, sagaID string, fn func(exec *SagaExecution)) error {
+	exec, err := s.GetExecution(ctx, tx, sagaID)
+	if err != nil {
+		return err
+	}
+	fn(exec)
+	return s.SaveExecution(ctx, tx, exec)
+}
+
+func (s memStore) UpdateStepState(ctx context.Context, tx db.Tx, sagaID string, stepIndex int, state StepState, output map[string]interface{}, errMsg string) error {
+	return s.update(ctx, tx, sagaID, func(exec *SagaExecution) {
+		exec.Steps[stepIndex].State = state
+		exec.Steps[stepIndex].Output = output
+		exec.Steps[stepIndex].ErrorMessage = errMsg
+	})
+}
+
+func (s memStore) UpdateSagaState(ctx context.Context, tx db.Tx, sagaID string, state SagaState, currentStep int, errMsg string) error {
+	return s.update(ctx, tx, sagaID, func(exec *SagaExecution) {
+		exec.State = state
+		exec.CurrentStep = currentStep
+		exec.ErrorMessage = errMsg
+	})
+}
+
+func (s memStore) UpdateSagaContext(ctx context.Context, tx db.Tx, sagaID string, sagaContext map[string]interface{}) error {
+	return s.update(ctx, tx, sagaID, func(exec *SagaExecution) {
+		exec.Context = sagaContext
+	})
+}
+
+func (s memStore) SaveStep(ctx context.Context, tx db.Tx, sagaID string, step *StepExecution) error {
+	return s.update(ctx, tx, sagaID, func(exec *SagaExecution) {
+		exec.Steps[step.StepIndex] = *step
+	})
+}
+
+// all returns every execution, in no particular order
+func (s memStore) all(ctx context.Context, tx db.Tx) []*SagaExecution {
+	var execs []*SagaExecution
+	for id := range memStateOf(tx).executions {
+		exec, _ := s.GetExecution(ctx, tx, id)
+		execs = append(execs, exec)
+	}
+	return execs
+}
+
+func (s memStore) ClaimExpiredSteps(ctx context.Context, tx db.Tx, now time.Time, limit int) ([]StepRef, error) {
+	var refs []StepRef
+	for _, exec := range s.all(ctx, tx) {
+		for _, step := range exec.Steps {
+			if (step.State == StepInProgress || step.State == StepCompensating) && step.DeadlineAt != nil && step.DeadlineAt.Before(now) && len(refs) < limit {
+				refs = append(refs, StepRef{SagaID: exec.SagaID, StepIndex: step.StepIndex})
+			}
+		}
+	}
+	return refs, nil
+}
+
+func (s memStore) ClaimExpiredSagas(ctx context.Context, tx db.Tx, now time.Time, limit int) ([]string, error) {
+	var ids []string
+	for _, exec := range s.all(ctx, tx) {
+		if exec.State == StateInProgress && exec.DeadlineAt != nil && exec.DeadlineAt.Before(now) && len(ids) < limit {
+			ids = append(ids, exec.SagaID)
+		}
+	}
+	return ids, nil
+}
+
+func (s memStore) CountStuckSagas(ctx context.Context, tx db.Tx, now time.Time) (int, error) {
+	steps, _ := s.ClaimExpiredSteps(ctx, tx, now, len(memStateOf(tx).executions)*100)
+	sagas, _ := s.ClaimExpiredSagas(ctx, tx, now, len(memStateOf(tx).executions))
+	stuck := map[string]bool{}
+	for _, ref := range steps {
+		stuck[ref.SagaID] = true
+	}
+	for _, id := range sagas {
+		stuck[id] = true
+	}
+	return len(stuck), nil
+}
+
+func (s memStore) ListExecutions(ctx context.Context, tx db.Tx, filter ExecutionFilter) ([]SagaExecution, error) {
+	var execs []SagaExecution
+	for _, exec := range s.all(ctx, tx) {
+		if (len(filter.States) == 0 || slices.Contains(filter.States, exec.State)) && (filter.Name == "" || filter.Name == exec.SagaName) {
+			exec.Steps = nil
+			execs = append(execs, *exec)
+		}
+	}
+	return execs, nil
+}
+
+func (memStore) RecordAdminAction(_ context.Context, tx db.Tx, action *AdminAction) error {
+	state := memStateOf(tx)
+	state.actions = append(state.actions, *action)
+	return nil
+}
+
+func (memStore) ListAdminActions(_ context.Context, tx db.Tx, sagaID string) ([]AdminAction, error) {
+	var actions []AdminAction
+	for _, action := range memStateOf(tx).actions {
+		if action.SagaID == sagaID {
+			actions = append(actions, action)
+		}
+	}
+	return actions, nil
+}
+
+func (s memStore) FindActiveByBusinessKey(ctx context.Context, tx db.Tx, key string) (string, error) {
+	for _, exec := range s.all(ctx, tx) {
+		switch exec.State {
+		case StateCreated, StateInProgress, StateCompensating:
+			if exec.BusinessKey == key {
+				return exec.SagaID, nil
+			}
+		}
+	}
+	return "", nil
+}
+
+func (memStore) AppendEvent(_ context.Context, tx db.Tx, event *SagaEvent) error {
+	state := memStateOf(tx)
+	event.ID = int64(len(state.events) + 1)
+	state.events = append(state.events, *event)
+	return nil
+}
+
+func (memStore) ListEvents(_ context.Context, tx db.Tx, sagaID string) ([]SagaEvent, error) {
+	var events []SagaEvent
+	for _, event := range memStateOf(tx).events {
+		if event.SagaID == sagaID {
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
+
+func (s memStore) ClaimWaitingSteps(ctx context.Context, tx db.Tx, event string, payload map[string]interface{}) ([]StepRef, error) {
+	var refs []StepRef
+	for _, exec := range s.all(ctx, tx) {
+		for _, step := range exec.Steps {
+			if step.State != StepInProgress || step.Wait == nil || step.Wait.Event != event {
+				continue
+			}
+			if key, ok := step.Wait.eventKey(payload); step.Wait.EventKey == "" || ok && key == step.WaitKey {
+				refs = append(refs, StepRef{SagaID: exec.SagaID, StepIndex: step.StepIndex})
+			}
+		}
+	}
+	return refs, nil
+}
+
+// newMemOrchestrator creates an orchestrator on a fresh memDB
+func newMemOrchestrator() (*Orchestrator, *memDB) {
+	database := newMemDB()
+	return &Orchestrator{
+		DB:         database,
+		StateStore: memStore{},
+		Outbox:     memOutbox{},
+		Metrics:    &EventMetrics{},
+	}, database
+}
+
+// saveExecution stores exec as it is, bypassing the orchestrator
+func saveExecution(t *testing.T, o *Orchestrator, exec *SagaExecution) {
+	t.Helper()
+	tx, _ := o.DB.BeginTx(context.Background())
+	if err := o.StateStore.SaveExecution(context.Background(), tx, exec); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// loadExecution reads an execution back, failing the test when it is missing
+func loadExecution(t *testing.T, o *Orchestrator, sagaID string) *SagaExecution {
+	t.Helper()
+	tx, _ := o.DB.BeginTx(context.Background())
+	exec, err := o.StateStore.GetExecution(context.Background(), tx, sagaID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return exec
+}
+
+// execution builds a saga of the given state whose steps are in the given
+// states; each step has a command and a compensation on service "svc"
+func execution(state SagaState, steps ...StepState) *SagaExecution {
+	exec := &SagaExecution{
+		SagaID:   "saga-1",
+		SagaName: "order",
+		State:    state,
+		Context:  map[string]interface{}{},
+	}
+	for i, stepState := range steps {
+		exec.Steps = append(exec.Steps, StepExecution{
+			StepID:     fmt.Sprintf("step-%d", i),
+			StepIndex:  i,
+			State:      stepState,
+			Service:    "svc",
+			Command:    fmt.Sprintf("do-%d", i),
+			Compensate: fmt.Sprintf("undo-%d", i),
+			Attempts:   1,
+		})
+	}
+	return exec
+}
+
+// locked runs fn on the locked execution in a transaction, the way the
+// sweeper and reply handlers do
+func locked(t *testing.T, o *Orchestrator, sagaID string, fn func(uow sagakit.UnitOfWork, exec *SagaExecution) error) {
+	t.Helper()
+	err := o.runInTx(context.Background(), func(uow sagakit.UnitOfWork) error {
+		exec, err := o.StateStore.LockExecution(context.Background(), uow.Tx(), sagaID)
+		if err != nil {
+			return err
+		}
+		return fn(uow, exec)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,529 @@
+package sagaflow
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"shared/sagakit/db"
+	"shared/sagakit/mgo"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MongoStateStore implements StateStore on MongoDB. An execution and its
+// steps are stored as one document. It must be used with transactions from
+// mgo.DB so that state changes and outbox messages commit together.
+//
+// Mongo has no row locks: LockExecution writes to the document, so a
+// concurrent transaction touching the same saga fails with a write conflict
+// and its message is redelivered. The sweeper's claims lease the execution
+// instead (see claimExecution), so sweepers skip each other's sagas the way
+// FOR UPDATE SKIP LOCKED does on PostgreSQL.
+//
+// The business key index needs MongoDB 6.0 or later, which accepts $in in
+// partial filter expressions.
+type MongoStateStore struct {
+	Executions   string
+	AdminActions string
+	Events       string
+	// BusinessKeys holds one document per business key, written to
+	// serialise StartSaga calls that use the same key
+	BusinessKeys string
+}
+
+func NewMongoStateStore() *MongoStateStore {
+	return &MongoStateStore{
+		Executions:   "saga_executions",
+		AdminActions: "saga_admin_actions",
+		Events:       "saga_events",
+		BusinessKeys: "saga_business_keys",
+	}
+}
+
+// EnsureIndexes creates the indexes the store relies on. Indexes cannot be
+// created inside a transaction, so call it once at startup.
+func (s *MongoStateStore) EnsureIndexes(ctx context.Context, database *mongo.Database) error {
+	_, err := database.Collection(s.Executions).Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "state", Value: 1}, {Key: "deadline_at", Value: 1}}},
+		{Keys: bson.D{{Key: "saga_name", Value: 1}, {Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "steps.state", Value: 1}, {Key: "steps.deadline_at", Value: 1}}},
+		{Keys: bson.D{{Key: "steps.wait.event", Value: 1}, {Key: "steps.wait_key", Value: 1}}},
+		{
+			Keys: bson.D{{Key: "business_key", Value: 1}},
+			Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{
+				"business_key": bson.M{"$type": "string"},
+				"state":        bson.M{"$in": activeStates()},
+			}),
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = database.Collection(s.Events).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "saga_id", Value: 1}, {Key: "id", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = database.Collection(s.AdminActions).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "saga_id", Value: 1}, {Key: "created_at", Value: 1}},
+	})
+	return err
+}
+
+// claimLease is how long a sweeper claim hides an execution from other
+// sweepers when the claiming transaction never commits
+const claimLease = time.Minute
+
+func activeStates() bson.A {
+	return bson.A{StateCreated, StateInProgress, StateCompensating}
+}
+
+// expiredStep matches in-flight steps and compensations whose deadline
+// passed before now
+func expiredStep(now time.Time) bson.M {
+	return bson.M{"$elemMatch": bson.M{
+		"state":       bson.M{"$in": bson.A{StepInProgress, StepCompensating}},
+		"deadline_at": bson.M{"$lt": now},
+	}}
+}
+
+func (s *MongoStateStore) SaveExecution(ctx context.Context, tx db.Tx, exec *SagaExecution) error {
+	t, err := mgo.FromTx(tx)
+	if err != nil {
+		return err
+	}
+
+	sc := t.Context(ctx)
+
+	// Replacing the document must not restart the journal numbering
+	var seq eventSeq
+	err = t.Collection(s.Executions).FindOne(sc,
+		bson.M{"_id": exec.SagaID},
+		options.FindOne().SetProjection(bson.M{"event_seq": 1}),
+	).Decode(&seq)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return err
+	}
+
+	_, err = t.Collection(s.Executions).ReplaceOne(sc,
+		bson.M{"_id": exec.SagaID}, executionDoc{SagaExecution: *exec, EventSeq: seq.EventSeq},
+		options.Replace().SetUpsert(true),
+	)
+	return err
+}
+
+// executionDoc is the stored form of an execution. EventSeq is the number
+// of the last journal entry of the saga.
+type executionDoc struct {
+	SagaExecution `bson:",inline"`
+	EventSeq      int64 `bson:"event_seq,omitempty"`
+}
+
+type eventSeq struct {
+	EventSeq int64 `bson:"event_seq"`
+}
+
+func (s *MongoStateStore) GetExecution(ctx context.Context, tx db.Tx, sagaID string) (*SagaExecution, error) {
+	t, err := mgo.FromTx(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	res := t.Collection(s.Executions).FindOne(t.Context(ctx), bson.M{"_id": sagaID})
+	return decodeExecution(res, sagaID)
+}
+
+func (s *MongoStateStore) LockExecution(ctx context.Context, tx db.Tx, sagaID string) (*SagaExecution, error) {
+	t, err := mgo.FromTx(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Committing the lock also releases a sweeper lease on the saga
+	res := t.Collection(s.Executions).FindOneAndUpdate(t.Context(ctx),
+		bson.M{"_id": sagaID},
+		bson.M{"$inc": bson.M{"lock": 1}, "$unset": bson.M{"claimed_until": ""}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	)
+	return decodeExecution(res, sagaID)
+}
+
+func decodeExecution(res *mongo.SingleResult, sagaID string) (*SagaExecution, error) {
+	var exec SagaExecution
+	if err := res.Decode(&exec); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, fmt.Errorf("%w: %s", ErrSagaNotFound, sagaID)
+		}
+		return nil, err
+	}
+	if exec.Context == nil {
+		exec.Context = map[string]interface{}{}
+	}
+	return &exec, nil
+}
+
+func (s *MongoStateStore) UpdateStepState(ctx context.Context, tx db.Tx, sagaID string, stepIndex int, state StepState, output map[string]interface{}, errMsg string) error {
+	var completedAt *time.Time
+	if state == StepCompleted || state == StepFailed || state == StepCompensated {
+		now := time.Now()
+		completedAt = &now
+	}
+
+	prefix := fmt.Sprintf("steps.%d.", stepIndex)
+	return s.updateExecution(ctx, tx, sagaID, bson.M{
+		prefix + "state":           state,
+		prefix + "output":          output,
+		prefix + "error_message":   errMsg,
+		prefix + "completed_at":    completedAt,
+		prefix + "next_attempt_at": nil,
+		prefix + "deadline_at":     nil,
+	})
+}
+
+func (s *MongoStateStore) UpdateSagaState(ctx context.Context, tx db.Tx, sagaID string, state SagaState, currentStep int, errMsg string) error {
+	return s.updateExecution(ctx, tx, sagaID, bson.M{
+		"state":         state,
+		"current_step":  currentStep,
+		"error_message": errMsg,
+		"updated_at":    time.Now(),
+	})
+}
+
+func (s *MongoStateStore) UpdateSagaContext(ctx context.Context, tx db.Tx, sagaID string, sagaContext map[string]interface{}) error {
+	return s.updateExecution(ctx, tx, sagaID, bson.M{
+		"context":    sagaContext,
+		"updated_at": time.Now(),
+	})
+}
+
+func (s *MongoStateStore) SaveStep(ctx context.Context, tx db.Tx, sagaID string, step *StepExecution) error {
+	return s.updateExecution(ctx, tx, sagaID, bson.M{
+		fmt.Sprintf("steps.%d", step.StepIndex): step,
+	})
+}
+
+func (s *MongoStateStore) updateExecution(ctx context.Context, tx db.Tx, sagaID string, set bson.M) error {
+	t, err := mgo.FromTx(tx)
+	if err != nil {
+		return err
+	}
+
+	_, err = t.Collection(s.Executions).UpdateOne(t.Context(ctx), bson.M{"_id": sagaID}, bson.M{"$set": set})
+	return err
+}
+
+func (s *MongoStateStore) ClaimExpiredSteps(ctx context.Context, tx db.Tx, now time.Time, limit int) ([]StepRef, error) {
+	var refs []StepRef
+	for len(refs) < limit {
+		exec, err := s.claimExecution(ctx, tx, bson.M{"steps": expiredStep(now)}, nil, now)
+		if err != nil || exec == nil {
+			return refs, err
+		}
+		for _, step := range exec.Steps {
+			inFlight := step.State == StepInProgress || step.State == StepCompensating
+			if inFlight && step.DeadlineAt != nil && step.DeadlineAt.Before(now) && len(refs) < limit {
+				refs = append(refs, StepRef{SagaID: exec.SagaID, StepIndex: step.StepIndex})
+			}
+		}
+	}
+	return refs, nil
+}
+
+func (s *MongoStateStore) ClaimExpiredSagas(ctx context.Context, tx db.Tx, now time.Time, limit int) ([]string, error) {
+	filter := bson.M{"state": StateInProgress, "deadline_at": bson.M{"$lt": now}}
+	sort := bson.D{{Key: "deadline_at", Value: 1}}
+
+	var ids []string
+	for len(ids) < limit {
+		exec, err := s.claimExecution(ctx, tx, filter, sort, now)
+		if err != nil || exec == nil {
+			return ids, err
+		}
+		ids = append(ids, exec.SagaID)
+	}
+	return ids, nil
+}
+
+// claimExecution leases the first execution matching filter that no other
+// sweeper holds, or returns nil when there is none. The lease is written
+// outside tx so other sweepers see it at once; LockExecution clears it when
+// tx commits and it runs out after claimLease when tx is rolled back.
+func (s *MongoStateStore) claimExecution(ctx context.Context, tx db.Tx, filter bson.M, sort bson.D, now time.Time) (*SagaExecution, error) {
+	t, err := mgo.FromTx(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	claim := bson.M{"claimed_until": bson.M{"$not": bson.M{"$gte": now}}}
+	for k, v := range filter {
+		claim[k] = v
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	if sort != nil {
+		opts.SetSort(sort)
+	}
+
+	var exec SagaExecution
+	err = t.Collection(s.Executions).FindOneAndUpdate(ctx, claim,
+		bson.M{"$set": bson.M{"claimed_until": now.Add(claimLease)}},
+		opts,
+	).Decode(&exec)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &exec, nil
+}
+
+func (s *MongoStateStore) CountStuckSagas(ctx context.Context, tx db.Tx, now time.Time) (int, error) {
+	t, err := mgo.FromTx(tx)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := t.Collection(s.Executions).CountDocuments(t.Context(ctx), bson.M{
+		"$or": bson.A{
+			bson.M{"state": StateInProgress, "deadline_at": bson.M{"$lt": now}},
+			bson.M{"state": bson.M{"$in": bson.A{StateInProgress, StateCompensating}}, "steps": expiredStep(now)},
+		},
+	})
+	return int(n), err
+}
+
+func (s *MongoStateStore) ListExecutions(ctx context.Context, tx db.Tx, filter ExecutionFilter) ([]SagaExecution, error) {
+	query := bson.M{}
+	if len(filter.States) > 0 {
+		query["state"] = bson.M{"$in": filter.States}
+	}
+	if filter.Name != "" {
+		query["saga_name"] = filter.Name
+	}
+	if filter.BusinessKey != "" {
+		query["business_key"] = filter.BusinessKey
+	}
+	created := bson.M{}
+	if !filter.CreatedFrom.IsZero() {
+		created["$gte"] = filter.CreatedFrom
+	}
+	if !filter.CreatedTo.IsZero() {
+		created["$lt"] = filter.CreatedTo
+	}
+	if len(created) > 0 {
+		query["created_at"] = created
+	}
+
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = 50
+	}
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}}).
+		SetSkip(int64(filter.Offset)).
+		SetLimit(int64(limit)).
+		SetProjection(bson.M{"steps": 0})
+
+	var execs []SagaExecution
+	err := s.findExecutions(ctx, tx, query, opts, func(exec *SagaExecution) bool {
+		execs = append(execs, *exec)
+		return true
+	})
+	return execs, err
+}
+
+// ClaimWaitingSteps claims inside tx rather than with a lease: the event
+// is redelivered when tx fails, and a lease would hide the step from it.
+// Writing the lock makes concurrent deliveries of the event conflict.
+func (s *MongoStateStore) ClaimWaitingSteps(ctx context.Context, tx db.Tx, event string, payload map[string]interface{}) ([]StepRef, error) {
+	t, err := mgo.FromTx(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	filter := bson.M{"steps": bson.M{"$elemMatch": bson.M{"state": StepInProgress, "wait.event": event}}}
+
+	var refs []StepRef
+	var ids bson.A
+	err = s.findExecutions(ctx, tx, filter, nil, func(exec *SagaExecution) bool {
+		for _, step := range exec.Steps {
+			if step.State != StepInProgress || step.Wait == nil || step.Wait.Event != event {
+				continue
+			}
+			if step.Wait.EventKey != "" {
+				if key, ok := step.Wait.eventKey(payload); !ok || key != step.WaitKey {
+					continue
+				}
+			}
+			refs = append(refs, StepRef{SagaID: exec.SagaID, StepIndex: step.StepIndex})
+			if len(ids) == 0 || ids[len(ids)-1] != exec.SagaID {
+				ids = append(ids, exec.SagaID)
+			}
+		}
+		return true
+	})
+	if err != nil || len(ids) == 0 {
+		return refs, err
+	}
+
+	_, err = t.Collection(s.Executions).UpdateMany(t.Context(ctx),
+		bson.M{"_id": bson.M{"$in": ids}},
+		bson.M{"$inc": bson.M{"lock": 1}},
+	)
+	return refs, err
+}
+
+// findExecutions calls fn for each execution matching filter until fn
+// returns false
+func (s *MongoStateStore) findExecutions(ctx context.Context, tx db.Tx, filter bson.M, opts *options.FindOptions, fn func(exec *SagaExecution) bool) error {
+	t, err := mgo.FromTx(tx)
+	if err != nil {
+		return err
+	}
+	sc := t.Context(ctx)
+
+	var findOpts []*options.FindOptions
+	if opts != nil {
+		findOpts = append(findOpts, opts)
+	}
+	cur, err := t.Collection(s.Executions).Find(sc, filter, findOpts...)
+	if err != nil {
+		return err
+	}
+	defer cur.Close(sc)
+
+	for cur.Next(sc) {
+		var exec SagaExecution
+		if err := cur.Decode(&exec); err != nil {
+			return err
+		}
+		if !fn(&exec) {
+			break
+		}
+	}
+	return cur.Err()
+}
+
+func (s *MongoStateStore) FindActiveByBusinessKey(ctx context.Context, tx db.Tx, key string) (string, error) {
+	t, err := mgo.FromTx(tx)
+	if err != nil {
+		return "", err
+	}
+	sc := t.Context(ctx)
+
+	// Writing the key document makes a concurrent start with the same key
+	// fail with a write conflict instead of slipping past the check
+	_, err = t.Collection(s.BusinessKeys).UpdateOne(sc,
+		bson.M{"_id": key},
+		bson.M{"$inc": bson.M{"starts": 1}, "$set": bson.M{"updated_at": time.Now()}},
+		options.Update().SetUpsert(true),
+	)
+	if err != nil {
+		return "", err
+	}
+
+	var exec SagaExecution
+	err = t.Collection(s.Executions).FindOne(sc,
+		bson.M{"business_key": key, "state": bson.M{"$in": activeStates()}},
+		options.FindOne().SetProjection(bson.M{"_id": 1}),
+	).Decode(&exec)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return "", nil
+	}
+	return exec.SagaID, err
+}
+
+// isMongoConflict reports whether err comes from a transaction that lost a
+// race with a concurrent one: a duplicate key on the business key index or
+// a write conflict on the key document
+func isMongoConflict(err error) bool {
+	if mongo.IsDuplicateKeyError(err) {
+		return true
+	}
+	var serverErr mongo.ServerError
+	return errors.As(err, &serverErr) &&
+		(serverErr.HasErrorCode(112) || serverErr.HasErrorLabel("TransientTransactionError"))
+}
+
+func (s *MongoStateStore) RecordAdminAction(ctx context.Context, tx db.Tx, action *AdminAction) error {
+	t, err := mgo.FromTx(tx)
+	if err != nil {
+		return err
+	}
+
+	_, err = t.Collection(s.AdminActions).InsertOne(t.Context(ctx), action)
+	return err
+}
+
+func (s *MongoStateStore) ListAdminActions(ctx context.Context, tx db.Tx, sagaID string) ([]AdminAction, error) {
+	t, err := mgo.FromTx(tx)
+	if err != nil {
+		return nil, err
+	}
+	sc := t.Context(ctx)
+
+	cur, err := t.Collection(s.AdminActions).Find(sc, bson.M{"saga_id": sagaID},
+		options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+
+	var actions []AdminAction
+	err = cur.All(sc, &actions)
+	return actions, err
+}
+
+func (s *MongoStateStore) AppendEvent(ctx context.Context, tx db.Tx, event *SagaEvent) error {
+	t, err := mgo.FromTx(tx)
+	if err != nil {
+		return err
+	}
+
+	sc := t.Context(ctx)
+
+	// Mongo has no sequences; the execution document numbers its journal.
+	// The increment commits or rolls back with the event, and concurrent
+	// writers to one saga conflict on it.
+	var seq eventSeq
+	err = t.Collection(s.Executions).FindOneAndUpdate(sc,
+		bson.M{"_id": event.SagaID},
+		bson.M{"$inc": bson.M{"event_seq": 1}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After).SetProjection(bson.M{"event_seq": 1}),
+	).Decode(&seq)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return fmt.Errorf("%w: %s", ErrSagaNotFound, event.SagaID)
+		}
+		return err
+	}
+
+	event.ID = seq.EventSeq
+	_, err = t.Collection(s.Events).InsertOne(sc, event)
+	return err
+}
+
+func (s *MongoStateStore) ListEvents(ctx context.Context, tx db.Tx, sagaID string) ([]SagaEvent, error) {
+	t, err := mgo.FromTx(tx)
+	if err != nil {
+		return nil, err
+	}
+	sc := t.Context(ctx)
+
+	cur, err := t.Collection(s.Events).Find(sc, bson.M{"saga_id": sagaID},
+		options.Find().SetSort(bson.D{{Key: "id", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+
+	var events []SagaEvent
+	err = cur.All(sc, &events)
+	return events, err
+}
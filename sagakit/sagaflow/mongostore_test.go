@@ -0,0 +1,65 @@
+package sagaflow
+
+import (
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestExecutionDocKeepsEventSeq(t *testing.T) {
+	exec := execution(StateInProgress, StepCompleted, StepInProgress)
+	exec.BusinessKey = "license:A"
+
+	raw, err := bson.Marshal(executionDoc{SagaExecution: *exec, EventSeq: 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var doc bson.M
+	if err := bson.Unmarshal(raw, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc["_id"] != "saga-1" || doc["business_key"] != "license:A" || doc["event_seq"] != int64(7) {
+		t.Errorf("stored document = %v, want the execution fields inline with event_seq 7", doc)
+	}
+
+	var seq eventSeq
+	if err := bson.Unmarshal(raw, &seq); err != nil || seq.EventSeq != 7 {
+		t.Errorf("event_seq read back = %d, %v; want 7", seq.EventSeq, err)
+	}
+
+	var got SagaExecution
+	if err := bson.Unmarshal(raw, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.SagaID != "saga-1" || len(got.Steps) != 2 || got.Steps[1].State != StepInProgress {
+		t.Errorf("execution read back = %+v", got)
+	}
+
+	// A new execution has no journal yet
+	raw, err = bson.Marshal(executionDoc{SagaExecution: *exec})
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc = bson.M{}
+	if err := bson.Unmarshal(raw, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := doc["event_seq"]; ok {
+		t.Errorf("new execution stored with event_seq %v", doc["event_seq"])
+	}
+}
+
+func TestExpiredStepFilter(t *testing.T) {
+	now := time.Now()
+	match := expiredStep(now)["$elemMatch"].(bson.M)
+
+	states := match["state"].(bson.M)["$in"].(bson.A)
+	if len(states) != 2 || states[0] != StepInProgress || states[1] != StepCompensating {
+		t.Errorf("expired steps match states %v, want in-flight steps and compensations", states)
+	}
+	if deadline := match["deadline_at"].(bson.M)["$lt"]; deadline != now {
+		t.Errorf("deadline filter = %v, want before %v", deadline, now)
+	}
+}
@@ -3,10 +3,13 @@ package sagaflow
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"shared/pkgs/uuids"
 	"shared/sagakit"
 	"shared/sagakit/db"
+	"shared/sagakit/mgo"
+	"shared/sagakit/outbox"
 	"time"
 
 	"github.com/ThreeDotsLabs/watermill/message"
@@ -16,171 +19,364 @@ import (
 type Orchestrator struct {
 	DB         db.DB
 	StateStore StateStore
-	Router     *message.Router
+	// Outbox receives the commands written together with saga state. It
+	// must belong to DB; nil uses the sagakit global store.
+	Outbox outbox.Store
+	Router *message.Router
+	// Definitions resolves sagas started by name
+	Definitions DefinitionStore
+	// CompensationRetry controls how often a failed compensation is re-sent
+	// before the saga ends FAILED; nil means DefaultCompensationRetry
+	CompensationRetry *RetryPolicy
+	// CompensationTimeout bounds each compensation attempt; an attempt
+	// without reply is retried through CompensationRetry. Zero uses the
+	// step Timeout, or DefaultCompensationTimeout when the step has none.
+	CompensationTimeout time.Duration
+	// AlertHook is called for every saga that ends FAILED and needs manual
+	// intervention. It runs from the TopicSagaFailed handler.
+	AlertHook func(ctx context.Context, alert SagaAlert) error
+	// WaitTopics lists the events awaited by wait steps; SetupEventHandlers
+	// subscribes to each of them
+	WaitTopics []string
+	// Metrics counts handled and stale participant replies
+	Metrics *EventMetrics
 }
 
+// NewOrchestrator creates an orchestrator on database. With an mgo.DB the
+// commands go to a Mongo outbox, which needs a dispatcher of its own (see
+// sagakit.NewDispatcher) unless it is the sagakit global store.
 func NewOrchestrator(database db.DB, stateStore StateStore) *Orchestrator {
+	store, _ := defaultStores(database)
 	return &Orchestrator{
 		DB:         database,
 		StateStore: stateStore,
+		Outbox:     store,
+		Metrics:    &EventMetrics{},
 	}
 }
 
-// StartSaga initiates a new saga execution
-func (o *Orchestrator) StartSaga(ctx context.Context, sagaDef Saga, initialContext map[string]interface{}) (string, error) {
-	sagaID := uuids.NewUUID()
+// defaultStores picks the outbox and inbox matching database
+func defaultStores(database db.DB) (outbox.Store, Inbox) {
+	if _, ok := database.(*mgo.DB); ok {
+		return mgo.NewOutbox(), NewMongoInbox()
+	}
+	return nil, NewPostgresInbox()
+}
 
-	// Create saga execution
-	exec := &SagaExecution{
-		SagaID:      sagaID,
-		SagaName:    sagaDef.Name,
-		State:       StateCreated,
-		CurrentStep: 0,
-		Context:     initialContext,
-		CreatedAt:   time.Now(),
-		UpdatedAt:   time.Now(),
+// runInTx runs fn in a transaction on DB whose messages go to Outbox
+func (o *Orchestrator) runInTx(ctx context.Context, fn func(uow sagakit.UnitOfWork) error) error {
+	store := o.Outbox
+	if store == nil {
+		store = sagakit.GetGlobalStore()
 	}
+	return sagakit.RunInTx(ctx, o.DB, store, fn)
+}
 
-	// Initialize step executions
-	for i, step := range sagaDef.Steps {
-		// Merge saga context with step payload
-		stepInput := make(map[string]interface{})
-		for k, v := range initialContext {
-			stepInput[k] = v
+// StartSaga initiates a new saga execution. With WithBusinessKey it first
+// looks for an active saga holding the key and applies the OnDuplicate
+// policy instead of starting another one.
+func (o *Orchestrator) StartSaga(ctx context.Context, sagaDef Saga, initialContext map[string]interface{}, opts ...StartOption) (string, error) {
+	var options startOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	var exec *SagaExecution
+	var existingID string
+	var err error
+	for attempt := 0; ; attempt++ {
+		exec = newExecution(sagaDef, initialContext)
+		exec.BusinessKey = options.businessKey
+
+		existingID, err = o.startExecution(ctx, exec)
+		// On Mongo a concurrent start with the same business key makes this
+		// one conflict; try again so that it finds the saga that won
+		if err == nil || exec.BusinessKey == "" || !isMongoConflict(err) || attempt == startConflictRetries {
+			break
 		}
-		for k, v := range step.Payload {
-			stepInput[k] = v
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(time.Duration(attempt+1) * 10 * time.Millisecond):
 		}
+	}
 
-		exec.Steps = append(exec.Steps, StepExecution{
-			StepID:    step.ID,
-			StepIndex: i,
-			State:     StepPending,
-			Service:   step.Service,
-			Command:   step.Command,
-			Input:     stepInput,
-			Attempts:  0,
-		})
+	if err != nil {
+		return "", err
 	}
 
-	// Save initial state
-	err := sagakit.RunInTx(ctx, o.DB, sagakit.GetGlobalStore(), func(uow sagakit.UnitOfWork) error {
-		if err := o.StateStore.SaveExecution(ctx, uow.Tx(), exec); err != nil {
-			return err
+	if existingID != "" {
+		if options.duplicate == DuplicateReject {
+			return existingID, fmt.Errorf("%w: %s holds %q", ErrDuplicateSaga, existingID, options.businessKey)
+		}
+		return existingID, nil
+	}
+
+	return exec.SagaID, nil
+}
+
+// startConflictRetries bounds how often StartSaga retries a start that
+// conflicted with another one using the same business key
+const startConflictRetries = 3
+
+// startExecution saves exec and sends its first command unless another
+// active saga holds its business key, whose ID it then returns
+func (o *Orchestrator) startExecution(ctx context.Context, exec *SagaExecution) (string, error) {
+	var existingID string
+	err := o.runInTx(ctx, func(uow sagakit.UnitOfWork) error {
+		if exec.BusinessKey != "" {
+			var err error
+			existingID, err = o.StateStore.FindActiveByBusinessKey(ctx, uow.Tx(), exec.BusinessKey)
+			if err != nil || existingID != "" {
+				return err
+			}
 		}
 
-		// Update state to in progress
-		exec.State = StateInProgress
-		if err := o.StateStore.UpdateSagaState(ctx, uow.Tx(), sagaID, StateInProgress, 0, ""); err != nil {
+		if err := o.StateStore.SaveExecution(ctx, uow.Tx(), exec); err != nil {
 			return err
 		}
 
 		// Send first step command
-		return o.sendStepCommand(uow, exec, 0)
+		return o.advanceTo(ctx, uow, exec, 0, nil)
 	})
+	return existingID, err
+}
+
+// newExecution creates the execution of a saga that is about to start
+func newExecution(sagaDef Saga, initialContext map[string]interface{}) *SagaExecution {
+	if initialContext == nil {
+		initialContext = map[string]interface{}{}
+	}
+
+	// Create saga execution
+	exec := &SagaExecution{
+		SagaID:            uuids.NewUUID(),
+		SagaName:          sagaDef.Name,
+		DefinitionVersion: sagaDef.Version,
+		State:             StateCreated,
+		CurrentStep:       0,
+		Context:           initialContext,
+		CreatedAt:         time.Now(),
+		UpdatedAt:         time.Now(),
+	}
+	if sagaDef.Timeout > 0 {
+		deadline := exec.CreatedAt.Add(sagaDef.Timeout)
+		exec.DeadlineAt = &deadline
+	}
+
+	// Initialize step executions
+	exec.Steps = compileSteps(sagaDef.Steps, initialContext)
+	return exec
+}
+
+// StartSagaByName starts the latest version of a registered definition
+func (o *Orchestrator) StartSagaByName(ctx context.Context, name string, initialContext map[string]interface{}, opts ...StartOption) (string, error) {
+	if o.Definitions == nil {
+		return "", errors.New("orchestrator has no definition store")
+	}
 
+	sagaDef, err := o.Definitions.Latest(ctx, name)
 	if err != nil {
 		return "", err
 	}
-
-	return sagaID, nil
+	return o.StartSaga(ctx, sagaDef, initialContext, opts...)
 }
 
-// HandleStepSuccess processes successful step completion
+// HandleStepSuccess processes successful step completion. Like the other
+// reply handlers it locks the saga and drops replies that are stale.
 func (o *Orchestrator) HandleStepSuccess(ctx context.Context, sagaID string, stepIndex int, output map[string]interface{}) error {
-	return sagakit.RunInTx(ctx, o.DB, sagakit.GetGlobalStore(), func(uow sagakit.UnitOfWork) error {
-		exec, err := o.StateStore.GetExecution(ctx, uow.Tx(), sagaID)
-		if err != nil {
-			return err
-		}
+	return o.handleStepSuccess(ctx, sagaID, stepIndex, 0, output)
+}
 
+func (o *Orchestrator) handleStepSuccess(ctx context.Context, sagaID string, stepIndex, attempt int, output map[string]interface{}) error {
+	return o.handleReply(ctx, replyStep, sagaID, stepIndex, attempt, func(uow sagakit.UnitOfWork, exec *SagaExecution) error {
 		// Update step state
-		if err := o.StateStore.UpdateStepState(ctx, uow.Tx(), sagaID, stepIndex, StepCompleted, output, ""); err != nil {
+		exec.Steps[stepIndex].Output = output
+		if err := o.setStepState(ctx, uow, exec, stepIndex, StepCompleted, ""); err != nil {
 			return err
 		}
 
-		// Merge output into saga context
-		for k, v := range output {
-			exec.Context[k] = v
+		// A group member that finished after a sibling failed the group is
+		// compensated straight away
+		if exec.State == StateCompensating {
+			if err := o.compensateStep(ctx, uow, exec, stepIndex, time.Time{}); err != nil {
+				return err
+			}
+			return o.finishCompensation(ctx, uow, exec)
 		}
 
-		// Check if this was the last step
-		if stepIndex == len(exec.Steps)-1 {
-			// Saga completed successfully
-			return o.StateStore.UpdateSagaState(ctx, uow.Tx(), sagaID, StateCompleted, stepIndex, "")
-		}
+		return o.proceed(ctx, uow, exec, stepIndex, output)
+	})
+}
 
-		// Move to next step
-		nextStepIndex := stepIndex + 1
-		if err := o.StateStore.UpdateSagaState(ctx, uow.Tx(), sagaID, StateInProgress, nextStepIndex, ""); err != nil {
-			return err
-		}
+// proceed merges the output of a finished step into the saga context and
+// moves on once the rest of its group is done
+func (o *Orchestrator) proceed(ctx context.Context, uow sagakit.UnitOfWork, exec *SagaExecution, stepIndex int, output map[string]interface{}) error {
+	// Merge output into saga context
+	for k, v := range output {
+		exec.Context[k] = v
+	}
+	if err := o.StateStore.UpdateSagaContext(ctx, uow.Tx(), exec.SagaID, exec.Context); err != nil {
+		return err
+	}
 
-		// Update step input with context
-		exec.Steps[nextStepIndex].Input = exec.Context
+	// Wait for the rest of the group, if any
+	start, end := exec.groupRange(stepIndex)
+	if !exec.allCompleted(start, end) {
+		if exec.settled(start, end) {
+			// ALL_SETTLED group whose failed member was waiting for us
+			return o.startCompensating(ctx, uow, exec, exec.ErrorMessage)
+		}
+		return nil
+	}
 
-		return o.sendStepCommand(uow, exec, nextStepIndex)
-	})
+	// Move to next step, or complete the saga after the last one
+	return o.advanceTo(ctx, uow, exec, end, exec.Context)
 }
 
 // HandleStepFailure processes step failure
 func (o *Orchestrator) HandleStepFailure(ctx context.Context, sagaID string, stepIndex int, errMsg string) error {
-	return sagakit.RunInTx(ctx, o.DB, sagakit.GetGlobalStore(), func(uow sagakit.UnitOfWork) error {
-		exec, err := o.StateStore.GetExecution(ctx, uow.Tx(), sagaID)
-		if err != nil {
+	return o.HandleStepError(ctx, sagaID, stepIndex, StepError{Message: errMsg})
+}
+
+// HandleStepError processes a classified step failure. The step is retried
+// according to its RetryPolicy, otherwise the saga starts compensating.
+func (o *Orchestrator) HandleStepError(ctx context.Context, sagaID string, stepIndex int, stepErr StepError) error {
+	return o.handleStepError(ctx, sagaID, stepIndex, 0, stepErr)
+}
+
+func (o *Orchestrator) handleStepError(ctx context.Context, sagaID string, stepIndex, attempt int, stepErr StepError) error {
+	return o.handleReply(ctx, replyStep, sagaID, stepIndex, attempt, func(uow sagakit.UnitOfWork, exec *SagaExecution) error {
+		return o.failStep(ctx, uow, exec, stepIndex, stepErr)
+	})
+}
+
+// failStep retries the step when its policy allows it, otherwise marks it
+// failed and compensates the saga
+func (o *Orchestrator) failStep(ctx context.Context, uow sagakit.UnitOfWork, exec *SagaExecution, stepIndex int, stepErr StepError) error {
+	step := &exec.Steps[stepIndex]
+	if err := o.cancelChild(ctx, uow, step, stepErr.Error()); err != nil {
+		return err
+	}
+
+	// Check if we should retry; a saga already compensating is not retried
+	if exec.State == StateInProgress && step.RetryPolicy != nil && step.RetryPolicy.ShouldRetry(step.Attempts, stepErr.Class) {
+		step.ErrorMessage = stepErr.Message
+		next := time.Now().Add(step.RetryPolicy.Backoff(step.Attempts))
+		return o.dispatchStep(ctx, uow, exec, stepIndex, next)
+	}
+
+	return o.abortStep(ctx, uow, exec, stepIndex, stepErr.Error())
+}
+
+// abortStep marks the step failed without retrying and compensates the saga.
+// A member of an ALL_SETTLED group waits for its siblings first.
+func (o *Orchestrator) abortStep(ctx context.Context, uow sagakit.UnitOfWork, exec *SagaExecution, stepIndex int, errMsg string) error {
+	// Mark step as failed
+	if err := o.setStepState(ctx, uow, exec, stepIndex, StepFailed, errMsg); err != nil {
+		return err
+	}
+
+	if exec.State == StateCompensating {
+		// Late failure of a group member; compensation is already under way
+		return o.finishCompensation(ctx, uow, exec)
+	}
+
+	start, end := exec.groupRange(stepIndex)
+	if exec.Steps[stepIndex].Join == JoinAllSettled && !exec.settled(start, end) {
+		return o.setSagaState(ctx, uow, exec, exec.State, exec.CurrentStep, errMsg)
+	}
+
+	return o.startCompensating(ctx, uow, exec, errMsg)
+}
+
+// expireStep fails an in-flight step whose deadline has passed. The retry
+// policy still applies, with the failure classified as ErrClassTimeout.
+// In a compensating saga the step is failed without retry, so a group
+// member that never replies cannot hold the compensation forever. An
+// expired compensation is retried through CompensationRetry.
+func (o *Orchestrator) expireStep(ctx context.Context, uow sagakit.UnitOfWork, exec *SagaExecution, stepIndex int) error {
+	step := exec.Steps[stepIndex]
+	if exec.State == StateCompensating && step.State == StepCompensating {
+		return o.failCompensation(ctx, uow, exec, stepIndex, StepError{
+			Class:   ErrClassTimeout,
+			Message: fmt.Sprintf("compensation of step %s timed out", step.StepID),
+		})
+	}
+	if exec.State == StateCompensating && step.State == StepInProgress {
+		errMsg := fmt.Sprintf("%s: step %s timed out after %s", ErrClassTimeout, step.StepID, step.Timeout)
+		if err := o.cancelChild(ctx, uow, &exec.Steps[stepIndex], errMsg); err != nil {
 			return err
 		}
+		return o.abortStep(ctx, uow, exec, stepIndex, errMsg)
+	}
+	if exec.State != StateInProgress || step.State != StepInProgress {
+		// Nothing left to fail; drop the deadline so it is not claimed again
+		exec.Steps[stepIndex].DeadlineAt = nil
+		return o.StateStore.SaveStep(ctx, uow.Tx(), exec.SagaID, &exec.Steps[stepIndex])
+	}
+
+	return o.failStep(ctx, uow, exec, stepIndex, StepError{
+		Class:   ErrClassTimeout,
+		Message: fmt.Sprintf("step %s timed out after %s", step.StepID, step.Timeout),
+	})
+}
 
-		step := exec.Steps[stepIndex]
+// expireSaga compensates a saga whose overall deadline has passed
+func (o *Orchestrator) expireSaga(ctx context.Context, uow sagakit.UnitOfWork, exec *SagaExecution) error {
+	if exec.State != StateInProgress {
+		return nil
+	}
 
-		// Check if we should retry
-		if step.Attempts < exec.Steps[stepIndex].Attempts { // This would come from saga definition
-			// Retry logic would go here
-			return o.sendStepCommand(uow, exec, stepIndex)
-		}
+	errMsg := ErrClassTimeout + ": saga deadline exceeded"
+	if err := o.failInFlight(ctx, uow, exec, errMsg); err != nil {
+		return err
+	}
+
+	return o.startCompensating(ctx, uow, exec, errMsg)
+}
 
-		// Mark step as failed
-		if err := o.StateStore.UpdateStepState(ctx, uow.Tx(), sagaID, stepIndex, StepFailed, nil, errMsg); err != nil {
+// failInFlight marks every in-progress step failed
+func (o *Orchestrator) failInFlight(ctx context.Context, uow sagakit.UnitOfWork, exec *SagaExecution, errMsg string) error {
+	for i := range exec.Steps {
+		if exec.Steps[i].State != StepInProgress {
+			continue
+		}
+		if err := o.cancelChild(ctx, uow, &exec.Steps[i], errMsg); err != nil {
 			return err
 		}
-
-		// Update saga state to compensating
-		if err := o.StateStore.UpdateSagaState(ctx, uow.Tx(), sagaID, StateCompensating, stepIndex, errMsg); err != nil {
+		if err := o.setStepState(ctx, uow, exec, i, StepFailed, errMsg); err != nil {
 			return err
 		}
+	}
+	return nil
+}
 
-		// Start compensation
-		return o.startCompensation(uow, exec, stepIndex)
-	})
+// startCompensating moves the saga to COMPENSATING and compensates every
+// completed step
+func (o *Orchestrator) startCompensating(ctx context.Context, uow sagakit.UnitOfWork, exec *SagaExecution, errMsg string) error {
+	// Update saga state to compensating
+	if err := o.setSagaState(ctx, uow, exec, StateCompensating, exec.CurrentStep, errMsg); err != nil {
+		return err
+	}
+
+	// Start compensation
+	if err := o.startCompensation(ctx, uow, exec); err != nil {
+		return err
+	}
+
+	// Nothing had completed: the saga is compensated already
+	return o.finishCompensation(ctx, uow, exec)
 }
 
 // startCompensation initiates the compensation process
-func (o *Orchestrator) startCompensation(uow sagakit.UnitOfWork, exec *SagaExecution, failedIndex int) error {
+func (o *Orchestrator) startCompensation(ctx context.Context, uow sagakit.UnitOfWork, exec *SagaExecution) error {
 	// Compensate in reverse order
-	for i := failedIndex - 1; i >= 0; i-- {
-		step := exec.Steps[i]
-		if step.State != StepCompleted {
+	for i := len(exec.Steps) - 1; i >= 0; i-- {
+		if exec.Steps[i].State != StepCompleted {
 			continue // Only compensate completed steps
 		}
-
-		msg := map[string]interface{}{
-			"saga_id":    exec.SagaID,
-			"step_id":    step.StepID,
-			"step_index": i,
-			"input":      step.Input,
-			"output":     step.Output,
-		}
-
-		topic := fmt.Sprintf("saga.compensate.%s", step.Service)
-		if err := uow.Publish(topic, msg, map[string]string{
-			"saga_id":    exec.SagaID,
-			"step_index": fmt.Sprintf("%d", i),
-		}); err != nil {
-			return err
-		}
-
-		// Update step state to compensating
-		if err := o.StateStore.UpdateStepState(context.Background(), uow.Tx(), exec.SagaID, i, StepCompensating, nil, ""); err != nil {
+		if err := o.compensateStep(ctx, uow, exec, i, time.Time{}); err != nil {
 			return err
 		}
 	}
@@ -188,41 +384,396 @@ func (o *Orchestrator) startCompensation(uow sagakit.UnitOfWork, exec *SagaExecu
 	return nil
 }
 
+// compensateStep publishes the compensation command of a completed step.
+// A non-zero notBefore holds the command in the outbox until that time.
+func (o *Orchestrator) compensateStep(ctx context.Context, uow sagakit.UnitOfWork, exec *SagaExecution, stepIndex int, notBefore time.Time) error {
+	step := &exec.Steps[stepIndex]
+	if step.SubSaga != nil {
+		return o.compensateChild(ctx, uow, exec, stepIndex)
+	}
+	if step.Compensate == "" {
+		// Wait steps and steps marked NoCompensate have nothing to undo
+		return o.setStepState(ctx, uow, exec, stepIndex, StepCompensated, "")
+	}
+	step.CompensationAttempts++
+
+	msg := map[string]interface{}{
+		"saga_id":    exec.SagaID,
+		"step_id":    step.StepID,
+		"step_index": stepIndex,
+		"command":    step.Compensate,
+		"input":      step.Input,
+		"output":     step.Output,
+		"attempt":    step.CompensationAttempts,
+	}
+
+	topic := CompensateTopic(step.Service)
+	metadata := map[string]string{
+		"saga_id":    exec.SagaID,
+		"step_index": fmt.Sprintf("%d", stepIndex),
+	}
+
+	payload := map[string]interface{}{"output": step.Output}
+	sentAt := time.Now()
+	var err error
+	if notBefore.After(sentAt) {
+		sentAt = notBefore
+		payload["not_before"] = notBefore
+		err = uow.PublishAt(topic, msg, metadata, notBefore)
+	} else {
+		err = uow.Publish(topic, msg, metadata)
+	}
+	if err != nil {
+		return err
+	}
+
+	// Update step state to compensating
+	from := step.State
+	step.State = StepCompensating
+	step.NextAttemptAt = nil
+	deadline := sentAt.Add(o.compensationTimeout(step))
+	step.DeadlineAt = &deadline
+	if err := o.StateStore.SaveStep(ctx, uow.Tx(), exec.SagaID, step); err != nil {
+		return err
+	}
+	return o.recordStepEvent(ctx, uow, exec, stepIndex, EventCompensationSent, from, step.CompensationAttempts, payload, step.ErrorMessage)
+}
+
 // HandleCompensationSuccess processes successful compensation
 func (o *Orchestrator) HandleCompensationSuccess(ctx context.Context, sagaID string, stepIndex int) error {
-	return sagakit.RunInTx(ctx, o.DB, sagakit.GetGlobalStore(), func(uow sagakit.UnitOfWork) error {
-		exec, err := o.StateStore.GetExecution(ctx, uow.Tx(), sagaID)
-		if err != nil {
+	return o.handleCompensationSuccess(ctx, sagaID, stepIndex, 0)
+}
+
+func (o *Orchestrator) handleCompensationSuccess(ctx context.Context, sagaID string, stepIndex, attempt int) error {
+	return o.handleReply(ctx, replyCompensation, sagaID, stepIndex, attempt, func(uow sagakit.UnitOfWork, exec *SagaExecution) error {
+		// Update step state
+		if err := o.setStepState(ctx, uow, exec, stepIndex, StepCompensated, ""); err != nil {
 			return err
 		}
 
-		// Update step state
-		if err := o.StateStore.UpdateStepState(ctx, uow.Tx(), sagaID, stepIndex, StepCompensated, nil, ""); err != nil {
+		return o.finishCompensation(ctx, uow, exec)
+	})
+}
+
+// HandleCompensationFailure processes failed compensation
+func (o *Orchestrator) HandleCompensationFailure(ctx context.Context, sagaID string, stepIndex int, errMsg string) error {
+	return o.HandleCompensationError(ctx, sagaID, stepIndex, StepError{Message: errMsg})
+}
+
+// HandleCompensationError processes a classified compensation failure. The
+// compensation is re-sent according to CompensationRetry; once that is
+// exhausted the step is given up and the saga ends FAILED.
+func (o *Orchestrator) HandleCompensationError(ctx context.Context, sagaID string, stepIndex int, stepErr StepError) error {
+	return o.handleCompensationError(ctx, sagaID, stepIndex, 0, stepErr)
+}
+
+func (o *Orchestrator) handleCompensationError(ctx context.Context, sagaID string, stepIndex, attempt int, stepErr StepError) error {
+	return o.handleReply(ctx, replyCompensation, sagaID, stepIndex, attempt, func(uow sagakit.UnitOfWork, exec *SagaExecution) error {
+		return o.failCompensation(ctx, uow, exec, stepIndex, stepErr)
+	})
+}
+
+// failCompensation re-sends a failed compensation when CompensationRetry
+// allows it, otherwise gives the step up so the saga ends FAILED
+func (o *Orchestrator) failCompensation(ctx context.Context, uow sagakit.UnitOfWork, exec *SagaExecution, stepIndex int, stepErr StepError) error {
+	step := &exec.Steps[stepIndex]
+	policy := o.compensationRetry()
+	if policy.ShouldRetry(step.CompensationAttempts, stepErr.Class) {
+		step.ErrorMessage = stepErr.Message
+		next := time.Now().Add(policy.Backoff(step.CompensationAttempts))
+		return o.compensateStep(ctx, uow, exec, stepIndex, next)
+	}
+
+	if err := o.setStepState(ctx, uow, exec, stepIndex, StepCompensationFailed, stepErr.Error()); err != nil {
+		return err
+	}
+	return o.finishCompensation(ctx, uow, exec)
+}
+
+func (o *Orchestrator) compensationRetry() *RetryPolicy {
+	if o.CompensationRetry != nil {
+		return o.CompensationRetry
+	}
+	return DefaultCompensationRetry
+}
+
+func (o *Orchestrator) compensationTimeout(step *StepExecution) time.Duration {
+	switch {
+	case o.CompensationTimeout > 0:
+		return o.CompensationTimeout
+	case step.Timeout > 0:
+		return step.Timeout
+	}
+	return DefaultCompensationTimeout
+}
+
+// finishCompensation ends a compensating saga once no step is in flight or
+// compensating any more: COMPENSATED when every compensation succeeded,
+// FAILED otherwise
+func (o *Orchestrator) finishCompensation(ctx context.Context, uow sagakit.UnitOfWork, exec *SagaExecution) error {
+	if exec.State != StateCompensating {
+		return nil
+	}
+
+	var failed []int
+	for i, step := range exec.Steps {
+		switch step.State {
+		case StepInProgress, StepCompensating:
+			return nil
+		case StepCompensationFailed:
+			failed = append(failed, i)
+		}
+	}
+
+	if len(failed) == 0 {
+		return o.setSagaState(ctx, uow, exec, StateCompensated, exec.CurrentStep, exec.ErrorMessage)
+	}
+
+	if err := o.setSagaState(ctx, uow, exec, StateFailed, exec.CurrentStep, exec.ErrorMessage); err != nil {
+		return err
+	}
+	return o.raiseAlert(uow, exec, failed)
+}
+
+// resumeCompensation re-sends the compensations of a compensating saga that
+// have not been confirmed yet. A FAILED saga goes back to COMPENSATING and
+// re-sends those that gave up.
+func (o *Orchestrator) resumeCompensation(ctx context.Context, uow sagakit.UnitOfWork, exec *SagaExecution) error {
+	resend := StepCompensating
+	if exec.State == StateFailed {
+		resend = StepCompensationFailed
+		if err := o.setSagaState(ctx, uow, exec, StateCompensating, exec.CurrentStep, exec.ErrorMessage); err != nil {
 			return err
 		}
+	}
+	for i := len(exec.Steps) - 1; i >= 0; i-- {
+		if exec.Steps[i].State != resend {
+			continue
+		}
+		if err := o.compensateStep(ctx, uow, exec, i, time.Time{}); err != nil {
+			return err
+		}
+	}
+	return o.finishCompensation(ctx, uow, exec)
+}
 
-		// Check if all compensations are done
-		allCompensated := true
-		for i, step := range exec.Steps {
-			if i >= exec.CurrentStep {
-				break
+// advanceTo moves the saga to index and dispatches the step there, or every
+// member of the step group starting there. Branches met on the way are
+// resolved and steps whose guard does not hold are skipped. Past the last
+// step the saga is completed. A non-nil input replaces the compiled input
+// of the dispatched steps.
+func (o *Orchestrator) advanceTo(ctx context.Context, uow sagakit.UnitOfWork, exec *SagaExecution, index int, input map[string]interface{}) error {
+	for index < len(exec.Steps) {
+		if err := o.resolveBranch(ctx, uow, exec, index); err != nil {
+			return o.abortStep(ctx, uow, exec, index, err.Error())
+		}
+
+		start, end := exec.groupRange(index)
+		dispatched := false
+		for i := start; i < end; i++ {
+			step := &exec.Steps[i]
+			if step.State == StepSkipped {
+				continue
+			}
+
+			ok, err := evalCondition(step.Condition, exec.Context)
+			if err != nil {
+				return o.abortStep(ctx, uow, exec, i, err.Error())
+			}
+			if !ok {
+				if err := o.setStepState(ctx, uow, exec, i, StepSkipped, ""); err != nil {
+					return err
+				}
+				continue
+			}
+
+			if !dispatched {
+				if err := o.setSagaState(ctx, uow, exec, StateInProgress, start, ""); err != nil {
+					return err
+				}
+				dispatched = true
+			}
+			if input != nil {
+				step.Input = input
 			}
-			if step.State == StepCompleted && exec.Steps[i].State != StepCompensated {
-				allCompensated = false
-				break
+			if err := o.dispatchStep(ctx, uow, exec, i, time.Time{}); err != nil {
+				return err
+			}
+			if exec.State != StateInProgress {
+				return nil // a sub-saga could not be started
 			}
 		}
 
-		if allCompensated {
-			return o.StateStore.UpdateSagaState(ctx, uow.Tx(), sagaID, StateCompensated, exec.CurrentStep, exec.ErrorMessage)
+		if dispatched {
+			return nil
+		}
+		index = end
+	}
+
+	// Saga completed successfully
+	return o.setSagaState(ctx, uow, exec, StateCompleted, len(exec.Steps)-1, "")
+}
+
+// resolveBranch picks the case of the branch whose first step is at index,
+// marking the steps of every other case skipped. Branches already decided
+// and steps outside a branch are left alone.
+func (o *Orchestrator) resolveBranch(ctx context.Context, uow sagakit.UnitOfWork, exec *SagaExecution, index int) error {
+	branch := exec.Steps[index].Branch
+	if branch == nil {
+		return nil
+	}
+
+	var members []int
+	for i := range exec.Steps {
+		b := exec.Steps[i].Branch
+		if b == nil || b.ID != branch.ID {
+			continue
+		}
+		if exec.Steps[i].State != StepPending {
+			return nil // already decided
+		}
+		members = append(members, i)
+	}
+
+	chosen, err := chooseBranchCase(branch, exec.Context)
+	if err != nil {
+		return err
+	}
+
+	for _, i := range members {
+		if exec.Steps[i].Branch.Case == chosen {
+			continue
+		}
+		if err := o.setStepState(ctx, uow, exec, i, StepSkipped, ""); err != nil {
+			return err
 		}
+	}
+	return nil
+}
+
+// setStepState changes the state of a step in exec and persists the step
+func (o *Orchestrator) setStepState(ctx context.Context, uow sagakit.UnitOfWork, exec *SagaExecution, stepIndex int, state StepState, errMsg string) error {
+	step := &exec.Steps[stepIndex]
+	from := step.State
+	step.State = state
+	step.ErrorMessage = errMsg
+	if state != StepInProgress {
+		step.NextAttemptAt = nil
+		step.DeadlineAt = nil
+	}
+	if state == StepCompleted || state == StepFailed || state == StepCompensated || state == StepCompensationFailed {
+		now := time.Now()
+		step.CompletedAt = &now
+	}
+
+	if err := o.StateStore.SaveStep(ctx, uow.Tx(), exec.SagaID, step); err != nil {
+		return err
+	}
+	if from == state {
+		return nil
+	}
+
+	attempt := step.Attempts
+	var payload map[string]interface{}
+	switch state {
+	case StepCompleted, StepSkipped:
+		payload = step.Output
+	case StepCompensating, StepCompensated, StepCompensationFailed:
+		attempt = step.CompensationAttempts
+	}
+	return o.recordStepEvent(ctx, uow, exec, stepIndex, EventStepState, from, attempt, payload, errMsg)
+}
 
+// setSagaState changes the state of exec and persists it
+func (o *Orchestrator) setSagaState(ctx context.Context, uow sagakit.UnitOfWork, exec *SagaExecution, state SagaState, currentStep int, errMsg string) error {
+	from := exec.State
+	exec.State = state
+	exec.CurrentStep = currentStep
+	exec.ErrorMessage = errMsg
+	exec.UpdatedAt = time.Now()
+
+	if err := o.StateStore.UpdateSagaState(ctx, uow.Tx(), exec.SagaID, state, currentStep, errMsg); err != nil {
+		return err
+	}
+	if from == state {
 		return nil
+	}
+
+	err := o.StateStore.AppendEvent(ctx, uow.Tx(), &SagaEvent{
+		SagaID:    exec.SagaID,
+		Type:      EventSagaState,
+		FromState: string(from),
+		ToState:   string(state),
+		Error:     errMsg,
+		CreatedAt: exec.UpdatedAt,
 	})
+	if err != nil {
+		return err
+	}
+	return o.notifyParent(uow, exec)
+}
+
+// dispatchStep records a new attempt of the step and publishes its command.
+// A non-zero notBefore holds the command in the outbox until that time.
+// Wait steps publish nothing and start waiting right away; sub-saga steps
+// start their child saga right away.
+func (o *Orchestrator) dispatchStep(ctx context.Context, uow sagakit.UnitOfWork, exec *SagaExecution, stepIndex int, notBefore time.Time) error {
+	step := &exec.Steps[stepIndex]
+	from := step.State
+	if step.Wait != nil {
+		key, err := step.Wait.key(exec.Context)
+		if err != nil {
+			return o.abortStep(ctx, uow, exec, stepIndex, err.Error())
+		}
+		notBefore = time.Time{}
+		step.WaitKey = key
+	}
+	if step.SubSaga != nil {
+		notBefore = time.Time{}
+	}
+
+	startedAt := time.Now()
+	step.NextAttemptAt = nil
+	if notBefore.After(startedAt) {
+		startedAt = notBefore
+		step.NextAttemptAt = &notBefore
+	}
+
+	step.State = StepInProgress
+	step.Attempts++
+	step.StartedAt = &startedAt
+	step.CompletedAt = nil
+	step.DeadlineAt = nil
+	if step.Timeout > 0 {
+		deadline := startedAt.Add(step.Timeout)
+		step.DeadlineAt = &deadline
+	}
+
+	if err := o.StateStore.SaveStep(ctx, uow.Tx(), exec.SagaID, step); err != nil {
+		return err
+	}
+
+	if step.Wait != nil {
+		payload := map[string]interface{}{"event": step.Wait.Event, "wait_key": step.WaitKey}
+		return o.recordStepEvent(ctx, uow, exec, stepIndex, EventWaitStarted, from, step.Attempts, payload, step.ErrorMessage)
+	}
+	if step.SubSaga != nil {
+		return o.startChild(ctx, uow, exec, stepIndex, from)
+	}
+
+	payload := map[string]interface{}{"input": step.Input}
+	if step.NextAttemptAt != nil {
+		payload["not_before"] = *step.NextAttemptAt
+	}
+	if err := o.recordStepEvent(ctx, uow, exec, stepIndex, EventCommandSent, from, step.Attempts, payload, step.ErrorMessage); err != nil {
+		return err
+	}
+
+	return o.sendStepCommand(uow, exec, stepIndex, notBefore)
 }
 
 // sendStepCommand publishes a command for a saga step
-func (o *Orchestrator) sendStepCommand(uow sagakit.UnitOfWork, exec *SagaExecution, stepIndex int) error {
+func (o *Orchestrator) sendStepCommand(uow sagakit.UnitOfWork, exec *SagaExecution, stepIndex int, notBefore time.Time) error {
 	step := exec.Steps[stepIndex]
 
 	msg := map[string]interface{}{
@@ -231,13 +782,19 @@ func (o *Orchestrator) sendStepCommand(uow sagakit.UnitOfWork, exec *SagaExecuti
 		"step_index": stepIndex,
 		"command":    step.Command,
 		"input":      step.Input,
+		"attempt":    step.Attempts,
 	}
 
-	topic := fmt.Sprintf("saga.command.%s", step.Service)
-	return uow.Publish(topic, msg, map[string]string{
+	topic := CommandTopic(step.Service)
+	metadata := map[string]string{
 		"saga_id":    exec.SagaID,
 		"step_index": fmt.Sprintf("%d", stepIndex),
-	})
+	}
+
+	if step.NextAttemptAt != nil {
+		return uow.PublishAt(topic, msg, metadata, notBefore)
+	}
+	return uow.Publish(topic, msg, metadata)
 }
 
 // SetupEventHandlers subscribes to saga events
@@ -247,59 +804,116 @@ func (o *Orchestrator) SetupEventHandlers(ctx context.Context, subscriber messag
 		return err
 	}
 
+	// Restore trace/request/tenant context from message headers
+	router.AddMiddleware(sagakit.ContextMiddleware)
+
 	// Handle step success events
 	router.AddConsumerHandler(
 		"saga_step_success",
-		"saga.event.step.success",
+		TopicStepSuccess,
 		subscriber,
 		func(msg *message.Message) error {
 			var event struct {
 				SagaID    string                 `json:"saga_id"`
 				StepIndex int                    `json:"step_index"`
+				Attempt   int                    `json:"attempt"`
 				Output    map[string]interface{} `json:"output"`
 			}
 			if err := json.Unmarshal(msg.Payload, &event); err != nil {
 				return err
 			}
-			return o.HandleStepSuccess(ctx, event.SagaID, event.StepIndex, event.Output)
+			return o.handleStepSuccess(msg.Context(), event.SagaID, event.StepIndex, event.Attempt, event.Output)
 		},
 	)
 
 	// Handle step failure events
 	router.AddConsumerHandler(
 		"saga_step_failure",
-		"saga.event.step.failure",
+		TopicStepFailure,
 		subscriber,
 		func(msg *message.Message) error {
 			var event struct {
 				SagaID    string `json:"saga_id"`
 				StepIndex int    `json:"step_index"`
-				Error     string `json:"error"`
+				Attempt   int    `json:"attempt"`
+				StepError
 			}
 			if err := json.Unmarshal(msg.Payload, &event); err != nil {
 				return err
 			}
-			return o.HandleStepFailure(ctx, event.SagaID, event.StepIndex, event.Error)
+			return o.handleStepError(msg.Context(), event.SagaID, event.StepIndex, event.Attempt, event.StepError)
 		},
 	)
 
 	// Handle compensation success events
 	router.AddConsumerHandler(
 		"saga_compensation_success",
-		"saga.event.compensation.success",
+		TopicCompensationSuccess,
 		subscriber,
 		func(msg *message.Message) error {
 			var event struct {
 				SagaID    string `json:"saga_id"`
 				StepIndex int    `json:"step_index"`
+				Attempt   int    `json:"attempt"`
 			}
 			if err := json.Unmarshal(msg.Payload, &event); err != nil {
 				return err
 			}
-			return o.HandleCompensationSuccess(ctx, event.SagaID, event.StepIndex)
+			return o.handleCompensationSuccess(msg.Context(), event.SagaID, event.StepIndex, event.Attempt)
 		},
 	)
 
+	// Handle compensation failure events
+	router.AddConsumerHandler(
+		"saga_compensation_failure",
+		TopicCompensationFailure,
+		subscriber,
+		func(msg *message.Message) error {
+			var event struct {
+				SagaID    string `json:"saga_id"`
+				StepIndex int    `json:"step_index"`
+				Attempt   int    `json:"attempt"`
+				StepError
+			}
+			if err := json.Unmarshal(msg.Payload, &event); err != nil {
+				return err
+			}
+			return o.handleCompensationError(msg.Context(), event.SagaID, event.StepIndex, event.Attempt, event.StepError)
+		},
+	)
+
+	// Complete wait steps waiting for these events
+	for _, topic := range o.WaitTopics {
+		router.AddConsumerHandler(
+			"saga_wait_"+topic,
+			topic,
+			subscriber,
+			func(msg *message.Message) error {
+				var payload map[string]interface{}
+				if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+					return nil // not an object; no wait step can match it
+				}
+				return o.HandleWaitEvent(msg.Context(), topic, payload)
+			},
+		)
+	}
+
+	// Hand sagas that need manual intervention to the alert hook
+	if o.AlertHook != nil {
+		router.AddConsumerHandler(
+			"saga_failed_alert",
+			TopicSagaFailed,
+			subscriber,
+			func(msg *message.Message) error {
+				var alert SagaAlert
+				if err := json.Unmarshal(msg.Payload, &alert); err != nil {
+					return err
+				}
+				return o.AlertHook(msg.Context(), alert)
+			},
+		)
+	}
+
 	o.Router = router
 	go func() {
 		if err := router.Run(ctx); err != nil {
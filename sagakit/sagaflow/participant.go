@@ -0,0 +1,285 @@
+package sagaflow
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"shared/sagakit"
+	"shared/sagakit/db"
+	"shared/sagakit/outbox"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/message"
+)
+
+// Error classes reported by Participant when no handler ran
+const (
+	ErrClassUnknownCommand = "unknown_command"
+	ErrClassInvalidInput   = "invalid_input"
+)
+
+// Command is a decoded saga.command.<service> message
+type Command[T any] struct {
+	SagaID    string
+	StepID    string
+	StepIndex int
+	Attempt   int
+	Name      string
+	Input     T
+}
+
+// Compensation is a decoded saga.compensate.<service> message. Output is
+// what the step returned when it completed.
+type Compensation[T any] struct {
+	SagaID    string
+	StepID    string
+	StepIndex int
+	Attempt   int
+	Name      string
+	Input     T
+	Output    map[string]interface{}
+}
+
+// CommandHandler runs a step. Writes go through uow.Tx() so they commit
+// together with the reply; the returned output is merged into the saga
+// context. Return a StepError to classify the failure for retries.
+type CommandHandler[T any] func(ctx context.Context, uow sagakit.UnitOfWork, cmd Command[T]) (map[string]interface{}, error)
+
+// CompensationHandler undoes a step
+type CompensationHandler[T any] func(ctx context.Context, uow sagakit.UnitOfWork, comp Compensation[T]) error
+
+// Participant receives the commands and compensations addressed to one
+// service, dispatches them by command name and publishes the reply in the
+// same transaction as the handler's own work. Redelivered messages are
+// recognised through the Inbox and not handled twice.
+type Participant struct {
+	Service string
+	DB      db.DB
+	// Store receives the replies; it must belong to DB. nil uses the
+	// sagakit global store.
+	Store  outbox.Store
+	Inbox  Inbox
+	Router *message.Router
+
+	commands      map[string]participantHandler
+	compensations map[string]participantHandler
+}
+
+type participantHandler func(ctx context.Context, uow sagakit.UnitOfWork, msg participantMessage) (map[string]interface{}, error)
+
+// participantMessage is the wire format shared by commands and compensations
+type participantMessage struct {
+	SagaID    string                 `json:"saga_id"`
+	StepID    string                 `json:"step_id"`
+	StepIndex int                    `json:"step_index"`
+	Command   string                 `json:"command"`
+	Attempt   int                    `json:"attempt"`
+	Input     json.RawMessage        `json:"input"`
+	Output    map[string]interface{} `json:"output,omitempty"`
+}
+
+// NewParticipant creates a participant on database. The outbox and inbox
+// follow the database: Mongo collections for an mgo.DB, PostgreSQL tables
+// and the sagakit global store otherwise.
+func NewParticipant(service string, database db.DB) *Participant {
+	store, inbox := defaultStores(database)
+	return &Participant{
+		Service:       service,
+		DB:            database,
+		Store:         store,
+		Inbox:         inbox,
+		commands:      map[string]participantHandler{},
+		compensations: map[string]participantHandler{},
+	}
+}
+
+// HandleCommand registers the handler for a step command
+func HandleCommand[T any](p *Participant, name string, h CommandHandler[T]) {
+	p.commands[name] = func(ctx context.Context, uow sagakit.UnitOfWork, msg participantMessage) (map[string]interface{}, error) {
+		var input T
+		if err := decodeInput(msg.Input, &input); err != nil {
+			return nil, err
+		}
+		return h(ctx, uow, Command[T]{
+			SagaID:    msg.SagaID,
+			StepID:    msg.StepID,
+			StepIndex: msg.StepIndex,
+			Attempt:   msg.Attempt,
+			Name:      msg.Command,
+			Input:     input,
+		})
+	}
+}
+
+// HandleCompensation registers the handler for a compensation command
+func HandleCompensation[T any](p *Participant, name string, h CompensationHandler[T]) {
+	p.compensations[name] = func(ctx context.Context, uow sagakit.UnitOfWork, msg participantMessage) (map[string]interface{}, error) {
+		var input T
+		if err := decodeInput(msg.Input, &input); err != nil {
+			return nil, err
+		}
+		return nil, h(ctx, uow, Compensation[T]{
+			SagaID:    msg.SagaID,
+			StepID:    msg.StepID,
+			StepIndex: msg.StepIndex,
+			Attempt:   msg.Attempt,
+			Name:      msg.Command,
+			Input:     input,
+			Output:    msg.Output,
+		})
+	}
+}
+
+func decodeInput(raw json.RawMessage, v any) error {
+	if len(raw) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		return StepError{Class: ErrClassInvalidInput, Message: err.Error()}
+	}
+	return nil
+}
+
+// InitSchema creates the inbox used to recognise redelivered commands
+func (p *Participant) InitSchema(ctx context.Context, tx db.Tx) error {
+	return p.Inbox.InitSchema(ctx, tx)
+}
+
+// SetupHandlers subscribes to the service's command and compensation topics
+func (p *Participant) SetupHandlers(ctx context.Context, subscriber message.Subscriber) error {
+	router, err := message.NewRouter(message.RouterConfig{}, sagakit.GetLogger())
+	if err != nil {
+		return err
+	}
+
+	// Restore trace/request/tenant context from message headers
+	router.AddMiddleware(sagakit.ContextMiddleware)
+
+	router.AddConsumerHandler(
+		fmt.Sprintf("saga_command_%s", p.Service),
+		CommandTopic(p.Service),
+		subscriber,
+		func(msg *message.Message) error {
+			return p.handle(msg.Context(), msg, false)
+		},
+	)
+
+	router.AddConsumerHandler(
+		fmt.Sprintf("saga_compensate_%s", p.Service),
+		CompensateTopic(p.Service),
+		subscriber,
+		func(msg *message.Message) error {
+			return p.handle(msg.Context(), msg, true)
+		},
+	)
+
+	p.Router = router
+	go func() {
+		if err := router.Run(ctx); err != nil {
+			fmt.Printf("Router error: %v\n", err)
+		}
+	}()
+
+	<-router.Running()
+	return nil
+}
+
+// handlerError marks an error returned by a registered handler, as opposed
+// to a failure of the transaction around it
+type handlerError struct{ err error }
+
+func (e handlerError) Error() string { return e.err.Error() }
+func (e handlerError) Unwrap() error { return e.err }
+
+func (p *Participant) handle(ctx context.Context, raw *message.Message, compensation bool) error {
+	var msg participantMessage
+	if err := json.Unmarshal(raw.Payload, &msg); err != nil {
+		// Not a saga message; nothing can be replied to
+		sagakit.GetLogger().Error("invalid saga message", err, watermill.LogFields{"service": p.Service})
+		return nil
+	}
+
+	kind, handlers := "command", p.commands
+	if compensation {
+		kind, handlers = "compensate", p.compensations
+	}
+	key := fmt.Sprintf("%s:%d:%d:%s", msg.SagaID, msg.StepIndex, msg.Attempt, kind)
+
+	h, ok := handlers[msg.Command]
+	if !ok {
+		h = func(context.Context, sagakit.UnitOfWork, participantMessage) (map[string]interface{}, error) {
+			return nil, StepError{Class: ErrClassUnknownCommand, Message: fmt.Sprintf("%s has no handler for %q", p.Service, msg.Command)}
+		}
+	}
+
+	err := p.runInTx(ctx, func(uow sagakit.UnitOfWork) error {
+		fresh, err := p.Inbox.ClaimTx(ctx, uow.Tx(), p.Service, key)
+		if err != nil || !fresh {
+			return err
+		}
+
+		output, err := h(ctx, uow, msg)
+		if err != nil {
+			return handlerError{err}
+		}
+		return p.reply(uow, msg, compensation, output, nil)
+	})
+
+	var herr handlerError
+	if !errors.As(err, &herr) {
+		// Success, duplicate, or an infrastructure error worth redelivering
+		return err
+	}
+
+	// The handler's work was rolled back; record the failure on its own
+	return p.runInTx(ctx, func(uow sagakit.UnitOfWork) error {
+		fresh, err := p.Inbox.ClaimTx(ctx, uow.Tx(), p.Service, key)
+		if err != nil || !fresh {
+			return err
+		}
+		return p.reply(uow, msg, compensation, nil, herr.err)
+	})
+}
+
+// runInTx runs fn in a transaction on DB whose messages go to Store
+func (p *Participant) runInTx(ctx context.Context, fn func(uow sagakit.UnitOfWork) error) error {
+	store := p.Store
+	if store == nil {
+		store = sagakit.GetGlobalStore()
+	}
+	return sagakit.RunInTx(ctx, p.DB, store, fn)
+}
+
+func (p *Participant) reply(uow sagakit.UnitOfWork, msg participantMessage, compensation bool, output map[string]interface{}, handlerErr error) error {
+	event := map[string]interface{}{
+		"saga_id":    msg.SagaID,
+		"step_id":    msg.StepID,
+		"step_index": msg.StepIndex,
+		"attempt":    msg.Attempt,
+	}
+
+	var topic string
+	switch {
+	case handlerErr != nil:
+		stepErr := StepError{Message: handlerErr.Error()}
+		errors.As(handlerErr, &stepErr)
+		event["error"] = stepErr.Message
+		event["error_class"] = stepErr.Class
+		topic = TopicStepFailure
+		if compensation {
+			topic = TopicCompensationFailure
+		}
+	case compensation:
+		topic = TopicCompensationSuccess
+	default:
+		event["output"] = output
+		topic = TopicStepSuccess
+	}
+
+	return uow.Publish(topic, event, map[string]string{
+		"saga_id":    msg.SagaID,
+		"step_index": fmt.Sprintf("%d", msg.StepIndex),
+	})
+}
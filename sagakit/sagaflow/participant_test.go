@@ -0,0 +1,155 @@
+package sagaflow
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"shared/sagakit"
+	"shared/sagakit/db"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/message"
+)
+
+type reserveInput struct {
+	SKU string `json:"sku"`
+}
+
+// newMemParticipant creates a participant of service "svc" on a fresh memDB
+func newMemParticipant() (*Participant, *memDB) {
+	database := newMemDB()
+	p := NewParticipant("svc", database)
+	p.Store = memOutbox{}
+	p.Inbox = memInbox{}
+	return p, database
+}
+
+func sagaMessage(t *testing.T, command string, stepIndex, attempt int) *message.Message {
+	t.Helper()
+	payload, err := json.Marshal(participantMessage{
+		SagaID:    "saga-1",
+		StepID:    "reserve",
+		StepIndex: stepIndex,
+		Command:   command,
+		Attempt:   attempt,
+		Input:     json.RawMessage(`{"sku":"A-1"}`),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return message.NewMessage(watermill.NewUUID(), payload)
+}
+
+func TestParticipantHandlesRedeliveriesOnce(t *testing.T) {
+	ctx := context.Background()
+	p, database := newMemParticipant()
+
+	var commands, compensations int
+	HandleCommand(p, "reserve", func(_ context.Context, _ sagakit.UnitOfWork, cmd Command[reserveInput]) (map[string]interface{}, error) {
+		commands++
+		if cmd.Input.SKU != "A-1" {
+			t.Errorf("input = %+v", cmd.Input)
+		}
+		return map[string]interface{}{"reservation": "r-1"}, nil
+	})
+	HandleCompensation(p, "release", func(context.Context, sagakit.UnitOfWork, Compensation[reserveInput]) error {
+		compensations++
+		return nil
+	})
+
+	deliveries := []struct {
+		msg          *message.Message
+		compensation bool
+	}{
+		{sagaMessage(t, "reserve", 0, 1), false},
+		{sagaMessage(t, "reserve", 0, 1), false}, // redelivered
+		{sagaMessage(t, "reserve", 0, 2), false}, // retried by the orchestrator
+		{sagaMessage(t, "release", 0, 2), true},
+		{sagaMessage(t, "release", 0, 2), true}, // redelivered
+	}
+	for _, d := range deliveries {
+		if err := p.handle(ctx, d.msg, d.compensation); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if commands != 2 || compensations != 1 {
+		t.Errorf("handled %d commands and %d compensations, want 2 and 1", commands, compensations)
+	}
+	replies := database.published(TopicStepSuccess)
+	if len(replies) != 2 || replies[0].Payload["output"].(map[string]interface{})["reservation"] != "r-1" {
+		t.Errorf("step replies = %+v, want two carrying the output", replies)
+	}
+	if n := len(database.published(TopicCompensationSuccess)); n != 1 {
+		t.Errorf("sent %d compensation replies, want 1", n)
+	}
+}
+
+func TestParticipantReportsFailures(t *testing.T) {
+	ctx := context.Background()
+	p, database := newMemParticipant()
+
+	calls := 0
+	HandleCommand(p, "reserve", func(_ context.Context, uow sagakit.UnitOfWork, _ Command[reserveInput]) (map[string]interface{}, error) {
+		calls++
+		if err := uow.Publish("stock.reserved", map[string]string{"sku": "A-1"}, nil); err != nil {
+			return nil, err
+		}
+		return nil, StepError{Class: "out_of_stock", Message: "no stock left"}
+	})
+
+	for range 2 {
+		if err := p.handle(ctx, sagaMessage(t, "reserve", 0, 1), false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := p.handle(ctx, sagaMessage(t, "ship", 1, 1), false); err != nil {
+		t.Fatal(err)
+	}
+
+	if calls != 1 {
+		t.Errorf("handler ran %d times, want 1", calls)
+	}
+	if msgs := database.published("stock.reserved"); len(msgs) != 0 {
+		t.Errorf("the failed handler's work was published: %+v", msgs)
+	}
+
+	failures := database.published(TopicStepFailure)
+	if len(failures) != 2 {
+		t.Fatalf("sent %d failure replies, want 2", len(failures))
+	}
+	if got := failures[0].Payload; got["error_class"] != "out_of_stock" || got["error"] != "no stock left" {
+		t.Errorf("failure reply = %+v", got)
+	}
+	if got := failures[1].Payload; got["error_class"] != ErrClassUnknownCommand || got["step_index"] != float64(1) {
+		t.Errorf("unknown command reply = %+v", got)
+	}
+}
+
+func TestParticipantRedeliversOnInfrastructureErrors(t *testing.T) {
+	p, database := newMemParticipant()
+	p.Inbox = failingInbox{}
+
+	HandleCommand(p, "reserve", func(context.Context, sagakit.UnitOfWork, Command[reserveInput]) (map[string]interface{}, error) {
+		t.Error("handler ran without an inbox claim")
+		return nil, nil
+	})
+
+	err := p.handle(context.Background(), sagaMessage(t, "reserve", 0, 1), false)
+	if !errors.Is(err, errInboxDown) {
+		t.Errorf("handle() = %v, want %v so the message is redelivered", err, errInboxDown)
+	}
+	if len(database.state.messages) != 0 {
+		t.Errorf("published %+v, want nothing", database.state.messages)
+	}
+}
+
+var errInboxDown = errors.New("inbox down")
+
+type failingInbox struct{ memInbox }
+
+func (failingInbox) ClaimTx(context.Context, db.Tx, string, string) (bool, error) {
+	return false, errInboxDown
+}
@@ -0,0 +1,199 @@
+package sagaflow
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"shared/sagakit/db"
+)
+
+var ErrDefinitionNotFound = errors.New("saga definition not found")
+
+// DefinitionStore looks up versioned saga definitions
+type DefinitionStore interface {
+	Get(ctx context.Context, name string, version int) (Saga, error)
+	Latest(ctx context.Context, name string) (Saga, error)
+}
+
+// MemoryDefinitions holds definitions registered in code or loaded from files
+type MemoryDefinitions struct {
+	KnownServices []string
+
+	mu   sync.RWMutex
+	defs map[string]map[int]Saga
+}
+
+func NewMemoryDefinitions(knownServices ...string) *MemoryDefinitions {
+	return &MemoryDefinitions{
+		KnownServices: knownServices,
+		defs:          map[string]map[int]Saga{},
+	}
+}
+
+// Register validates and adds a definition. A version, once registered,
+// cannot be replaced, and it may not start itself through its sub-sagas.
+func (m *MemoryDefinitions) Register(s Saga) error {
+	if err := s.Validate(m.KnownServices...); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := checkSubSagaCycles(s, m.lookup); err != nil {
+		return err
+	}
+	if m.defs[s.Name] == nil {
+		m.defs[s.Name] = map[int]Saga{}
+	}
+	if _, exists := m.defs[s.Name][s.Version]; exists {
+		return fmt.Errorf("saga %q version %d already registered", s.Name, s.Version)
+	}
+	m.defs[s.Name][s.Version] = s
+	return nil
+}
+
+// LoadDir registers every definition file in dir
+func (m *MemoryDefinitions) LoadDir(dir string) error {
+	sagas, err := LoadDefinitionDir(dir, m.KnownServices...)
+	if err != nil {
+		return err
+	}
+	for _, s := range sagas {
+		if err := m.Register(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *MemoryDefinitions) Get(ctx context.Context, name string, version int) (Saga, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.lookup(name, version)
+}
+
+func (m *MemoryDefinitions) Latest(ctx context.Context, name string) (Saga, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.lookup(name, 0)
+}
+
+// lookup returns a definition, the latest one for version 0. The caller
+// holds mu.
+func (m *MemoryDefinitions) lookup(name string, version int) (Saga, error) {
+	if version != 0 {
+		s, ok := m.defs[name][version]
+		if !ok {
+			return Saga{}, fmt.Errorf("%w: %s v%d", ErrDefinitionNotFound, name, version)
+		}
+		return s, nil
+	}
+
+	var latest Saga
+	for v, s := range m.defs[name] {
+		if v > latest.Version {
+			latest = s
+		}
+	}
+	if latest.Version == 0 {
+		return Saga{}, fmt.Errorf("%w: %s", ErrDefinitionNotFound, name)
+	}
+	return latest, nil
+}
+
+// PostgresDefinitionStore keeps definitions in the saga_definitions table,
+// so new versions can be published without redeploying the orchestrator
+type PostgresDefinitionStore struct {
+	DB            db.DB
+	KnownServices []string
+}
+
+func NewPostgresDefinitionStore(database db.DB, knownServices ...string) *PostgresDefinitionStore {
+	return &PostgresDefinitionStore{DB: database, KnownServices: knownServices}
+}
+
+// InitSchema creates the definitions table
+func (s *PostgresDefinitionStore) InitSchema(ctx context.Context, tx db.Tx) error {
+	return tx.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS saga_definitions (
+			name TEXT NOT NULL,
+			version INT NOT NULL,
+			definition JSONB NOT NULL,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+			PRIMARY KEY (name, version)
+		)
+	`)
+}
+
+// Save validates and stores a new definition version. Existing versions
+// are never overwritten, and a version may not start itself through its
+// sub-sagas.
+func (s *PostgresDefinitionStore) Save(ctx context.Context, tx db.Tx, def Saga) error {
+	if err := def.Validate(s.KnownServices...); err != nil {
+		return err
+	}
+	err := checkSubSagaCycles(def, func(name string, version int) (Saga, error) {
+		if version == 0 {
+			return s.Latest(ctx, name)
+		}
+		return s.Get(ctx, name, version)
+	})
+	if err != nil {
+		return err
+	}
+
+	data, err := MarshalDefinition(def)
+	if err != nil {
+		return err
+	}
+
+	return tx.Exec(ctx, `
+		INSERT INTO saga_definitions (name, version, definition)
+		VALUES ($1, $2, $3)
+	`, def.Name, def.Version, data)
+}
+
+func (s *PostgresDefinitionStore) Get(ctx context.Context, name string, version int) (Saga, error) {
+	return s.query(ctx, `
+		SELECT definition FROM saga_definitions
+		WHERE name = $1 AND version = $2
+	`, name, version)
+}
+
+func (s *PostgresDefinitionStore) Latest(ctx context.Context, name string) (Saga, error) {
+	return s.query(ctx, `
+		SELECT definition FROM saga_definitions
+		WHERE name = $1
+		ORDER BY version DESC
+		LIMIT 1
+	`, name)
+}
+
+func (s *PostgresDefinitionStore) query(ctx context.Context, query string, args ...any) (Saga, error) {
+	tx, err := s.DB.BeginTx(ctx)
+	if err != nil {
+		return Saga{}, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(ctx, query, args...)
+	if err != nil {
+		return Saga{}, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return Saga{}, fmt.Errorf("%w: %v", ErrDefinitionNotFound, args)
+	}
+
+	var data []byte
+	if err := rows.Scan(&data); err != nil {
+		return Saga{}, err
+	}
+	return ParseDefinition(data)
+}
@@ -0,0 +1,85 @@
+package sagaflow
+
+import (
+	"errors"
+	"testing"
+)
+
+func subSagaDef(name string, version int, children ...SubSagaSpec) Saga {
+	s := Saga{Name: name, Version: version}
+	for i, child := range children {
+		child := child
+		s.Steps = append(s.Steps, Step{ID: name + "-child-" + string(rune('a'+i)), SubSaga: &child})
+	}
+	if len(s.Steps) == 0 {
+		s.Steps = []Step{{ID: name + "-step", Service: "svc", Command: "do", NoCompensate: true}}
+	}
+	return s
+}
+
+func TestRegisterRejectsSubSagaCycles(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing []Saga
+		def      Saga
+		wantErr  error
+	}{
+		{
+			name: "self reference",
+			def:  subSagaDef("a", 1, SubSagaSpec{Name: "a"}),
+			// version 0 resolves to the definition being registered
+			wantErr: ErrSubSagaCycle,
+		},
+		{
+			name:     "two sagas",
+			existing: []Saga{subSagaDef("b", 1, SubSagaSpec{Name: "a"})},
+			def:      subSagaDef("a", 1, SubSagaSpec{Name: "b", Version: 1}),
+			wantErr:  ErrSubSagaCycle,
+		},
+		{
+			name: "through a group member",
+			existing: []Saga{
+				subSagaDef("c", 1, SubSagaSpec{Name: "a", Version: 1}),
+				subSagaDef("b", 1, SubSagaSpec{Name: "c"}),
+			},
+			def: Saga{Name: "a", Version: 1, Steps: []Step{{
+				ID:       "group",
+				Parallel: []Step{{ID: "member", SubSaga: &SubSagaSpec{Name: "b"}}},
+			}}},
+			wantErr: ErrSubSagaCycle,
+		},
+		{
+			name:     "pinned to an older version",
+			existing: []Saga{subSagaDef("a", 1), subSagaDef("b", 1, SubSagaSpec{Name: "a", Version: 1})},
+			def:      subSagaDef("a", 2, SubSagaSpec{Name: "b"}),
+		},
+		{
+			name:     "shared child",
+			existing: []Saga{subSagaDef("c", 1), subSagaDef("b", 1, SubSagaSpec{Name: "c"})},
+			def:      subSagaDef("a", 1, SubSagaSpec{Name: "b"}, SubSagaSpec{Name: "c"}),
+		},
+		{
+			name: "unregistered child",
+			def:  subSagaDef("a", 1, SubSagaSpec{Name: "missing"}),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defs := NewMemoryDefinitions()
+			for _, s := range tt.existing {
+				if err := defs.Register(s); err != nil {
+					t.Fatalf("register %s v%d: %v", s.Name, s.Version, err)
+				}
+			}
+
+			err := defs.Register(tt.def)
+			if tt.wantErr == nil && err != nil {
+				t.Fatalf("Register() = %v, want nil", err)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Register() = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
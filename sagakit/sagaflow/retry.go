@@ -1,25 +1,85 @@
 package sagaflow
 
 import (
-	"context"
 	"fmt"
-	"shared/sagakit"
+	"math"
+	"math/rand/v2"
+	"slices"
 	"time"
 )
 
-func RetryStep(ctx context.Context, s Saga, stepIndex int, attempt int) error {
-	st := s.Steps[stepIndex]
-	if attempt > st.MaxRetries {
-		return fmt.Errorf("max retries exceeded")
-	}
-	time.Sleep(time.Duration(attempt) * time.Second)
-	msg := map[string]any{
-		"saga_id":    s.SagaID,
-		"step_id":    st.ID,
-		"step_index": stepIndex,
-		"command":    st.Command,
-		"payload":    st.Payload,
-	}
-	topic := fmt.Sprintf("saga.command.%s", st.Service)
-	return sagakit.Publish(ctx, topic, msg)
+// ErrClassTimeout is the error class of steps failed by the Sweeper
+const ErrClassTimeout = "timeout"
+
+const (
+	defaultInitialBackoff = time.Second
+	defaultMaxBackoff     = 5 * time.Minute
+	defaultMultiplier     = 2.0
+)
+
+// RetryPolicy controls how often a failed step is re-sent before the saga
+// starts compensating. It is stored with each StepExecution so that a
+// running saga keeps the policy it was started with.
+type RetryPolicy struct {
+	// MaxAttempts counts the first attempt; 1 or less disables retries
+	MaxAttempts    int           `bson:"max_attempts" json:"max_attempts"`
+	InitialBackoff time.Duration `bson:"initial_backoff" json:"initial_backoff"`
+	MaxBackoff     time.Duration `bson:"max_backoff" json:"max_backoff"`
+	Multiplier     float64       `bson:"multiplier" json:"multiplier"`
+	// Jitter randomises each delay by ± the given fraction (0..1)
+	Jitter float64 `bson:"jitter" json:"jitter"`
+	// RetryableErrors lists the error classes worth retrying; empty means all
+	RetryableErrors []string `bson:"retryable_errors,omitempty" json:"retryable_errors,omitempty"`
+}
+
+// ShouldRetry reports whether another attempt is allowed after attempts
+// have already been made and the last one failed with errClass
+func (p RetryPolicy) ShouldRetry(attempts int, errClass string) bool {
+	if attempts >= p.MaxAttempts {
+		return false
+	}
+	if len(p.RetryableErrors) == 0 {
+		return true
+	}
+	return slices.Contains(p.RetryableErrors, errClass)
+}
+
+// Backoff returns the delay before the attempt following attempt
+func (p RetryPolicy) Backoff(attempt int) time.Duration {
+	initial := p.InitialBackoff
+	if initial <= 0 {
+		initial = defaultInitialBackoff
+	}
+	maxBackoff := p.MaxBackoff
+	if maxBackoff <= 0 {
+		maxBackoff = defaultMaxBackoff
+	}
+	multiplier := p.Multiplier
+	if multiplier < 1 {
+		multiplier = defaultMultiplier
+	}
+
+	d := float64(initial) * math.Pow(multiplier, float64(max(attempt-1, 0)))
+	if d > float64(maxBackoff) {
+		d = float64(maxBackoff)
+	}
+	// Definitions built in Go skip Validate, so keep the delay positive
+	if jitter := min(p.Jitter, 1); jitter > 0 {
+		d += d * jitter * (2*rand.Float64() - 1)
+	}
+	return time.Duration(d)
+}
+
+// StepError is the failure reported by a participant. Class is matched
+// against RetryPolicy.RetryableErrors.
+type StepError struct {
+	Class   string `json:"error_class,omitempty"`
+	Message string `json:"error"`
+}
+
+func (e StepError) Error() string {
+	if e.Class == "" {
+		return e.Message
+	}
+	return fmt.Sprintf("%s: %s", e.Class, e.Message)
 }
@@ -0,0 +1,65 @@
+package sagaflow
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRetryPolicyShouldRetry(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   RetryPolicy
+		attempts int
+		errClass string
+		want     bool
+	}{
+		{name: "no policy", policy: RetryPolicy{}, attempts: 1, want: false},
+		{name: "single attempt", policy: RetryPolicy{MaxAttempts: 1}, attempts: 1, want: false},
+		{name: "attempts left", policy: RetryPolicy{MaxAttempts: 3}, attempts: 2, want: true},
+		{name: "attempts used up", policy: RetryPolicy{MaxAttempts: 3}, attempts: 3, want: false},
+		{name: "retryable class", policy: RetryPolicy{MaxAttempts: 3, RetryableErrors: []string{"timeout", "unavailable"}}, attempts: 1, errClass: "unavailable", want: true},
+		{name: "other class", policy: RetryPolicy{MaxAttempts: 3, RetryableErrors: []string{"timeout"}}, attempts: 1, errClass: "validation", want: false},
+		{name: "unclassified error with a class list", policy: RetryPolicy{MaxAttempts: 3, RetryableErrors: []string{"timeout"}}, attempts: 1, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.ShouldRetry(tt.attempts, tt.errClass); got != tt.want {
+				t.Errorf("ShouldRetry(%d, %q) = %v, want %v", tt.attempts, tt.errClass, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  RetryPolicy
+		attempt int
+		min     time.Duration
+		max     time.Duration
+	}{
+		{name: "defaults", policy: RetryPolicy{}, attempt: 1, min: time.Second, max: time.Second},
+		{name: "defaults double", policy: RetryPolicy{}, attempt: 3, min: 4 * time.Second, max: 4 * time.Second},
+		{name: "attempt 0 is the first", policy: RetryPolicy{InitialBackoff: time.Second}, attempt: 0, min: time.Second, max: time.Second},
+		{name: "multiplier", policy: RetryPolicy{InitialBackoff: 100 * time.Millisecond, Multiplier: 3}, attempt: 3, min: 900 * time.Millisecond, max: 900 * time.Millisecond},
+		{name: "multiplier below 1 uses the default", policy: RetryPolicy{InitialBackoff: time.Second, Multiplier: 0.5}, attempt: 2, min: 2 * time.Second, max: 2 * time.Second},
+		{name: "capped", policy: RetryPolicy{InitialBackoff: time.Second, MaxBackoff: 10 * time.Second}, attempt: 10, min: 10 * time.Second, max: 10 * time.Second},
+		{name: "default cap", policy: RetryPolicy{}, attempt: 100, min: defaultMaxBackoff, max: defaultMaxBackoff},
+		{name: "jitter", policy: RetryPolicy{InitialBackoff: 10 * time.Second, Jitter: 0.2}, attempt: 1, min: 8 * time.Second, max: 12 * time.Second},
+		{name: "jitter above 1 is clamped", policy: RetryPolicy{InitialBackoff: 10 * time.Second, Jitter: 5}, attempt: 1, min: 0, max: 20 * time.Second},
+		{name: "negative jitter is ignored", policy: RetryPolicy{InitialBackoff: 10 * time.Second, Jitter: -1}, attempt: 1, min: 10 * time.Second, max: 10 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Jitter is random, so sample it a few times
+			for range 50 {
+				got := tt.policy.Backoff(tt.attempt)
+				if got < tt.min || got > tt.max {
+					t.Fatalf("Backoff(%d) = %s, want between %s and %s", tt.attempt, got, tt.min, tt.max)
+				}
+			}
+		})
+	}
+}
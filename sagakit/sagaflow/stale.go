@@ -0,0 +1,122 @@
+package sagaflow
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+
+	"shared/sagakit"
+
+	"github.com/ThreeDotsLabs/watermill"
+)
+
+// replyKind tells step replies from compensation replies
+type replyKind string
+
+const (
+	replyStep         replyKind = "step"
+	replyCompensation replyKind = "compensation"
+)
+
+// EventMetrics counts participant replies handled and dropped as stale
+type EventMetrics struct {
+	handled                  atomic.Int64
+	droppedStaleSteps        atomic.Int64
+	droppedStaleCompensation atomic.Int64
+}
+
+// EventStats is a point-in-time copy of EventMetrics
+type EventStats struct {
+	Handled                   int64 `json:"handled"`
+	DroppedStaleSteps         int64 `json:"dropped_stale_steps"`
+	DroppedStaleCompensations int64 `json:"dropped_stale_compensations"`
+}
+
+func (m *EventMetrics) Snapshot() EventStats {
+	return EventStats{
+		Handled:                   m.handled.Load(),
+		DroppedStaleSteps:         m.droppedStaleSteps.Load(),
+		DroppedStaleCompensations: m.droppedStaleCompensation.Load(),
+	}
+}
+
+func (m *EventMetrics) record(kind replyKind, stale bool) {
+	if m == nil {
+		return
+	}
+	switch {
+	case !stale:
+		m.handled.Add(1)
+	case kind == replyCompensation:
+		m.droppedStaleCompensation.Add(1)
+	default:
+		m.droppedStaleSteps.Add(1)
+	}
+}
+
+// handleReply locks the saga and applies fn to it, unless the reply no
+// longer matches the step it is for: a duplicate, a reply to an earlier
+// attempt, or one that arrives after the saga moved on. Stale replies are
+// dropped and counted. attempt 0 skips the attempt check.
+func (o *Orchestrator) handleReply(ctx context.Context, kind replyKind, sagaID string, stepIndex, attempt int, fn func(uow sagakit.UnitOfWork, exec *SagaExecution) error) error {
+	var reason string
+	err := o.runInTx(ctx, func(uow sagakit.UnitOfWork) error {
+		exec, err := o.StateStore.LockExecution(ctx, uow.Tx(), sagaID)
+		if err != nil {
+			return err
+		}
+
+		if reason = exec.staleReply(kind, stepIndex, attempt); reason != "" {
+			return nil
+		}
+		return fn(uow, exec)
+	})
+	if err != nil {
+		return err
+	}
+
+	o.Metrics.record(kind, reason != "")
+	if reason != "" {
+		sagakit.GetLogger().Info("dropped stale saga reply", watermill.LogFields{
+			"saga_id":    sagaID,
+			"step_index": stepIndex,
+			"attempt":    attempt,
+			"kind":       string(kind),
+			"reason":     reason,
+		})
+	}
+	return nil
+}
+
+// staleReply returns why a reply for the step at stepIndex does not apply
+// to the execution as it stands, or "" when it does
+func (e *SagaExecution) staleReply(kind replyKind, stepIndex, attempt int) string {
+	if stepIndex < 0 || stepIndex >= len(e.Steps) {
+		return fmt.Sprintf("no step %d", stepIndex)
+	}
+	step := e.Steps[stepIndex]
+
+	if kind == replyCompensation {
+		switch {
+		case e.State != StateCompensating:
+			return fmt.Sprintf("saga is %s", e.State)
+		case step.State != StepCompensating:
+			return fmt.Sprintf("step is %s", step.State)
+		case attempt > 0 && attempt != step.CompensationAttempts:
+			return fmt.Sprintf("compensation attempt %d, expected %d", attempt, step.CompensationAttempts)
+		}
+		return ""
+	}
+
+	switch {
+	case e.State != StateInProgress && e.State != StateCompensating:
+		return fmt.Sprintf("saga is %s", e.State)
+	case step.Wait != nil:
+		return "wait steps complete through events or signals"
+	case step.State != StepInProgress:
+		return fmt.Sprintf("step is %s", step.State)
+	case attempt > 0 && attempt != step.Attempts:
+		return fmt.Sprintf("attempt %d, expected %d", attempt, step.Attempts)
+	}
+	return ""
+}
@@ -0,0 +1,81 @@
+package sagaflow
+
+import (
+	"context"
+	"testing"
+)
+
+func TestStaleReply(t *testing.T) {
+	waiting := execution(StateInProgress, StepCompleted, StepInProgress)
+	waiting.Steps[1].Wait = &WaitSpec{Event: "paid"}
+	retried := execution(StateInProgress, StepInProgress)
+	retried.Steps[0].Attempts = 2
+	compensating := execution(StateCompensating, StepCompensating, StepFailed)
+	compensating.Steps[0].CompensationAttempts = 3
+
+	tests := []struct {
+		name      string
+		exec      *SagaExecution
+		kind      replyKind
+		step      int
+		attempt   int
+		wantStale bool
+	}{
+		{name: "current attempt", exec: retried, kind: replyStep, attempt: 2},
+		{name: "attempt unknown", exec: retried, kind: replyStep, attempt: 0},
+		{name: "earlier attempt", exec: retried, kind: replyStep, attempt: 1, wantStale: true},
+		{name: "no such step", exec: retried, kind: replyStep, step: 5, attempt: 2, wantStale: true},
+		{name: "negative step", exec: retried, kind: replyStep, step: -1, wantStale: true},
+		{name: "duplicate reply", exec: execution(StateInProgress, StepCompleted, StepInProgress), kind: replyStep, attempt: 1, wantStale: true},
+		{name: "saga finished", exec: execution(StateCompleted, StepInProgress), kind: replyStep, attempt: 1, wantStale: true},
+		{name: "late group member", exec: grouped(execution(StateCompensating, StepFailed, StepInProgress), 0, 1), kind: replyStep, step: 1, attempt: 1},
+		{name: "wait step", exec: waiting, kind: replyStep, step: 1, attempt: 1, wantStale: true},
+		{name: "current compensation", exec: compensating, kind: replyCompensation, attempt: 3},
+		{name: "earlier compensation", exec: compensating, kind: replyCompensation, attempt: 2, wantStale: true},
+		{name: "compensation of a step not compensating", exec: compensating, kind: replyCompensation, step: 1, wantStale: true},
+		{name: "compensation after an abort", exec: execution(StateAborted, StepCompensating), kind: replyCompensation, attempt: 1, wantStale: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reason := tt.exec.staleReply(tt.kind, tt.step, tt.attempt)
+			if (reason != "") != tt.wantStale {
+				t.Errorf("staleReply(%s, %d, %d) = %q, want stale %v", tt.kind, tt.step, tt.attempt, reason, tt.wantStale)
+			}
+		})
+	}
+}
+
+func TestEventMetricsRecord(t *testing.T) {
+	m := &EventMetrics{}
+	m.record(replyStep, false)
+	m.record(replyCompensation, false)
+	m.record(replyStep, true)
+	m.record(replyCompensation, true)
+	m.record(replyCompensation, true)
+
+	want := EventStats{Handled: 2, DroppedStaleSteps: 1, DroppedStaleCompensations: 2}
+	if got := m.Snapshot(); got != want {
+		t.Errorf("Snapshot() = %+v, want %+v", got, want)
+	}
+
+	var none *EventMetrics
+	none.record(replyStep, false) // must not panic
+}
+
+func TestCurrentReplyIsHandled(t *testing.T) {
+	o, _ := newMemOrchestrator()
+	exec := execution(StateInProgress, StepInProgress)
+	exec.Steps[0].Attempts = 2
+	saveExecution(t, o, exec)
+
+	if err := o.handleStepSuccess(context.Background(), "saga-1", 0, 2, map[string]interface{}{"id": "x"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := loadExecution(t, o, "saga-1"); got.State != StateCompleted {
+		t.Errorf("saga %s, want %s", got.State, StateCompleted)
+	}
+	if got := o.Metrics.Snapshot(); got.Handled != 1 {
+		t.Errorf("metrics = %+v, want one reply handled", got)
+	}
+}
@@ -0,0 +1,41 @@
+package sagaflow
+
+import "errors"
+
+// ErrDuplicateSaga is returned by StartSaga under DuplicateReject when an
+// active saga already holds the business key
+var ErrDuplicateSaga = errors.New("an active saga already holds this business key")
+
+// DuplicatePolicy decides what StartSaga does when an active saga already
+// holds the requested business key
+type DuplicatePolicy int
+
+const (
+	// DuplicateReturnExisting returns the ID of the running saga
+	DuplicateReturnExisting DuplicatePolicy = iota
+	// DuplicateReject returns the ID of the running saga with ErrDuplicateSaga
+	DuplicateReject
+)
+
+// StartOption configures StartSaga
+type StartOption func(*startOptions)
+
+type startOptions struct {
+	businessKey string
+	duplicate   DuplicatePolicy
+}
+
+// WithBusinessKey makes the saga unique among active sagas by key, for
+// example "license:<code>"
+func WithBusinessKey(key string) StartOption {
+	return func(o *startOptions) {
+		o.businessKey = key
+	}
+}
+
+// OnDuplicate sets the policy applied when the business key is taken
+func OnDuplicate(policy DuplicatePolicy) StartOption {
+	return func(o *startOptions) {
+		o.duplicate = policy
+	}
+}
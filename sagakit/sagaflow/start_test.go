@@ -0,0 +1,124 @@
+package sagaflow
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"shared/sagakit/db"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var licenseSaga = Saga{Name: "license", Version: 1, Steps: []Step{
+	{ID: "issue", Service: "svc", Command: "issue", Compensate: "revoke"},
+}}
+
+func TestStartSagaWithBusinessKey(t *testing.T) {
+	ctx := context.Background()
+	o, database := newMemOrchestrator()
+
+	first, err := o.StartSaga(ctx, licenseSaga, nil, WithBusinessKey("license:A"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	again, err := o.StartSaga(ctx, licenseSaga, nil, WithBusinessKey("license:A"))
+	if err != nil || again != first {
+		t.Errorf("second start = %q, %v; want the running saga %q", again, err, first)
+	}
+
+	rejected, err := o.StartSaga(ctx, licenseSaga, nil, WithBusinessKey("license:A"), OnDuplicate(DuplicateReject))
+	if !errors.Is(err, ErrDuplicateSaga) || rejected != first {
+		t.Errorf("rejected start = %q, %v; want %q with %v", rejected, err, first, ErrDuplicateSaga)
+	}
+
+	other, err := o.StartSaga(ctx, licenseSaga, nil, WithBusinessKey("license:B"))
+	if err != nil || other == first {
+		t.Errorf("start with another key = %q, %v; want a new saga", other, err)
+	}
+	if n := len(database.published(CommandTopic("svc"))); n != 2 {
+		t.Errorf("sent %d commands, want one per saga started", n)
+	}
+
+	// The key is free again once the saga holding it has finished
+	if err := o.HandleStepSuccess(ctx, first, 0, nil); err != nil {
+		t.Fatal(err)
+	}
+	next, err := o.StartSaga(ctx, licenseSaga, nil, WithBusinessKey("license:A"), OnDuplicate(DuplicateReject))
+	if err != nil || next == first {
+		t.Errorf("start after completion = %q, %v; want a new saga", next, err)
+	}
+}
+
+// conflictingStore fails the business key lookup with err the first
+// conflicts times, as a Mongo start racing another start does
+type conflictingStore struct {
+	memStore
+	err       error
+	conflicts *int
+}
+
+func (s conflictingStore) FindActiveByBusinessKey(ctx context.Context, tx db.Tx, key string) (string, error) {
+	if *s.conflicts > 0 {
+		*s.conflicts--
+		return "", s.err
+	}
+	return s.memStore.FindActiveByBusinessKey(ctx, tx, key)
+}
+
+func TestStartSagaRetriesConflicts(t *testing.T) {
+	writeConflict := mongo.CommandError{Code: 112, Message: "WriteConflict"}
+
+	tests := []struct {
+		name      string
+		err       error
+		conflicts int
+		wantErr   bool
+	}{
+		{name: "conflict then success", err: writeConflict, conflicts: 2},
+		{name: "conflicts past the retries", err: writeConflict, conflicts: startConflictRetries + 1, wantErr: true},
+		{name: "other errors are not retried", err: errors.New("connection reset"), conflicts: 1, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o, _ := newMemOrchestrator()
+			conflicts := tt.conflicts
+			o.StateStore = conflictingStore{err: tt.err, conflicts: &conflicts}
+
+			sagaID, err := o.StartSaga(context.Background(), licenseSaga, nil, WithBusinessKey("license:A"))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("StartSaga() = %q, %v; want error %v", sagaID, err, tt.wantErr)
+			}
+			if !tt.wantErr && conflicts != 0 {
+				t.Errorf("%d conflicts left, want every one retried", conflicts)
+			}
+		})
+	}
+}
+
+func TestIsMongoConflict(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "duplicate key", err: mongo.WriteException{WriteErrors: []mongo.WriteError{{Code: 11000}}}, want: true},
+		{name: "write conflict", err: mongo.CommandError{Code: 112}, want: true},
+		{name: "transient transaction error", err: mongo.CommandError{Code: 251, Labels: []string{"TransientTransactionError"}}, want: true},
+		{name: "wrapped", err: fmt.Errorf("start saga: %w", mongo.CommandError{Code: 112}), want: true},
+		{name: "other server error", err: mongo.CommandError{Code: 13}, want: false},
+		{name: "plain error", err: errors.New("boom"), want: false},
+		{name: "nil", err: nil, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isMongoConflict(tt.err); got != tt.want {
+				t.Errorf("isMongoConflict(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
@@ -3,7 +3,9 @@ package sagaflow
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"shared/pkgs/evaluate"
 	"shared/sagakit/db"
 	"time"
 )
@@ -18,8 +20,13 @@ const (
 	StateFailed       SagaState = "FAILED"
 	StateCompensating SagaState = "COMPENSATING"
 	StateCompensated  SagaState = "COMPENSATED"
+	// StateAborted is set by an operator; the saga is left as it is
+	StateAborted SagaState = "ABORTED"
 )
 
+// ErrSagaNotFound is returned when no execution has the requested ID
+var ErrSagaNotFound = errors.New("saga not found")
+
 // StepState represents the state of individual saga steps
 type StepState string
 
@@ -30,42 +37,117 @@ const (
 	StepFailed       StepState = "FAILED"
 	StepCompensating StepState = "COMPENSATING"
 	StepCompensated  StepState = "COMPENSATED"
+	// StepCompensationFailed marks steps whose compensation gave up after
+	// its retries; the saga ends FAILED and needs manual intervention
+	StepCompensationFailed StepState = "COMPENSATION_FAILED"
+	// StepSkipped marks steps whose guard or branch case did not match
+	StepSkipped StepState = "SKIPPED"
 )
 
 // SagaExecution tracks the execution of a saga
 type SagaExecution struct {
-	SagaID       string                 `json:"saga_id"`
-	SagaName     string                 `json:"saga_name"`
-	State        SagaState              `json:"state"`
-	CurrentStep  int                    `json:"current_step"`
-	Steps        []StepExecution        `json:"steps"`
-	Context      map[string]interface{} `json:"context"` // Shared data between steps
-	ErrorMessage string                 `json:"error_message,omitempty"`
-	CreatedAt    time.Time              `json:"created_at"`
-	UpdatedAt    time.Time              `json:"updated_at"`
+	SagaID   string `bson:"_id" json:"saga_id"`
+	SagaName string `bson:"saga_name" json:"saga_name"`
+	// BusinessKey, when set, is unique among active executions
+	BusinessKey string `bson:"business_key,omitempty" json:"business_key,omitempty"`
+	// DefinitionVersion is the version of the definition the saga started with
+	DefinitionVersion int                    `bson:"definition_version" json:"definition_version"`
+	State             SagaState              `bson:"state" json:"state"`
+	CurrentStep       int                    `bson:"current_step" json:"current_step"`
+	Steps             []StepExecution        `bson:"steps" json:"steps"`
+	Context           map[string]interface{} `bson:"context" json:"context"` // Shared data between steps
+	ErrorMessage      string                 `bson:"error_message,omitempty" json:"error_message,omitempty"`
+	// DeadlineAt bounds the whole saga; nil means no saga timeout
+	DeadlineAt *time.Time `bson:"deadline_at,omitempty" json:"deadline_at,omitempty"`
+	// Parent links a sub-saga to the step of the saga that started it
+	Parent    *ParentRef `bson:"parent,omitempty" json:"parent,omitempty"`
+	CreatedAt time.Time  `bson:"created_at" json:"created_at"`
+	UpdatedAt time.Time  `bson:"updated_at" json:"updated_at"`
 }
 
 // StepExecution tracks individual step execution
 type StepExecution struct {
-	StepID       string                 `json:"step_id"`
-	StepIndex    int                    `json:"step_index"`
-	State        StepState              `json:"state"`
-	Service      string                 `json:"service"`
-	Command      string                 `json:"command"`
-	Input        map[string]interface{} `json:"input"`
-	Output       map[string]interface{} `json:"output,omitempty"`
-	ErrorMessage string                 `json:"error_message,omitempty"`
-	Attempts     int                    `json:"attempts"`
-	StartedAt    *time.Time             `json:"started_at,omitempty"`
-	CompletedAt  *time.Time             `json:"completed_at,omitempty"`
+	StepID       string                 `bson:"step_id" json:"step_id"`
+	StepIndex    int                    `bson:"step_index" json:"step_index"`
+	State        StepState              `bson:"state" json:"state"`
+	Service      string                 `bson:"service" json:"service"`
+	Command      string                 `bson:"command" json:"command"`
+	Compensate   string                 `bson:"compensate,omitempty" json:"compensate,omitempty"`
+	Input        map[string]interface{} `bson:"input" json:"input"`
+	Output       map[string]interface{} `bson:"output,omitempty" json:"output,omitempty"`
+	ErrorMessage string                 `bson:"error_message,omitempty" json:"error_message,omitempty"`
+	Attempts     int                    `bson:"attempts" json:"attempts"`
+	// CompensationAttempts counts the compensation commands sent so far
+	CompensationAttempts int `bson:"compensation_attempts,omitempty" json:"compensation_attempts,omitempty"`
+	// GroupID and Join are set on the members of a parallel step group
+	GroupID string     `bson:"group_id,omitempty" json:"group_id,omitempty"`
+	Join    JoinPolicy `bson:"join,omitempty" json:"join,omitempty"`
+	// Condition guards the step; Branch is set on steps of a branch case
+	Condition   *evaluate.ConditionNode `bson:"condition,omitempty" json:"condition,omitempty"`
+	Branch      *BranchMembership       `bson:"branch,omitempty" json:"branch,omitempty"`
+	RetryPolicy *RetryPolicy            `bson:"retry_policy,omitempty" json:"retry_policy,omitempty"`
+	// NextAttemptAt is set while a retry is waiting in the outbox
+	NextAttemptAt *time.Time `bson:"next_attempt_at,omitempty" json:"next_attempt_at,omitempty"`
+	// Wait is set on wait steps; WaitKey is the context value the awaited
+	// event must carry, resolved when the wait starts
+	Wait    *WaitSpec `bson:"wait,omitempty" json:"wait,omitempty"`
+	WaitKey string    `bson:"wait_key,omitempty" json:"wait_key,omitempty"`
+	// SubSaga is set on sub-saga steps; ChildSagaID is the child started
+	// by the current attempt
+	SubSaga     *SubSagaSpec `bson:"sub_saga,omitempty" json:"sub_saga,omitempty"`
+	ChildSagaID string       `bson:"child_saga_id,omitempty" json:"child_saga_id,omitempty"`
+	// Timeout bounds each attempt; DeadlineAt is set while an attempt is in flight
+	Timeout     time.Duration `bson:"timeout,omitempty" json:"timeout,omitempty"`
+	DeadlineAt  *time.Time    `bson:"deadline_at,omitempty" json:"deadline_at,omitempty"`
+	StartedAt   *time.Time    `bson:"started_at,omitempty" json:"started_at,omitempty"`
+	CompletedAt *time.Time    `bson:"completed_at,omitempty" json:"completed_at,omitempty"`
 }
 
 // StateStore handles saga state persistence
 type StateStore interface {
 	SaveExecution(ctx context.Context, tx db.Tx, exec *SagaExecution) error
 	GetExecution(ctx context.Context, tx db.Tx, sagaID string) (*SagaExecution, error)
+	// LockExecution loads an execution like GetExecution and holds a row
+	// lock on it until tx ends, serialising concurrent events for one saga
+	LockExecution(ctx context.Context, tx db.Tx, sagaID string) (*SagaExecution, error)
 	UpdateStepState(ctx context.Context, tx db.Tx, sagaID string, stepIndex int, state StepState, output map[string]interface{}, errMsg string) error
 	UpdateSagaState(ctx context.Context, tx db.Tx, sagaID string, state SagaState, currentStep int, errMsg string) error
+	// UpdateSagaContext persists the context shared between steps
+	UpdateSagaContext(ctx context.Context, tx db.Tx, sagaID string, sagaContext map[string]interface{}) error
+	// SaveStep persists every mutable field of a single step execution
+	SaveStep(ctx context.Context, tx db.Tx, sagaID string, step *StepExecution) error
+	// ClaimExpiredSteps locks the sagas of in-progress or compensating steps
+	// whose deadline passed before now, skipping sagas already locked elsewhere
+	ClaimExpiredSteps(ctx context.Context, tx db.Tx, now time.Time, limit int) ([]StepRef, error)
+	// ClaimExpiredSagas locks in-progress sagas whose deadline passed before now
+	ClaimExpiredSagas(ctx context.Context, tx db.Tx, now time.Time, limit int) ([]string, error)
+	// CountStuckSagas counts in-progress sagas with an expired saga or step
+	// deadline and compensating sagas with an expired compensation
+	CountStuckSagas(ctx context.Context, tx db.Tx, now time.Time) (int, error)
+	// ListExecutions returns executions matching filter, newest first,
+	// without their steps
+	ListExecutions(ctx context.Context, tx db.Tx, filter ExecutionFilter) ([]SagaExecution, error)
+	// RecordAdminAction appends an entry to the admin audit log
+	RecordAdminAction(ctx context.Context, tx db.Tx, action *AdminAction) error
+	// FindActiveByBusinessKey returns the ID of the active execution holding
+	// key, or "" when there is none. Concurrent callers with the same key are
+	// serialised until tx ends, so a check followed by SaveExecution is safe.
+	FindActiveByBusinessKey(ctx context.Context, tx db.Tx, key string) (string, error)
+	// ListAdminActions returns the audit log of a saga, oldest first
+	ListAdminActions(ctx context.Context, tx db.Tx, sagaID string) ([]AdminAction, error)
+	// AppendEvent adds an entry to the execution journal
+	AppendEvent(ctx context.Context, tx db.Tx, event *SagaEvent) error
+	// ListEvents returns the journal of a saga in the order it was written
+	ListEvents(ctx context.Context, tx db.Tx, sagaID string) ([]SagaEvent, error)
+	// ClaimWaitingSteps finds the wait steps waiting for event whose key
+	// matches the event payload and locks their sagas
+	ClaimWaitingSteps(ctx context.Context, tx db.Tx, event string, payload map[string]interface{}) ([]StepRef, error)
+}
+
+// StepRef identifies a single step of a saga execution
+type StepRef struct {
+	SagaID    string
+	StepIndex int
 }
 
 // PostgresStateStore implements StateStore for PostgreSQL
@@ -81,10 +163,14 @@ func (s *PostgresStateStore) InitSchema(ctx context.Context, tx db.Tx) error {
 		CREATE TABLE IF NOT EXISTS saga_executions (
 			saga_id TEXT PRIMARY KEY,
 			saga_name TEXT NOT NULL,
+			business_key TEXT,
+			definition_version INT NOT NULL DEFAULT 0,
 			state TEXT NOT NULL,
 			current_step INT NOT NULL DEFAULT 0,
 			context JSONB,
 			error_message TEXT,
+			deadline_at TIMESTAMPTZ,
+			parent JSONB,
 			created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
 			updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
 		);
@@ -96,17 +182,85 @@ func (s *PostgresStateStore) InitSchema(ctx context.Context, tx db.Tx) error {
 			state TEXT NOT NULL,
 			service TEXT NOT NULL,
 			command TEXT NOT NULL,
+			compensate TEXT NOT NULL DEFAULT '',
 			input JSONB,
 			output JSONB,
 			error_message TEXT,
 			attempts INT NOT NULL DEFAULT 0,
+			compensation_attempts INT NOT NULL DEFAULT 0,
+			group_id TEXT,
+			join_policy TEXT,
+			condition JSONB,
+			branch JSONB,
+			retry_policy JSONB,
+			wait JSONB,
+			wait_key TEXT,
+			sub_saga JSONB,
+			child_saga_id TEXT,
+			next_attempt_at TIMESTAMPTZ,
+			timeout_ms BIGINT NOT NULL DEFAULT 0,
+			deadline_at TIMESTAMPTZ,
 			started_at TIMESTAMPTZ,
 			completed_at TIMESTAMPTZ,
 			PRIMARY KEY (saga_id, step_index)
 		);
 
+		ALTER TABLE saga_step_executions ADD COLUMN IF NOT EXISTS retry_policy JSONB;
+		ALTER TABLE saga_step_executions ADD COLUMN IF NOT EXISTS next_attempt_at TIMESTAMPTZ;
+		ALTER TABLE saga_step_executions ADD COLUMN IF NOT EXISTS timeout_ms BIGINT NOT NULL DEFAULT 0;
+		ALTER TABLE saga_step_executions ADD COLUMN IF NOT EXISTS deadline_at TIMESTAMPTZ;
+		ALTER TABLE saga_executions ADD COLUMN IF NOT EXISTS deadline_at TIMESTAMPTZ;
+		ALTER TABLE saga_executions ADD COLUMN IF NOT EXISTS definition_version INT NOT NULL DEFAULT 0;
+		ALTER TABLE saga_executions ADD COLUMN IF NOT EXISTS business_key TEXT;
+		ALTER TABLE saga_step_executions ADD COLUMN IF NOT EXISTS group_id TEXT;
+		ALTER TABLE saga_step_executions ADD COLUMN IF NOT EXISTS join_policy TEXT;
+		ALTER TABLE saga_step_executions ADD COLUMN IF NOT EXISTS condition JSONB;
+		ALTER TABLE saga_step_executions ADD COLUMN IF NOT EXISTS branch JSONB;
+		ALTER TABLE saga_step_executions ADD COLUMN IF NOT EXISTS compensate TEXT NOT NULL DEFAULT '';
+		ALTER TABLE saga_step_executions ADD COLUMN IF NOT EXISTS compensation_attempts INT NOT NULL DEFAULT 0;
+		ALTER TABLE saga_step_executions ADD COLUMN IF NOT EXISTS wait JSONB;
+		ALTER TABLE saga_step_executions ADD COLUMN IF NOT EXISTS wait_key TEXT;
+		ALTER TABLE saga_executions ADD COLUMN IF NOT EXISTS parent JSONB;
+		ALTER TABLE saga_step_executions ADD COLUMN IF NOT EXISTS sub_saga JSONB;
+		ALTER TABLE saga_step_executions ADD COLUMN IF NOT EXISTS child_saga_id TEXT;
+
+		CREATE TABLE IF NOT EXISTS saga_admin_actions (
+			id TEXT PRIMARY KEY,
+			saga_id TEXT NOT NULL,
+			actor TEXT NOT NULL,
+			action TEXT NOT NULL,
+			step_index INT,
+			reason TEXT,
+			payload JSONB,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		);
+
+		CREATE TABLE IF NOT EXISTS saga_events (
+			id BIGSERIAL PRIMARY KEY,
+			saga_id TEXT NOT NULL,
+			event_type TEXT NOT NULL,
+			step_index INT,
+			step_id TEXT,
+			service TEXT,
+			from_state TEXT,
+			to_state TEXT,
+			attempt INT NOT NULL DEFAULT 0,
+			payload JSONB,
+			error TEXT,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		);
+
 		CREATE INDEX IF NOT EXISTS idx_saga_executions_state ON saga_executions(state);
+		CREATE INDEX IF NOT EXISTS idx_saga_events_saga_id ON saga_events(saga_id, id);
+		CREATE INDEX IF NOT EXISTS idx_saga_executions_name_created ON saga_executions(saga_name, created_at);
+		CREATE UNIQUE INDEX IF NOT EXISTS idx_saga_executions_active_business_key ON saga_executions(business_key)
+			WHERE business_key IS NOT NULL AND state IN ('CREATED', 'IN_PROGRESS', 'COMPENSATING');
+		CREATE INDEX IF NOT EXISTS idx_saga_admin_actions_saga_id ON saga_admin_actions(saga_id, created_at);
 		CREATE INDEX IF NOT EXISTS idx_saga_step_executions_saga_id ON saga_step_executions(saga_id);
+		CREATE INDEX IF NOT EXISTS idx_saga_executions_deadline ON saga_executions(deadline_at) WHERE state = 'IN_PROGRESS';
+		CREATE INDEX IF NOT EXISTS idx_saga_step_executions_deadline ON saga_step_executions(deadline_at) WHERE state = 'IN_PROGRESS';
+		CREATE INDEX IF NOT EXISTS idx_saga_step_executions_compensation_deadline ON saga_step_executions(deadline_at) WHERE state = 'COMPENSATING';
+		CREATE INDEX IF NOT EXISTS idx_saga_step_executions_waiting ON saga_step_executions((wait ->> 'event'), wait_key) WHERE state = 'IN_PROGRESS' AND wait IS NOT NULL;
 	`
 	return tx.Exec(ctx, schema)
 }
@@ -116,39 +270,31 @@ func (s *PostgresStateStore) SaveExecution(ctx context.Context, tx db.Tx, exec *
 	if err != nil {
 		return err
 	}
+	parentJSON, err := json.Marshal(exec.Parent)
+	if err != nil {
+		return err
+	}
 
 	// Save saga execution
 	err = tx.Exec(ctx, `
-		INSERT INTO saga_executions (saga_id, saga_name, state, current_step, context, error_message, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		INSERT INTO saga_executions (saga_id, saga_name, business_key, definition_version, state, current_step, context, error_message, deadline_at, parent, created_at, updated_at)
+		VALUES ($1, $2, NULLIF($3, ''), $4, $5, $6, $7, $8, $9, $10, $11, $12)
 		ON CONFLICT (saga_id) DO UPDATE SET
 			state = EXCLUDED.state,
 			current_step = EXCLUDED.current_step,
 			context = EXCLUDED.context,
 			error_message = EXCLUDED.error_message,
+			deadline_at = EXCLUDED.deadline_at,
+			parent = EXCLUDED.parent,
 			updated_at = EXCLUDED.updated_at
-	`, exec.SagaID, exec.SagaName, exec.State, exec.CurrentStep, contextJSON, exec.ErrorMessage, exec.CreatedAt, exec.UpdatedAt)
+	`, exec.SagaID, exec.SagaName, exec.BusinessKey, exec.DefinitionVersion, exec.State, exec.CurrentStep, contextJSON, exec.ErrorMessage, exec.DeadlineAt, parentJSON, exec.CreatedAt, exec.UpdatedAt)
 	if err != nil {
 		return err
 	}
 
 	// Save step executions
-	for _, step := range exec.Steps {
-		inputJSON, _ := json.Marshal(step.Input)
-		outputJSON, _ := json.Marshal(step.Output)
-
-		err = tx.Exec(ctx, `
-			INSERT INTO saga_step_executions (saga_id, step_index, step_id, state, service, command, input, output, error_message, attempts, started_at, completed_at)
-			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
-			ON CONFLICT (saga_id, step_index) DO UPDATE SET
-				state = EXCLUDED.state,
-				output = EXCLUDED.output,
-				error_message = EXCLUDED.error_message,
-				attempts = EXCLUDED.attempts,
-				started_at = EXCLUDED.started_at,
-				completed_at = EXCLUDED.completed_at
-		`, exec.SagaID, step.StepIndex, step.StepID, step.State, step.Service, step.Command, inputJSON, outputJSON, step.ErrorMessage, step.Attempts, step.StartedAt, step.CompletedAt)
-		if err != nil {
+	for i := range exec.Steps {
+		if err := s.SaveStep(ctx, tx, exec.SagaID, &exec.Steps[i]); err != nil {
 			return err
 		}
 	}
@@ -156,24 +302,79 @@ func (s *PostgresStateStore) SaveExecution(ctx context.Context, tx db.Tx, exec *
 	return nil
 }
 
+func (s *PostgresStateStore) SaveStep(ctx context.Context, tx db.Tx, sagaID string, step *StepExecution) error {
+	inputJSON, _ := json.Marshal(step.Input)
+	outputJSON, _ := json.Marshal(step.Output)
+	policyJSON, err := json.Marshal(step.RetryPolicy)
+	if err != nil {
+		return err
+	}
+	conditionJSON, err := json.Marshal(step.Condition)
+	if err != nil {
+		return err
+	}
+	branchJSON, err := json.Marshal(step.Branch)
+	if err != nil {
+		return err
+	}
+	waitJSON, err := json.Marshal(step.Wait)
+	if err != nil {
+		return err
+	}
+	subSagaJSON, err := json.Marshal(step.SubSaga)
+	if err != nil {
+		return err
+	}
+
+	return tx.Exec(ctx, `
+		INSERT INTO saga_step_executions (saga_id, step_index, step_id, state, service, command, compensate, input, output, error_message, attempts, compensation_attempts, group_id, join_policy, condition, branch, retry_policy, wait, wait_key, sub_saga, child_saga_id, next_attempt_at, timeout_ms, deadline_at, started_at, completed_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, NULLIF($21, ''), $22, $23, $24, $25, $26)
+		ON CONFLICT (saga_id, step_index) DO UPDATE SET
+			state = EXCLUDED.state,
+			input = EXCLUDED.input,
+			output = EXCLUDED.output,
+			error_message = EXCLUDED.error_message,
+			attempts = EXCLUDED.attempts,
+			compensation_attempts = EXCLUDED.compensation_attempts,
+			retry_policy = EXCLUDED.retry_policy,
+			wait_key = EXCLUDED.wait_key,
+			child_saga_id = EXCLUDED.child_saga_id,
+			next_attempt_at = EXCLUDED.next_attempt_at,
+			timeout_ms = EXCLUDED.timeout_ms,
+			deadline_at = EXCLUDED.deadline_at,
+			started_at = EXCLUDED.started_at,
+			completed_at = EXCLUDED.completed_at
+	`, sagaID, step.StepIndex, step.StepID, step.State, step.Service, step.Command, step.Compensate, inputJSON, outputJSON, step.ErrorMessage, step.Attempts, step.CompensationAttempts, step.GroupID, step.Join, conditionJSON, branchJSON, policyJSON, waitJSON, step.WaitKey, subSagaJSON, step.ChildSagaID, step.NextAttemptAt, step.Timeout.Milliseconds(), step.DeadlineAt, step.StartedAt, step.CompletedAt)
+}
+
 func (s *PostgresStateStore) GetExecution(ctx context.Context, tx db.Tx, sagaID string) (*SagaExecution, error) {
+	return s.loadExecution(ctx, tx, sagaID, "")
+}
+
+func (s *PostgresStateStore) LockExecution(ctx context.Context, tx db.Tx, sagaID string) (*SagaExecution, error) {
+	return s.loadExecution(ctx, tx, sagaID, "FOR UPDATE")
+}
+
+func (s *PostgresStateStore) loadExecution(ctx context.Context, tx db.Tx, sagaID, lock string) (*SagaExecution, error) {
 	rows, err := tx.Query(ctx, `
-		SELECT saga_id, saga_name, state, current_step, context, error_message, created_at, updated_at
+		SELECT saga_id, saga_name, COALESCE(business_key, ''), definition_version, state, current_step, context, error_message, deadline_at, parent, created_at, updated_at
 		FROM saga_executions
 		WHERE saga_id = $1
-	`, sagaID)
+	`+lock, sagaID)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
 
 	if !rows.Next() {
-		return nil, fmt.Errorf("saga not found: %s", sagaID)
+		rows.Close()
+		return nil, fmt.Errorf("%w: %s", ErrSagaNotFound, sagaID)
 	}
 
 	var exec SagaExecution
-	var contextJSON []byte
-	err = rows.Scan(&exec.SagaID, &exec.SagaName, &exec.State, &exec.CurrentStep, &contextJSON, &exec.ErrorMessage, &exec.CreatedAt, &exec.UpdatedAt)
+	var contextJSON, parentJSON []byte
+	err = rows.Scan(&exec.SagaID, &exec.SagaName, &exec.BusinessKey, &exec.DefinitionVersion, &exec.State, &exec.CurrentStep, &contextJSON, &exec.ErrorMessage, &exec.DeadlineAt, &parentJSON, &exec.CreatedAt, &exec.UpdatedAt)
+	// Release the connection before loading the steps
+	rows.Close()
 	if err != nil {
 		return nil, err
 	}
@@ -181,10 +382,16 @@ func (s *PostgresStateStore) GetExecution(ctx context.Context, tx db.Tx, sagaID
 	if len(contextJSON) > 0 {
 		json.Unmarshal(contextJSON, &exec.Context)
 	}
+	if exec.Context == nil {
+		exec.Context = map[string]interface{}{}
+	}
+	if len(parentJSON) > 0 {
+		json.Unmarshal(parentJSON, &exec.Parent)
+	}
 
 	// Load steps
 	stepRows, err := tx.Query(ctx, `
-		SELECT step_id, step_index, state, service, command, input, output, error_message, attempts, started_at, completed_at
+		SELECT step_id, step_index, state, service, command, compensate, input, output, error_message, attempts, compensation_attempts, COALESCE(group_id, ''), COALESCE(join_policy, ''), condition, branch, retry_policy, wait, COALESCE(wait_key, ''), sub_saga, COALESCE(child_saga_id, ''), next_attempt_at, timeout_ms, deadline_at, started_at, completed_at
 		FROM saga_step_executions
 		WHERE saga_id = $1
 		ORDER BY step_index
@@ -196,8 +403,9 @@ func (s *PostgresStateStore) GetExecution(ctx context.Context, tx db.Tx, sagaID
 
 	for stepRows.Next() {
 		var step StepExecution
-		var inputJSON, outputJSON []byte
-		err = stepRows.Scan(&step.StepID, &step.StepIndex, &step.State, &step.Service, &step.Command, &inputJSON, &outputJSON, &step.ErrorMessage, &step.Attempts, &step.StartedAt, &step.CompletedAt)
+		var inputJSON, outputJSON, conditionJSON, branchJSON, policyJSON, waitJSON, subSagaJSON []byte
+		var timeoutMs int64
+		err = stepRows.Scan(&step.StepID, &step.StepIndex, &step.State, &step.Service, &step.Command, &step.Compensate, &inputJSON, &outputJSON, &step.ErrorMessage, &step.Attempts, &step.CompensationAttempts, &step.GroupID, &step.Join, &conditionJSON, &branchJSON, &policyJSON, &waitJSON, &step.WaitKey, &subSagaJSON, &step.ChildSagaID, &step.NextAttemptAt, &timeoutMs, &step.DeadlineAt, &step.StartedAt, &step.CompletedAt)
 		if err != nil {
 			return nil, err
 		}
@@ -208,6 +416,22 @@ func (s *PostgresStateStore) GetExecution(ctx context.Context, tx db.Tx, sagaID
 		if len(outputJSON) > 0 {
 			json.Unmarshal(outputJSON, &step.Output)
 		}
+		if len(conditionJSON) > 0 {
+			json.Unmarshal(conditionJSON, &step.Condition)
+		}
+		if len(branchJSON) > 0 {
+			json.Unmarshal(branchJSON, &step.Branch)
+		}
+		if len(policyJSON) > 0 {
+			json.Unmarshal(policyJSON, &step.RetryPolicy)
+		}
+		if len(waitJSON) > 0 {
+			json.Unmarshal(waitJSON, &step.Wait)
+		}
+		if len(subSagaJSON) > 0 {
+			json.Unmarshal(subSagaJSON, &step.SubSaga)
+		}
+		step.Timeout = time.Duration(timeoutMs) * time.Millisecond
 
 		exec.Steps = append(exec.Steps, step)
 	}
@@ -226,7 +450,7 @@ func (s *PostgresStateStore) UpdateStepState(ctx context.Context, tx db.Tx, saga
 
 	return tx.Exec(ctx, `
 		UPDATE saga_step_executions
-		SET state = $1, output = $2, error_message = $3, completed_at = $4, attempts = attempts + 1
+		SET state = $1, output = $2, error_message = $3, completed_at = $4, next_attempt_at = NULL, deadline_at = NULL
 		WHERE saga_id = $5 AND step_index = $6
 	`, state, outputJSON, errMsg, completedAt, sagaID, stepIndex)
 }
@@ -238,3 +462,295 @@ func (s *PostgresStateStore) UpdateSagaState(ctx context.Context, tx db.Tx, saga
 		WHERE saga_id = $4
 	`, state, currentStep, errMsg, sagaID)
 }
+
+func (s *PostgresStateStore) UpdateSagaContext(ctx context.Context, tx db.Tx, sagaID string, sagaContext map[string]interface{}) error {
+	contextJSON, err := json.Marshal(sagaContext)
+	if err != nil {
+		return err
+	}
+
+	return tx.Exec(ctx, `
+		UPDATE saga_executions
+		SET context = $1, updated_at = now()
+		WHERE saga_id = $2
+	`, contextJSON, sagaID)
+}
+
+func (s *PostgresStateStore) ClaimExpiredSteps(ctx context.Context, tx db.Tx, now time.Time, limit int) ([]StepRef, error) {
+	rows, err := tx.Query(ctx, `
+		SELECT s.saga_id, s.step_index
+		FROM saga_step_executions s
+		JOIN saga_executions e ON e.saga_id = s.saga_id
+		WHERE s.state IN ($1, $2) AND s.deadline_at < $3
+		ORDER BY s.deadline_at
+		LIMIT $4
+		FOR UPDATE OF e SKIP LOCKED
+	`, StepInProgress, StepCompensating, now, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var refs []StepRef
+	for rows.Next() {
+		var ref StepRef
+		if err := rows.Scan(&ref.SagaID, &ref.StepIndex); err != nil {
+			return nil, err
+		}
+		refs = append(refs, ref)
+	}
+	return refs, nil
+}
+
+func (s *PostgresStateStore) ClaimExpiredSagas(ctx context.Context, tx db.Tx, now time.Time, limit int) ([]string, error) {
+	rows, err := tx.Query(ctx, `
+		SELECT saga_id
+		FROM saga_executions
+		WHERE state = $1 AND deadline_at < $2
+		ORDER BY deadline_at
+		LIMIT $3
+		FOR UPDATE SKIP LOCKED
+	`, StateInProgress, now, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+func (s *PostgresStateStore) CountStuckSagas(ctx context.Context, tx db.Tx, now time.Time) (int, error) {
+	rows, err := tx.Query(ctx, `
+		SELECT COUNT(*)
+		FROM saga_executions e
+		WHERE (e.state = $1 AND e.deadline_at < $2)
+		   OR (e.state IN ($1, $3) AND EXISTS (
+			SELECT 1 FROM saga_step_executions s
+			WHERE s.saga_id = e.saga_id AND s.state IN ($4, $5) AND s.deadline_at < $2
+		  ))
+	`, StateInProgress, now, StateCompensating, StepInProgress, StepCompensating)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	var count int
+	if rows.Next() {
+		if err := rows.Scan(&count); err != nil {
+			return 0, err
+		}
+	}
+	return count, nil
+}
+
+func (s *PostgresStateStore) ListExecutions(ctx context.Context, tx db.Tx, filter ExecutionFilter) ([]SagaExecution, error) {
+	query := `
+		SELECT saga_id, saga_name, COALESCE(business_key, ''), definition_version, state, current_step, context, error_message, deadline_at, parent, created_at, updated_at
+		FROM saga_executions
+		WHERE 1 = 1`
+	var args []any
+	arg := func(v any) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if len(filter.States) > 0 {
+		states := make([]string, len(filter.States))
+		for i, st := range filter.States {
+			states[i] = string(st)
+		}
+		query += " AND state = ANY(" + arg(states) + ")"
+	}
+	if filter.Name != "" {
+		query += " AND saga_name = " + arg(filter.Name)
+	}
+	if filter.BusinessKey != "" {
+		query += " AND business_key = " + arg(filter.BusinessKey)
+	}
+	if !filter.CreatedFrom.IsZero() {
+		query += " AND created_at >= " + arg(filter.CreatedFrom)
+	}
+	if !filter.CreatedTo.IsZero() {
+		query += " AND created_at < " + arg(filter.CreatedTo)
+	}
+
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = 50
+	}
+	query += " ORDER BY created_at DESC LIMIT " + arg(limit) + " OFFSET " + arg(filter.Offset)
+
+	rows, err := tx.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var execs []SagaExecution
+	for rows.Next() {
+		var exec SagaExecution
+		var contextJSON, parentJSON []byte
+		err := rows.Scan(&exec.SagaID, &exec.SagaName, &exec.BusinessKey, &exec.DefinitionVersion, &exec.State, &exec.CurrentStep, &contextJSON, &exec.ErrorMessage, &exec.DeadlineAt, &parentJSON, &exec.CreatedAt, &exec.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		if len(contextJSON) > 0 {
+			json.Unmarshal(contextJSON, &exec.Context)
+		}
+		if len(parentJSON) > 0 {
+			json.Unmarshal(parentJSON, &exec.Parent)
+		}
+		execs = append(execs, exec)
+	}
+	return execs, nil
+}
+
+func (s *PostgresStateStore) RecordAdminAction(ctx context.Context, tx db.Tx, action *AdminAction) error {
+	payloadJSON, err := json.Marshal(action.Payload)
+	if err != nil {
+		return err
+	}
+
+	return tx.Exec(ctx, `
+		INSERT INTO saga_admin_actions (id, saga_id, actor, action, step_index, reason, payload, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	`, action.ID, action.SagaID, action.Actor, action.Action, action.StepIndex, action.Reason, payloadJSON, action.CreatedAt)
+}
+
+func (s *PostgresStateStore) ListAdminActions(ctx context.Context, tx db.Tx, sagaID string) ([]AdminAction, error) {
+	rows, err := tx.Query(ctx, `
+		SELECT id, saga_id, actor, action, step_index, COALESCE(reason, ''), payload, created_at
+		FROM saga_admin_actions
+		WHERE saga_id = $1
+		ORDER BY created_at
+	`, sagaID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var actions []AdminAction
+	for rows.Next() {
+		var action AdminAction
+		var payloadJSON []byte
+		err := rows.Scan(&action.ID, &action.SagaID, &action.Actor, &action.Action, &action.StepIndex, &action.Reason, &payloadJSON, &action.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		if len(payloadJSON) > 0 {
+			json.Unmarshal(payloadJSON, &action.Payload)
+		}
+		actions = append(actions, action)
+	}
+	return actions, nil
+}
+
+func (s *PostgresStateStore) AppendEvent(ctx context.Context, tx db.Tx, event *SagaEvent) error {
+	var payloadJSON []byte
+	if event.Payload != nil {
+		var err error
+		if payloadJSON, err = json.Marshal(event.Payload); err != nil {
+			return err
+		}
+	}
+
+	return tx.Exec(ctx, `
+		INSERT INTO saga_events (saga_id, event_type, step_index, step_id, service, from_state, to_state, attempt, payload, error, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+	`, event.SagaID, event.Type, event.StepIndex, event.StepID, event.Service, event.FromState, event.ToState, event.Attempt, payloadJSON, event.Error, event.CreatedAt)
+}
+
+func (s *PostgresStateStore) ListEvents(ctx context.Context, tx db.Tx, sagaID string) ([]SagaEvent, error) {
+	rows, err := tx.Query(ctx, `
+		SELECT id, saga_id, event_type, step_index, COALESCE(step_id, ''), COALESCE(service, ''), COALESCE(from_state, ''), COALESCE(to_state, ''), attempt, payload, COALESCE(error, ''), created_at
+		FROM saga_events
+		WHERE saga_id = $1
+		ORDER BY id
+	`, sagaID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []SagaEvent
+	for rows.Next() {
+		var event SagaEvent
+		var payloadJSON []byte
+		err := rows.Scan(&event.ID, &event.SagaID, &event.Type, &event.StepIndex, &event.StepID, &event.Service, &event.FromState, &event.ToState, &event.Attempt, &payloadJSON, &event.Error, &event.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		if len(payloadJSON) > 0 {
+			json.Unmarshal(payloadJSON, &event.Payload)
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+func (s *PostgresStateStore) ClaimWaitingSteps(ctx context.Context, tx db.Tx, event string, payload map[string]interface{}) ([]StepRef, error) {
+	payloadJSON, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.Query(ctx, `
+		SELECT s.saga_id, s.step_index
+		FROM saga_step_executions s
+		JOIN saga_executions e ON e.saga_id = s.saga_id
+		WHERE s.state = $1
+		  AND s.wait ->> 'event' = $2
+		  AND (COALESCE(s.wait ->> 'event_key', '') = ''
+		       OR s.wait_key = COALESCE($3::jsonb ->> (s.wait ->> 'event_key'),
+		                                $3::jsonb -> 'payload' ->> (s.wait ->> 'event_key')))
+		ORDER BY s.started_at
+		FOR UPDATE OF e
+	`, StepInProgress, event, payloadJSON)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var refs []StepRef
+	for rows.Next() {
+		var ref StepRef
+		if err := rows.Scan(&ref.SagaID, &ref.StepIndex); err != nil {
+			return nil, err
+		}
+		refs = append(refs, ref)
+	}
+	return refs, nil
+}
+
+func (s *PostgresStateStore) FindActiveByBusinessKey(ctx context.Context, tx db.Tx, key string) (string, error) {
+	if err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock(hashtext($1))`, "saga:"+key); err != nil {
+		return "", err
+	}
+
+	rows, err := tx.Query(ctx, `
+		SELECT saga_id
+		FROM saga_executions
+		WHERE business_key = $1 AND state IN ($2, $3, $4)
+		LIMIT 1
+	`, key, StateCreated, StateInProgress, StateCompensating)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	var sagaID string
+	if rows.Next() {
+		if err := rows.Scan(&sagaID); err != nil {
+			return "", err
+		}
+	}
+	return sagaID, nil
+}
@@ -0,0 +1,183 @@
+package sagaflow
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+
+	"shared/sagakit"
+)
+
+// ErrClassSubSagaFailed is the error class of sub-saga steps whose child
+// saga did not complete
+const ErrClassSubSagaFailed = "sub_saga_failed"
+
+// SubSagaSpec names the definition a sub-saga step starts. Version 0 starts
+// the latest version.
+type SubSagaSpec struct {
+	Name    string `bson:"name" json:"name"`
+	Version int    `bson:"version,omitempty" json:"version,omitempty"`
+}
+
+// ParentRef links a child saga to the parent step that started it. Attempt
+// is the attempt of the parent step; CompensationAttempt is set once the
+// parent compensates the step.
+type ParentRef struct {
+	SagaID              string `bson:"saga_id" json:"saga_id"`
+	StepIndex           int    `bson:"step_index" json:"step_index"`
+	Attempt             int    `bson:"attempt" json:"attempt"`
+	CompensationAttempt int    `bson:"compensation_attempt,omitempty" json:"compensation_attempt,omitempty"`
+}
+
+// startChild starts the child saga of a sub-saga step in the same
+// transaction. The step input is the child's initial context. A definition
+// that cannot be resolved fails the step without retrying.
+func (o *Orchestrator) startChild(ctx context.Context, uow sagakit.UnitOfWork, exec *SagaExecution, stepIndex int, from StepState) error {
+	step := &exec.Steps[stepIndex]
+	def, err := o.subSagaDefinition(ctx, *step.SubSaga)
+	if err != nil {
+		return o.abortStep(ctx, uow, exec, stepIndex, err.Error())
+	}
+
+	child := newExecution(def, maps.Clone(step.Input))
+	child.Parent = &ParentRef{SagaID: exec.SagaID, StepIndex: stepIndex, Attempt: step.Attempts}
+	if err := o.StateStore.SaveExecution(ctx, uow.Tx(), child); err != nil {
+		return err
+	}
+
+	step.ChildSagaID = child.SagaID
+	if err := o.StateStore.SaveStep(ctx, uow.Tx(), exec.SagaID, step); err != nil {
+		return err
+	}
+	payload := map[string]interface{}{"child_saga_id": child.SagaID, "saga_name": def.Name, "version": def.Version}
+	if err := o.recordStepEvent(ctx, uow, exec, stepIndex, EventSubSagaStarted, from, step.Attempts, payload, step.ErrorMessage); err != nil {
+		return err
+	}
+
+	return o.advanceTo(ctx, uow, child, 0, nil)
+}
+
+func (o *Orchestrator) subSagaDefinition(ctx context.Context, spec SubSagaSpec) (Saga, error) {
+	if o.Definitions == nil {
+		return Saga{}, errors.New("orchestrator has no definition store")
+	}
+	if spec.Version == 0 {
+		return o.Definitions.Latest(ctx, spec.Name)
+	}
+	return o.Definitions.Get(ctx, spec.Name, spec.Version)
+}
+
+// compensateChild compensates the child saga of a sub-saga step. A
+// completed child runs its own compensation chain and a FAILED one resumes
+// it; the parent step is settled when the child reports back.
+func (o *Orchestrator) compensateChild(ctx context.Context, uow sagakit.UnitOfWork, exec *SagaExecution, stepIndex int) error {
+	step := &exec.Steps[stepIndex]
+	if step.ChildSagaID == "" {
+		return o.setStepState(ctx, uow, exec, stepIndex, StepCompensated, "")
+	}
+
+	child, err := o.StateStore.LockExecution(ctx, uow.Tx(), step.ChildSagaID)
+	if err != nil {
+		return err
+	}
+
+	step.CompensationAttempts++
+	from := step.State
+	step.State = StepCompensating
+	step.NextAttemptAt = nil
+	step.DeadlineAt = nil
+	if err := o.StateStore.SaveStep(ctx, uow.Tx(), exec.SagaID, step); err != nil {
+		return err
+	}
+	payload := map[string]interface{}{"child_saga_id": child.SagaID}
+	if err := o.recordStepEvent(ctx, uow, exec, stepIndex, EventCompensationSent, from, step.CompensationAttempts, payload, step.ErrorMessage); err != nil {
+		return err
+	}
+
+	if child.Parent == nil {
+		child.Parent = &ParentRef{SagaID: exec.SagaID, StepIndex: stepIndex, Attempt: step.Attempts}
+	}
+	child.Parent.CompensationAttempt = step.CompensationAttempts
+	if err := o.StateStore.SaveExecution(ctx, uow.Tx(), child); err != nil {
+		return err
+	}
+
+	switch child.State {
+	case StateCompleted:
+		return o.startCompensating(ctx, uow, child, fmt.Sprintf("parent saga %s compensating", exec.SagaID))
+	case StateFailed:
+		return o.resumeCompensation(ctx, uow, child)
+	case StateCompensated, StateAborted:
+		return o.notifyParent(uow, child)
+	}
+	// Still compensating; the child reports back when it is done
+	return nil
+}
+
+// cancelChild compensates the running child of a sub-saga step that the
+// parent gives up on. The child's late reply is then dropped as stale.
+func (o *Orchestrator) cancelChild(ctx context.Context, uow sagakit.UnitOfWork, step *StepExecution, errMsg string) error {
+	if step.SubSaga == nil || step.ChildSagaID == "" || step.State != StepInProgress {
+		return nil
+	}
+
+	child, err := o.StateStore.LockExecution(ctx, uow.Tx(), step.ChildSagaID)
+	if err != nil {
+		return err
+	}
+	if child.State != StateInProgress {
+		return nil
+	}
+	if err := o.failInFlight(ctx, uow, child, errMsg); err != nil {
+		return err
+	}
+	return o.startCompensating(ctx, uow, child, errMsg)
+}
+
+// notifyParent reports the final state of a child saga to its parent step
+// as a participant reply, so the parent handles it like any other step
+func (o *Orchestrator) notifyParent(uow sagakit.UnitOfWork, exec *SagaExecution) error {
+	parent := exec.Parent
+	if parent == nil {
+		return nil
+	}
+	switch exec.State {
+	case StateCompleted, StateCompensated, StateFailed, StateAborted:
+	default:
+		return nil
+	}
+
+	msg := map[string]interface{}{
+		"saga_id":       parent.SagaID,
+		"step_index":    parent.StepIndex,
+		"child_saga_id": exec.SagaID,
+	}
+	metadata := map[string]string{
+		"saga_id":    parent.SagaID,
+		"step_index": fmt.Sprintf("%d", parent.StepIndex),
+	}
+	errMsg := fmt.Sprintf("sub-saga %s ended %s", exec.SagaID, exec.State)
+	if exec.ErrorMessage != "" {
+		errMsg += ": " + exec.ErrorMessage
+	}
+
+	if parent.CompensationAttempt > 0 {
+		msg["attempt"] = parent.CompensationAttempt
+		if exec.State == StateCompensated {
+			return uow.Publish(TopicCompensationSuccess, msg, metadata)
+		}
+		msg["error"] = errMsg
+		msg["error_class"] = ErrClassSubSagaFailed
+		return uow.Publish(TopicCompensationFailure, msg, metadata)
+	}
+
+	msg["attempt"] = parent.Attempt
+	if exec.State == StateCompleted {
+		msg["output"] = exec.Context
+		return uow.Publish(TopicStepSuccess, msg, metadata)
+	}
+	msg["error"] = errMsg
+	msg["error_class"] = ErrClassSubSagaFailed
+	return uow.Publish(TopicStepFailure, msg, metadata)
+}
@@ -0,0 +1,119 @@
+package sagaflow
+
+import (
+	"context"
+	"testing"
+
+	"shared/sagakit"
+)
+
+func TestNotifyParent(t *testing.T) {
+	tests := []struct {
+		name        string
+		state       SagaState
+		parent      *ParentRef
+		wantTopic   string
+		wantAttempt float64
+	}{
+		{name: "completed", state: StateCompleted, parent: &ParentRef{SagaID: "parent", StepIndex: 2, Attempt: 1}, wantTopic: TopicStepSuccess, wantAttempt: 1},
+		{name: "compensated by itself", state: StateCompensated, parent: &ParentRef{SagaID: "parent", StepIndex: 2, Attempt: 3}, wantTopic: TopicStepFailure, wantAttempt: 3},
+		{name: "aborted", state: StateAborted, parent: &ParentRef{SagaID: "parent", StepIndex: 2, Attempt: 1}, wantTopic: TopicStepFailure, wantAttempt: 1},
+		{name: "compensated for the parent", state: StateCompensated, parent: &ParentRef{SagaID: "parent", StepIndex: 2, Attempt: 1, CompensationAttempt: 2}, wantTopic: TopicCompensationSuccess, wantAttempt: 2},
+		{name: "failed to compensate for the parent", state: StateFailed, parent: &ParentRef{SagaID: "parent", StepIndex: 2, Attempt: 1, CompensationAttempt: 1}, wantTopic: TopicCompensationFailure, wantAttempt: 1},
+		{name: "still running", state: StateCompensating, parent: &ParentRef{SagaID: "parent", StepIndex: 2, Attempt: 1}},
+		{name: "top-level saga", state: StateCompleted},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o, database := newMemOrchestrator()
+			child := execution(tt.state, StepCompleted)
+			child.SagaID = "child"
+			child.Parent = tt.parent
+			child.Context = map[string]interface{}{"tracking": "T-1"}
+			child.ErrorMessage = "carrier down"
+
+			err := o.runInTx(context.Background(), func(uow sagakit.UnitOfWork) error {
+				return o.notifyParent(uow, child)
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.wantTopic == "" {
+				if len(database.state.messages) != 0 {
+					t.Errorf("published %+v, want nothing", database.state.messages)
+				}
+				return
+			}
+			msgs := database.published(tt.wantTopic)
+			if len(msgs) != 1 || len(database.state.messages) != 1 {
+				t.Fatalf("published %+v, want one message on %s", database.state.messages, tt.wantTopic)
+			}
+			msg := msgs[0]
+			if msg.Payload["saga_id"] != "parent" || msg.Payload["step_index"] != float64(2) || msg.Payload["child_saga_id"] != "child" || msg.Payload["attempt"] != tt.wantAttempt {
+				t.Errorf("reply = %+v", msg.Payload)
+			}
+			if msg.Metadata["saga_id"] != "parent" || msg.Metadata["step_index"] != "2" {
+				t.Errorf("reply metadata = %v", msg.Metadata)
+			}
+			switch tt.wantTopic {
+			case TopicStepSuccess:
+				if output, _ := msg.Payload["output"].(map[string]interface{}); output["tracking"] != "T-1" {
+					t.Errorf("reply output = %v, want the child context", msg.Payload["output"])
+				}
+			case TopicStepFailure, TopicCompensationFailure:
+				if msg.Payload["error_class"] != ErrClassSubSagaFailed || msg.Payload["error"] != "sub-saga child ended "+string(tt.state)+": carrier down" {
+					t.Errorf("reply error = %v (%v)", msg.Payload["error"], msg.Payload["error_class"])
+				}
+			}
+		})
+	}
+}
+
+func TestSubSagaReportsToParent(t *testing.T) {
+	ctx := context.Background()
+	o, database := newMemOrchestrator()
+	defs := NewMemoryDefinitions()
+	if err := defs.Register(Saga{Name: "shipping", Version: 1, Steps: []Step{
+		{ID: "book", Service: "svc", Command: "book", Compensate: "cancel"},
+	}}); err != nil {
+		t.Fatal(err)
+	}
+	o.Definitions = defs
+
+	parentID, err := o.StartSaga(ctx, Saga{Name: "order", Version: 1, Steps: []Step{
+		{ID: "ship", SubSaga: &SubSagaSpec{Name: "shipping"}},
+	}}, map[string]interface{}{"order_id": "o-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parent := loadExecution(t, o, parentID)
+	childID := parent.Steps[0].ChildSagaID
+	if parent.Steps[0].State != StepInProgress || childID == "" {
+		t.Fatalf("sub-saga step = %s with child %q, want a running child", parent.Steps[0].State, childID)
+	}
+	child := loadExecution(t, o, childID)
+	if child.Parent == nil || child.Parent.SagaID != parentID || child.Context["order_id"] != "o-1" {
+		t.Fatalf("child = %+v, want it linked to the parent with the step input", child)
+	}
+
+	if err := o.HandleStepSuccess(ctx, childID, 0, map[string]interface{}{"booking": "b-1"}); err != nil {
+		t.Fatal(err)
+	}
+	replies := database.published(TopicStepSuccess)
+	if len(replies) != 1 || replies[0].Payload["saga_id"] != parentID {
+		t.Fatalf("replies = %+v, want the parent step completed", replies)
+	}
+
+	// The orchestrator consumes its own reply like a participant's
+	output := replies[0].Payload["output"].(map[string]interface{})
+	if err := o.HandleStepSuccess(ctx, parentID, 0, output); err != nil {
+		t.Fatal(err)
+	}
+	parent = loadExecution(t, o, parentID)
+	if parent.State != StateCompleted || parent.Context["booking"] != "b-1" {
+		t.Errorf("parent = %s with context %v, want COMPLETED with the child's output", parent.State, parent.Context)
+	}
+}
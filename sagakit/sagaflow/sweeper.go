@@ -0,0 +1,171 @@
+package sagaflow
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"shared/sagakit"
+
+	"github.com/ThreeDotsLabs/watermill"
+)
+
+// Sweeper periodically fails steps and sagas whose deadline has expired,
+// so a participant that never replies cannot wedge a saga in IN_PROGRESS
+// or COMPENSATING. Expired rows are claimed with FOR UPDATE SKIP LOCKED,
+// which makes it safe to run a Sweeper on every orchestrator instance.
+type Sweeper struct {
+	Orchestrator *Orchestrator
+	Interval     time.Duration
+	BatchSize    int
+	Metrics      *SweeperMetrics
+}
+
+// SweeperMetrics counts what the sweeper found and did
+type SweeperMetrics struct {
+	sweeps        atomic.Int64
+	timedOutSteps atomic.Int64
+	timedOutSagas atomic.Int64
+	errors        atomic.Int64
+	stuckSagas    atomic.Int64
+	lastSweepAt   atomic.Int64
+}
+
+// SweeperStats is a point-in-time copy of SweeperMetrics
+type SweeperStats struct {
+	Sweeps        int64     `json:"sweeps"`
+	TimedOutSteps int64     `json:"timed_out_steps"`
+	TimedOutSagas int64     `json:"timed_out_sagas"`
+	Errors        int64     `json:"errors"`
+	StuckSagas    int64     `json:"stuck_sagas"` // as seen by the last sweep
+	LastSweepAt   time.Time `json:"last_sweep_at"`
+}
+
+func (m *SweeperMetrics) Snapshot() SweeperStats {
+	return SweeperStats{
+		Sweeps:        m.sweeps.Load(),
+		TimedOutSteps: m.timedOutSteps.Load(),
+		TimedOutSagas: m.timedOutSagas.Load(),
+		Errors:        m.errors.Load(),
+		StuckSagas:    m.stuckSagas.Load(),
+		LastSweepAt:   time.Unix(0, m.lastSweepAt.Load()),
+	}
+}
+
+func NewSweeper(o *Orchestrator) *Sweeper {
+	return &Sweeper{
+		Orchestrator: o,
+		Interval:     10 * time.Second,
+		BatchSize:    100,
+		Metrics:      &SweeperMetrics{},
+	}
+}
+
+// Start runs the sweeper until ctx is cancelled
+func (s *Sweeper) Start(ctx context.Context) error {
+	if s.Interval <= 0 {
+		s.Interval = 10 * time.Second
+	}
+	if s.Metrics == nil {
+		s.Metrics = &SweeperMetrics{}
+	}
+	log := sagakit.GetLogger()
+
+	for {
+		if err := s.SweepOnce(ctx); err != nil {
+			s.Metrics.errors.Add(1)
+			log.Error("saga sweep failed", err, nil)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(s.Interval):
+		}
+	}
+}
+
+// SweepOnce handles up to BatchSize expired steps and sagas. Each one is
+// claimed and handled in its own transaction.
+func (s *Sweeper) SweepOnce(ctx context.Context) error {
+	if s.BatchSize <= 0 {
+		s.BatchSize = 100
+	}
+	if s.Metrics == nil {
+		s.Metrics = &SweeperMetrics{}
+	}
+	o := s.Orchestrator
+	now := time.Now()
+
+	s.Metrics.sweeps.Add(1)
+	s.Metrics.lastSweepAt.Store(now.UnixNano())
+
+	err := o.runInTx(ctx, func(uow sagakit.UnitOfWork) error {
+		count, err := o.StateStore.CountStuckSagas(ctx, uow.Tx(), now)
+		if err != nil {
+			return err
+		}
+		s.Metrics.stuckSagas.Store(int64(count))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < s.BatchSize; i++ {
+		var sagaID string
+		err := o.runInTx(ctx, func(uow sagakit.UnitOfWork) error {
+			ids, err := o.StateStore.ClaimExpiredSagas(ctx, uow.Tx(), now, 1)
+			if err != nil || len(ids) == 0 {
+				return err
+			}
+			sagaID = ids[0]
+
+			exec, err := o.StateStore.LockExecution(ctx, uow.Tx(), sagaID)
+			if err != nil {
+				return err
+			}
+			return o.expireSaga(ctx, uow, exec)
+		})
+		if err != nil {
+			return err
+		}
+		if sagaID == "" {
+			break
+		}
+		s.Metrics.timedOutSagas.Add(1)
+		sagakit.GetLogger().Info("saga timed out", watermill.LogFields{
+			"saga_id": sagaID,
+		})
+	}
+
+	for i := 0; i < s.BatchSize; i++ {
+		var ref StepRef
+		err := o.runInTx(ctx, func(uow sagakit.UnitOfWork) error {
+			refs, err := o.StateStore.ClaimExpiredSteps(ctx, uow.Tx(), now, 1)
+			if err != nil || len(refs) == 0 {
+				return err
+			}
+			ref = refs[0]
+
+			exec, err := o.StateStore.LockExecution(ctx, uow.Tx(), ref.SagaID)
+			if err != nil {
+				return err
+			}
+			return o.expireStep(ctx, uow, exec, ref.StepIndex)
+		})
+		if err != nil {
+			return err
+		}
+		if ref.SagaID == "" {
+			break
+		}
+		s.Metrics.timedOutSteps.Add(1)
+		sagakit.GetLogger().Info("saga step timed out", watermill.LogFields{
+			"saga_id":    ref.SagaID,
+			"step_index": ref.StepIndex,
+		})
+	}
+
+	return nil
+}
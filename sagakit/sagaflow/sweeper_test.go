@@ -0,0 +1,153 @@
+package sagaflow
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"shared/sagakit"
+)
+
+func TestExpireStep(t *testing.T) {
+	past := time.Now().Add(-time.Minute)
+
+	tests := []struct {
+		name         string
+		exec         *SagaExecution
+		setup        func(exec *SagaExecution)
+		step         int
+		wantSaga     SagaState
+		wantSteps    []StepState
+		wantCommands int
+		wantUndos    int
+	}{
+		{
+			name:      "step without retries compensates the saga",
+			exec:      execution(StateInProgress, StepCompleted, StepInProgress),
+			step:      1,
+			wantSaga:  StateCompensating,
+			wantSteps: []StepState{StepCompensating, StepFailed},
+			wantUndos: 1,
+		},
+		{
+			name: "step with attempts left is sent again",
+			exec: execution(StateInProgress, StepCompleted, StepInProgress),
+			setup: func(exec *SagaExecution) {
+				exec.Steps[1].RetryPolicy = &RetryPolicy{MaxAttempts: 3}
+			},
+			step:         1,
+			wantSaga:     StateInProgress,
+			wantSteps:    []StepState{StepCompleted, StepInProgress},
+			wantCommands: 1,
+		},
+		{
+			name: "timeouts outside the retryable classes are not retried",
+			exec: execution(StateInProgress, StepInProgress),
+			setup: func(exec *SagaExecution) {
+				exec.Steps[0].RetryPolicy = &RetryPolicy{MaxAttempts: 3, RetryableErrors: []string{"unavailable"}}
+			},
+			wantSaga:  StateCompensated,
+			wantSteps: []StepState{StepFailed},
+		},
+		{
+			name: "compensation is sent again",
+			exec: execution(StateCompensating, StepCompensating, StepFailed),
+			setup: func(exec *SagaExecution) {
+				exec.Steps[0].CompensationAttempts = 1
+			},
+			wantSaga:  StateCompensating,
+			wantSteps: []StepState{StepCompensating, StepFailed},
+			wantUndos: 1,
+		},
+		{
+			name:      "group member of a compensating saga fails without retry",
+			exec:      grouped(execution(StateCompensating, StepFailed, StepInProgress), 0, 1),
+			setup:     func(exec *SagaExecution) { exec.Steps[1].RetryPolicy = &RetryPolicy{MaxAttempts: 3} },
+			step:      1,
+			wantSaga:  StateCompensated,
+			wantSteps: []StepState{StepFailed, StepFailed},
+		},
+		{
+			name:      "settled step only loses its deadline",
+			exec:      execution(StateInProgress, StepCompleted, StepInProgress),
+			step:      0,
+			wantSaga:  StateInProgress,
+			wantSteps: []StepState{StepCompleted, StepInProgress},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o, database := newMemOrchestrator()
+			tt.exec.Steps[tt.step].DeadlineAt = &past
+			if tt.setup != nil {
+				tt.setup(tt.exec)
+			}
+			saveExecution(t, o, tt.exec)
+
+			locked(t, o, "saga-1", func(uow sagakit.UnitOfWork, exec *SagaExecution) error {
+				return o.expireStep(context.Background(), uow, exec, tt.step)
+			})
+
+			got := loadExecution(t, o, "saga-1")
+			if got.State != tt.wantSaga {
+				t.Errorf("saga state = %s, want %s", got.State, tt.wantSaga)
+			}
+			for i, want := range tt.wantSteps {
+				if got.Steps[i].State != want {
+					t.Errorf("step %d state = %s, want %s", i, got.Steps[i].State, want)
+				}
+			}
+			if d := got.Steps[tt.step].DeadlineAt; d != nil && !d.After(past) {
+				t.Errorf("step %d keeps its expired deadline", tt.step)
+			}
+			if n := len(database.published(CommandTopic("svc"))); n != tt.wantCommands {
+				t.Errorf("sent %d commands, want %d", n, tt.wantCommands)
+			}
+			if n := len(database.published(CompensateTopic("svc"))); n != tt.wantUndos {
+				t.Errorf("sent %d compensations, want %d", n, tt.wantUndos)
+			}
+		})
+	}
+}
+
+func TestExpireSaga(t *testing.T) {
+	t.Run("in progress", func(t *testing.T) {
+		o, database := newMemOrchestrator()
+		saveExecution(t, o, grouped(execution(StateInProgress, StepCompleted, StepInProgress, StepInProgress), 1, 2))
+
+		locked(t, o, "saga-1", func(uow sagakit.UnitOfWork, exec *SagaExecution) error {
+			return o.expireSaga(context.Background(), uow, exec)
+		})
+
+		got := loadExecution(t, o, "saga-1")
+		if got.State != StateCompensating || !strings.HasPrefix(got.ErrorMessage, ErrClassTimeout) {
+			t.Errorf("saga = %s %q, want %s with a timeout error", got.State, got.ErrorMessage, StateCompensating)
+		}
+		for i, want := range []StepState{StepCompensating, StepFailed, StepFailed} {
+			if got.Steps[i].State != want {
+				t.Errorf("step %d state = %s, want %s", i, got.Steps[i].State, want)
+			}
+		}
+		if undos := database.published(CompensateTopic("svc")); len(undos) != 1 || undos[0].Payload["command"] != "undo-0" {
+			t.Errorf("compensations sent = %+v, want undo-0", undos)
+		}
+	})
+
+	t.Run("already compensating", func(t *testing.T) {
+		o, database := newMemOrchestrator()
+		saveExecution(t, o, execution(StateCompensating, StepCompensating, StepFailed))
+
+		locked(t, o, "saga-1", func(uow sagakit.UnitOfWork, exec *SagaExecution) error {
+			return o.expireSaga(context.Background(), uow, exec)
+		})
+
+		if got := loadExecution(t, o, "saga-1"); got.State != StateCompensating || got.Steps[0].State != StepCompensating {
+			t.Errorf("saga = %s, step 0 = %s; want it left alone", got.State, got.Steps[0].State)
+		}
+		if len(database.state.messages) != 0 {
+			t.Errorf("published %+v, want nothing", database.state.messages)
+		}
+	})
+}
@@ -0,0 +1,25 @@
+package sagaflow
+
+import "fmt"
+
+// Topics the orchestrator listens on for participant replies
+const (
+	TopicStepSuccess         = "saga.event.step.success"
+	TopicStepFailure         = "saga.event.step.failure"
+	TopicCompensationSuccess = "saga.event.compensation.success"
+	TopicCompensationFailure = "saga.event.compensation.failure"
+)
+
+// TopicSagaFailed carries a SagaAlert when a saga ends FAILED because a
+// compensation could not be completed
+const TopicSagaFailed = "saga.event.failed"
+
+// CommandTopic is the topic a service receives step commands on
+func CommandTopic(service string) string {
+	return fmt.Sprintf("saga.command.%s", service)
+}
+
+// CompensateTopic is the topic a service receives compensations on
+func CompensateTopic(service string) string {
+	return fmt.Sprintf("saga.compensate.%s", service)
+}
@@ -1,16 +1,63 @@
-
 package sagaflow
 
+import (
+	"time"
+
+	"shared/pkgs/evaluate"
+)
+
 type Step struct {
-    ID string
-    Service string
-    Command string
-    Payload map[string]any
-    Compensate string
-    MaxRetries int
+	ID         string
+	Service    string
+	Command    string
+	Payload    map[string]any
+	Compensate string
+	// NoCompensate marks steps that need no compensation (pivot or
+	// read-only steps) so that validation accepts an empty Compensate
+	NoCompensate bool
+	MaxRetries   int
+	// Retry overrides MaxRetries with a full retry policy
+	Retry *RetryPolicy
+	// Timeout bounds each attempt; an expired attempt fails with ErrClassTimeout
+	Timeout time.Duration
+	// Parallel turns the step into a group whose members are dispatched
+	// together and joined according to Join (JoinAll by default) before the
+	// saga moves on. A group step only has an ID and Join; everything else
+	// is set on its members.
+	Parallel []Step
+	Join     JoinPolicy
+	// When guards the step; it is evaluated against the saga context when
+	// the saga reaches the step, and a false guard marks the step skipped
+	When *evaluate.ConditionNode
+	// Branch turns the step into a choice between alternative step lists.
+	// Only the first matching case runs; the others are skipped. Cases may
+	// hold plain steps and groups, but not further branches.
+	Branch []BranchCase
+	// WaitFor turns the step into a wait step: nothing is published and the
+	// step completes when the awaited event or Orchestrator.Signal arrives.
+	// Timeout and Retry apply as for any other step.
+	WaitFor *WaitSpec
+	// SubSaga turns the step into a sub-saga step: it starts a child saga
+	// with the step payload as its context and completes with the child's
+	// final context. Compensating the step compensates the child.
+	SubSaga *SubSagaSpec
 }
 type Saga struct {
-    SagaID string
-    Name string
-    Steps []Step
+	SagaID string
+	Name   string
+	// Version identifies the definition; executions pin the version they start with
+	Version int
+	Steps   []Step
+	// Timeout bounds the whole saga; once expired the saga is compensated
+	Timeout time.Duration
+}
+
+// retryPolicy resolves the policy persisted with the step execution.
+// MaxRetries is kept for definitions written before RetryPolicy existed.
+func (s Step) retryPolicy() *RetryPolicy {
+	if s.Retry != nil {
+		p := *s.Retry
+		return &p
+	}
+	return &RetryPolicy{MaxAttempts: s.MaxRetries + 1}
 }
@@ -0,0 +1,237 @@
+package sagaflow
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// ErrSubSagaCycle is returned when registering a definition would let a
+// saga start itself through its sub-sagas, e.g. A → B → A
+var ErrSubSagaCycle = errors.New("sub-saga cycle")
+
+// Validate checks a saga definition before it is registered: a name and
+// version, unique step IDs, a service and command on every step, a
+// compensation on every step not marked NoCompensate, and well-formed
+// groups, branches and wait steps. When knownServices is given, steps may only target
+// those services.
+func (s Saga) Validate(knownServices ...string) error {
+	v := &validator{known: knownServices, seen: map[string]bool{}}
+
+	if s.Name == "" {
+		v.errorf("saga name is required")
+	}
+	if s.Version < 1 {
+		v.errorf("saga %q: version must be 1 or greater", s.Name)
+	}
+	if len(s.Steps) == 0 {
+		v.errorf("saga %q: at least one step is required", s.Name)
+	}
+
+	for _, st := range s.Steps {
+		v.step(st, false, false)
+	}
+
+	return errors.Join(v.errs...)
+}
+
+type validator struct {
+	known []string
+	seen  map[string]bool
+	errs  []error
+}
+
+func (v *validator) errorf(format string, args ...any) {
+	v.errs = append(v.errs, fmt.Errorf(format, args...))
+}
+
+func (v *validator) step(st Step, inGroup, inBranch bool) {
+	if st.ID == "" {
+		v.errorf("step without id")
+	} else if v.seen[st.ID] {
+		v.errorf("step %q: duplicate step id", st.ID)
+	}
+	v.seen[st.ID] = true
+
+	switch {
+	case len(st.Parallel) > 0:
+		if inGroup {
+			v.errorf("step %q: groups cannot be nested", st.ID)
+		}
+		if len(st.Branch) > 0 || st.Service != "" || st.Command != "" {
+			v.errorf("step %q: a group cannot have a branch, service or command", st.ID)
+		}
+		if st.When != nil {
+			v.errorf("step %q: put conditions on group members, not on the group", st.ID)
+		}
+		if st.Payload != nil || st.Compensate != "" || st.NoCompensate || st.MaxRetries != 0 || st.Retry != nil || st.Timeout != 0 {
+			v.errorf("step %q: put payload, compensate, retries and timeout on group members, not on the group", st.ID)
+		}
+		if st.WaitFor != nil || st.SubSaga != nil {
+			v.errorf("step %q: a group cannot wait or start a sub-saga", st.ID)
+		}
+		switch st.Join {
+		case "", JoinAll, JoinAllSettled:
+		default:
+			v.errorf("step %q: unknown join policy %q", st.ID, st.Join)
+		}
+		for _, member := range st.Parallel {
+			v.step(member, true, inBranch)
+		}
+
+	case len(st.Branch) > 0:
+		if inGroup || inBranch {
+			v.errorf("step %q: branches cannot be nested in groups or branches", st.ID)
+		}
+		if st.Service != "" || st.Command != "" || st.When != nil {
+			v.errorf("step %q: a branch cannot have a service, command or condition", st.ID)
+		}
+		for i, bc := range st.Branch {
+			if bc.When == nil && i < len(st.Branch)-1 {
+				v.errorf("step %q: case %d has no condition, so later cases are unreachable", st.ID, i)
+			}
+			for _, cs := range bc.Steps {
+				v.step(cs, false, true)
+			}
+		}
+
+	case st.WaitFor != nil:
+		v.wait(st)
+
+	case st.SubSaga != nil:
+		v.subSaga(st)
+
+	default:
+		v.action(st)
+	}
+}
+
+func (v *validator) wait(st Step) {
+	if st.Service != "" || st.Command != "" || st.Compensate != "" {
+		v.errorf("step %q: a wait step cannot have a service, command or compensate", st.ID)
+	}
+	if st.SubSaga != nil {
+		v.errorf("step %q: a step cannot both wait and start a sub-saga", st.ID)
+	}
+	if st.WaitFor.Event == "" && st.WaitFor.EventKey != "" {
+		v.errorf("step %q: wait_for.event_key needs wait_for.event", st.ID)
+	}
+	if st.Timeout < 0 {
+		v.errorf("step %q: timeout cannot be negative", st.ID)
+	}
+}
+
+func (v *validator) subSaga(st Step) {
+	if st.Service != "" || st.Command != "" || st.Compensate != "" {
+		v.errorf("step %q: a sub-saga step cannot have a service, command or compensate", st.ID)
+	}
+	if st.SubSaga.Name == "" {
+		v.errorf("step %q: sub_saga.name is required", st.ID)
+	}
+	if st.SubSaga.Version < 0 {
+		v.errorf("step %q: sub_saga.version cannot be negative", st.ID)
+	}
+	if st.Timeout < 0 {
+		v.errorf("step %q: timeout cannot be negative", st.ID)
+	}
+}
+
+func (v *validator) action(st Step) {
+	if st.Service == "" {
+		v.errorf("step %q: service is required", st.ID)
+	} else if len(v.known) > 0 && !slices.Contains(v.known, st.Service) {
+		v.errorf("step %q: unknown service %q", st.ID, st.Service)
+	}
+	if st.Command == "" {
+		v.errorf("step %q: command is required", st.ID)
+	}
+	if st.Compensate == "" && !st.NoCompensate {
+		v.errorf("step %q: compensate is required (set no_compensate for steps that need none)", st.ID)
+	}
+	if st.Retry != nil {
+		if st.Retry.MaxAttempts < 0 {
+			v.errorf("step %q: retry.max_attempts cannot be negative", st.ID)
+		}
+		if st.Retry.Jitter < 0 || st.Retry.Jitter > 1 {
+			v.errorf("step %q: retry.jitter must be between 0 and 1", st.ID)
+		}
+	}
+	if st.Timeout < 0 {
+		v.errorf("step %q: timeout cannot be negative", st.ID)
+	}
+}
+
+// definitionLookup returns a registered definition; version 0 asks for the
+// latest one. Missing definitions are reported with ErrDefinitionNotFound.
+type definitionLookup func(name string, version int) (Saga, error)
+
+// checkSubSagaCycles follows the sub-saga steps of def through lookup, as
+// if def were already registered, and reports a path leading back to a
+// saga on it. Sub-sagas that are not registered yet are skipped: they fail
+// when started instead.
+func checkSubSagaCycles(def Saga, lookup definitionLookup) error {
+	resolve := func(spec SubSagaSpec) (Saga, error) {
+		if spec.Name == def.Name && spec.Version == def.Version {
+			return def, nil
+		}
+		s, err := lookup(spec.Name, spec.Version)
+		if spec.Name == def.Name && spec.Version == 0 &&
+			(errors.Is(err, ErrDefinitionNotFound) || err == nil && s.Version < def.Version) {
+			return def, nil
+		}
+		return s, err
+	}
+
+	var path []string
+	onPath := map[string]bool{}
+	done := map[string]bool{}
+
+	var visit func(s Saga) error
+	visit = func(s Saga) error {
+		key := fmt.Sprintf("%s v%d", s.Name, s.Version)
+		if onPath[key] {
+			return fmt.Errorf("%w: %s → %s", ErrSubSagaCycle, strings.Join(path, " → "), key)
+		}
+		if done[key] {
+			return nil
+		}
+		onPath[key] = true
+		path = append(path, key)
+
+		for _, spec := range subSagaSpecs(s.Steps) {
+			child, err := resolve(spec)
+			if errors.Is(err, ErrDefinitionNotFound) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			if err := visit(child); err != nil {
+				return err
+			}
+		}
+
+		path = path[:len(path)-1]
+		onPath[key] = false
+		done[key] = true
+		return nil
+	}
+	return visit(def)
+}
+
+// subSagaSpecs collects the sub-saga steps of steps, including those in
+// groups and branches
+func subSagaSpecs(steps []Step) []SubSagaSpec {
+	var specs []SubSagaSpec
+	for _, st := range steps {
+		if st.SubSaga != nil {
+			specs = append(specs, *st.SubSaga)
+		}
+		specs = append(specs, subSagaSpecs(st.Parallel)...)
+		for _, bc := range st.Branch {
+			specs = append(specs, subSagaSpecs(bc.Steps)...)
+		}
+	}
+	return specs
+}
@@ -0,0 +1,90 @@
+package sagaflow
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSagaValidate(t *testing.T) {
+	saga := func(steps ...Step) Saga {
+		return Saga{Name: "order", Version: 1, Steps: steps}
+	}
+
+	tests := []struct {
+		name    string
+		saga    Saga
+		known   []string
+		wantErr string // substring of the error; empty for a valid saga
+	}{
+		{
+			name: "valid",
+			saga: saga(
+				Step{ID: "reserve", Service: "svc", Command: "reserve", Compensate: "release"},
+				Step{ID: "notify", Join: JoinAllSettled, Parallel: []Step{action("email"), action("sms")}},
+				Step{ID: "route", Branch: []BranchCase{
+					{When: cond("amount", ">", 10), Steps: []Step{action("review")}},
+					{Steps: []Step{action("approve")}},
+				}},
+				Step{ID: "await", WaitFor: &WaitSpec{Event: "paid", EventKey: "order_id"}},
+				Step{ID: "child", SubSaga: &SubSagaSpec{Name: "shipping"}},
+			),
+			known: []string{"svc"},
+		},
+		{name: "missing name", saga: Saga{Version: 1, Steps: []Step{action("a")}}, wantErr: "saga name is required"},
+		{name: "version 0", saga: Saga{Name: "order", Steps: []Step{action("a")}}, wantErr: "version must be 1 or greater"},
+		{name: "no steps", saga: saga(), wantErr: "at least one step is required"},
+		{name: "step without id", saga: saga(Step{Service: "svc", Command: "c", NoCompensate: true}), wantErr: "step without id"},
+		{name: "duplicate id", saga: saga(action("a"), Step{ID: "g", Parallel: []Step{action("a")}}), wantErr: `"a": duplicate step id`},
+		{name: "missing service", saga: saga(Step{ID: "a", Command: "c", NoCompensate: true}), wantErr: "service is required"},
+		{name: "unknown service", saga: saga(action("a")), known: []string{"billing"}, wantErr: `unknown service "svc"`},
+		{name: "missing compensate", saga: saga(Step{ID: "a", Service: "svc", Command: "c"}), wantErr: "compensate is required"},
+		{name: "negative timeout", saga: saga(Step{ID: "a", Service: "svc", Command: "c", NoCompensate: true, Timeout: -time.Second}), wantErr: "timeout cannot be negative"},
+		{name: "jitter above 1", saga: saga(Step{ID: "a", Service: "svc", Command: "c", NoCompensate: true, Retry: &RetryPolicy{Jitter: 2}}), wantErr: "jitter must be between 0 and 1"},
+		{
+			name:    "nested group",
+			saga:    saga(Step{ID: "g", Parallel: []Step{{ID: "inner", Parallel: []Step{action("a")}}}}),
+			wantErr: "groups cannot be nested",
+		},
+		{name: "group with a command", saga: saga(Step{ID: "g", Command: "c", Parallel: []Step{action("a")}}), wantErr: "a group cannot have a branch, service or command"},
+		{name: "condition on a group", saga: saga(Step{ID: "g", When: cond("x", "==", 1), Parallel: []Step{action("a")}}), wantErr: "put conditions on group members"},
+		{name: "retry on a group", saga: saga(Step{ID: "g", Retry: &RetryPolicy{MaxAttempts: 3}, Parallel: []Step{action("a")}}), wantErr: "put payload, compensate, retries and timeout on group members"},
+		{name: "timeout on a group", saga: saga(Step{ID: "g", Timeout: time.Minute, Parallel: []Step{action("a")}}), wantErr: "put payload, compensate, retries and timeout on group members"},
+		{name: "payload on a group", saga: saga(Step{ID: "g", Payload: map[string]any{"x": 1}, Parallel: []Step{action("a")}}), wantErr: "put payload, compensate, retries and timeout on group members"},
+		{name: "compensate on a group", saga: saga(Step{ID: "g", Compensate: "undo", Parallel: []Step{action("a")}}), wantErr: "put payload, compensate, retries and timeout on group members"},
+		{name: "wait on a group", saga: saga(Step{ID: "g", WaitFor: &WaitSpec{Event: "paid"}, Parallel: []Step{action("a")}}), wantErr: "a group cannot wait or start a sub-saga"},
+		{name: "unknown join", saga: saga(Step{ID: "g", Join: "ANY", Parallel: []Step{action("a")}}), wantErr: `unknown join policy "ANY"`},
+		{
+			name: "unreachable case",
+			saga: saga(Step{ID: "b", Branch: []BranchCase{
+				{Steps: []Step{action("a")}},
+				{When: cond("x", "==", 1), Steps: []Step{action("c")}},
+			}}),
+			wantErr: "case 0 has no condition",
+		},
+		{
+			name:    "branch in a group",
+			saga:    saga(Step{ID: "g", Parallel: []Step{{ID: "b", Branch: []BranchCase{{Steps: []Step{action("a")}}}}}}),
+			wantErr: "branches cannot be nested",
+		},
+		{name: "wait with a service", saga: saga(Step{ID: "w", Service: "svc", WaitFor: &WaitSpec{Event: "paid"}}), wantErr: "a wait step cannot have a service"},
+		{name: "event key without event", saga: saga(Step{ID: "w", WaitFor: &WaitSpec{EventKey: "order_id"}}), wantErr: "wait_for.event_key needs wait_for.event"},
+		{name: "sub-saga without name", saga: saga(Step{ID: "s", SubSaga: &SubSagaSpec{}}), wantErr: "sub_saga.name is required"},
+		{name: "sub-saga with a command", saga: saga(Step{ID: "s", Command: "c", SubSaga: &SubSagaSpec{Name: "x"}}), wantErr: "a sub-saga step cannot have a service"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.saga.Validate(tt.known...)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Validate() = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Validate() = %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
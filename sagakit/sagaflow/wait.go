@@ -0,0 +1,112 @@
+package sagaflow
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"shared/sagakit"
+)
+
+// WaitSpec describes what a wait step waits for. Event names the topic of
+// the awaited event; without it the step only completes through
+// Orchestrator.Signal. EventKey correlates the event with the saga: the
+// event payload field EventKey must equal the saga context value
+// ContextKey (EventKey when empty). The field is looked up at the top of
+// the event and, for kf envelopes, under "payload". Without EventKey the
+// first event on the topic completes every step waiting for it.
+type WaitSpec struct {
+	Event      string `bson:"event,omitempty" json:"event,omitempty"`
+	EventKey   string `bson:"event_key,omitempty" json:"event_key,omitempty"`
+	ContextKey string `bson:"context_key,omitempty" json:"context_key,omitempty"`
+}
+
+// key resolves the correlation value the awaited event must carry. A step
+// correlating on a context value the saga does not have could never match,
+// so that is an error.
+func (w WaitSpec) key(sagaContext map[string]interface{}) (string, error) {
+	if w.EventKey == "" {
+		return "", nil
+	}
+	contextKey := w.ContextKey
+	if contextKey == "" {
+		contextKey = w.EventKey
+	}
+	v, ok := sagaContext[contextKey]
+	if !ok || v == nil {
+		return "", fmt.Errorf("wait for %s: saga context has no %q to correlate on", w.Event, contextKey)
+	}
+	return fmt.Sprint(v), nil
+}
+
+// eventKey returns the correlation value carried by an event, looking at
+// the top level first and then under the payload of a kf envelope
+func (w WaitSpec) eventKey(event map[string]interface{}) (string, bool) {
+	v, ok := event[w.EventKey]
+	if !ok || v == nil {
+		payload, isMap := event["payload"].(map[string]interface{})
+		if !isMap {
+			return "", false
+		}
+		if v, ok = payload[w.EventKey]; !ok || v == nil {
+			return "", false
+		}
+	}
+	return fmt.Sprint(v), true
+}
+
+// Signal completes the wait step stepID of a saga, for instance when a user
+// approves a task. The payload is merged into the saga context. Signals
+// are recorded in the admin audit log like the other operator actions.
+func (o *Orchestrator) Signal(ctx context.Context, actor, sagaID, stepID string, payload map[string]interface{}, reason string) error {
+	exec, err := o.GetExecution(ctx, sagaID)
+	if err != nil {
+		return err
+	}
+	stepIndex := slices.IndexFunc(exec.Steps, func(step StepExecution) bool { return step.StepID == stepID })
+	if stepIndex < 0 {
+		return fmt.Errorf("%w: saga %s has no step %s", ErrStepNotFound, sagaID, stepID)
+	}
+
+	return o.runAdminAction(ctx, actor, sagaID, AdminSignal, &stepIndex, reason, payload, func(uow sagakit.UnitOfWork, exec *SagaExecution) error {
+		step := exec.Steps[stepIndex]
+		if step.Wait == nil || step.State != StepInProgress || exec.State != StateInProgress {
+			return fmt.Errorf("%w: step %s is not waiting", ErrActionNotAllowed, stepID)
+		}
+		return o.completeWait(ctx, uow, exec, stepIndex, payload)
+	})
+}
+
+// HandleWaitEvent completes the wait steps waiting for event whose
+// correlation key matches payload
+func (o *Orchestrator) HandleWaitEvent(ctx context.Context, event string, payload map[string]interface{}) error {
+	return o.runInTx(ctx, func(uow sagakit.UnitOfWork) error {
+		refs, err := o.StateStore.ClaimWaitingSteps(ctx, uow.Tx(), event, payload)
+		if err != nil {
+			return err
+		}
+
+		for _, ref := range refs {
+			exec, err := o.StateStore.LockExecution(ctx, uow.Tx(), ref.SagaID)
+			if err != nil {
+				return err
+			}
+			if exec.State != StateInProgress || exec.Steps[ref.StepIndex].State != StepInProgress {
+				continue // settled while we waited for the lock
+			}
+			if err := o.completeWait(ctx, uow, exec, ref.StepIndex, payload); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// completeWait completes a wait step with payload as its output
+func (o *Orchestrator) completeWait(ctx context.Context, uow sagakit.UnitOfWork, exec *SagaExecution, stepIndex int, payload map[string]interface{}) error {
+	exec.Steps[stepIndex].Output = payload
+	if err := o.setStepState(ctx, uow, exec, stepIndex, StepCompleted, ""); err != nil {
+		return err
+	}
+	return o.proceed(ctx, uow, exec, stepIndex, payload)
+}
@@ -0,0 +1,124 @@
+package sagaflow
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestWaitSpecKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    WaitSpec
+		context map[string]interface{}
+		want    string
+		wantErr bool
+	}{
+		{name: "no correlation", spec: WaitSpec{Event: "paid"}, want: ""},
+		{name: "same name in context", spec: WaitSpec{Event: "paid", EventKey: "order_id"}, context: map[string]interface{}{"order_id": 42}, want: "42"},
+		{name: "context key", spec: WaitSpec{Event: "paid", EventKey: "id", ContextKey: "order_id"}, context: map[string]interface{}{"order_id": "o-1", "id": "x"}, want: "o-1"},
+		{name: "missing in context", spec: WaitSpec{Event: "paid", EventKey: "order_id"}, context: map[string]interface{}{}, wantErr: true},
+		{name: "nil in context", spec: WaitSpec{Event: "paid", EventKey: "order_id"}, context: map[string]interface{}{"order_id": nil}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.spec.key(tt.context)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("key() = %q, %v; want %q, error %v", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestWaitSpecEventKey(t *testing.T) {
+	spec := WaitSpec{Event: "paid", EventKey: "order_id"}
+	tests := []struct {
+		name   string
+		event  map[string]interface{}
+		want   string
+		wantOK bool
+	}{
+		{name: "top level", event: map[string]interface{}{"order_id": float64(42)}, want: "42", wantOK: true},
+		{name: "kf envelope", event: map[string]interface{}{"event_type": "paid", "payload": map[string]interface{}{"order_id": "o-1"}}, want: "o-1", wantOK: true},
+		{name: "top level wins", event: map[string]interface{}{"order_id": "a", "payload": map[string]interface{}{"order_id": "b"}}, want: "a", wantOK: true},
+		{name: "null falls back to the envelope", event: map[string]interface{}{"order_id": nil, "payload": map[string]interface{}{"order_id": "b"}}, want: "b", wantOK: true},
+		{name: "missing", event: map[string]interface{}{"payload": map[string]interface{}{}}, wantOK: false},
+		{name: "payload not an object", event: map[string]interface{}{"payload": "x"}, wantOK: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := spec.eventKey(tt.event)
+			if ok != tt.wantOK || got != tt.want {
+				t.Errorf("eventKey() = %q, %v; want %q, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestWaitStepCompletesOnMatchingEvent(t *testing.T) {
+	ctx := context.Background()
+	o, database := newMemOrchestrator()
+
+	sagaID, err := o.StartSaga(ctx, Saga{Name: "order", Version: 1, Steps: []Step{
+		{ID: "await-payment", WaitFor: &WaitSpec{Event: "payment.received", EventKey: "order_id"}},
+		{ID: "ship", Service: "svc", Command: "ship", NoCompensate: true},
+	}}, map[string]interface{}{"order_id": "o-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := loadExecution(t, o, sagaID); got.Steps[0].State != StepInProgress || got.Steps[0].WaitKey != "o-1" {
+		t.Fatalf("wait step = %s keyed %q, want waiting for o-1", got.Steps[0].State, got.Steps[0].WaitKey)
+	}
+
+	if err := o.HandleWaitEvent(ctx, "payment.received", map[string]interface{}{"payload": map[string]interface{}{"order_id": "o-2"}}); err != nil {
+		t.Fatal(err)
+	}
+	if got := loadExecution(t, o, sagaID); got.Steps[0].State != StepInProgress {
+		t.Fatalf("an event for another order completed the step")
+	}
+
+	if err := o.HandleWaitEvent(ctx, "payment.received", map[string]interface{}{"payload": map[string]interface{}{"order_id": "o-1", "amount": 10}}); err != nil {
+		t.Fatal(err)
+	}
+	got := loadExecution(t, o, sagaID)
+	if got.Steps[0].State != StepCompleted || got.Steps[1].State != StepInProgress {
+		t.Fatalf("steps = %s, %s; want the wait completed and ship sent", got.Steps[0].State, got.Steps[1].State)
+	}
+	if commands := database.published(CommandTopic("svc")); len(commands) != 1 || commands[0].Payload["command"] != "ship" {
+		t.Errorf("commands sent = %+v, want ship", commands)
+	}
+
+	err = o.Signal(ctx, "alice", sagaID, "await-payment", nil, "paid by phone")
+	if !errors.Is(err, ErrActionNotAllowed) {
+		t.Errorf("Signal() on a completed wait = %v, want %v", err, ErrActionNotAllowed)
+	}
+}
+
+func TestSignalCompletesWaitStep(t *testing.T) {
+	ctx := context.Background()
+	o, _ := newMemOrchestrator()
+
+	sagaID, err := o.StartSaga(ctx, Saga{Name: "refund", Version: 1, Steps: []Step{
+		{ID: "approve", WaitFor: &WaitSpec{}},
+	}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := o.Signal(ctx, "alice", sagaID, "missing", nil, ""); !errors.Is(err, ErrStepNotFound) {
+		t.Errorf("Signal() on an unknown step = %v, want %v", err, ErrStepNotFound)
+	}
+	if err := o.Signal(ctx, "alice", sagaID, "approve", map[string]interface{}{"approved_by": "alice"}, "looks fine"); err != nil {
+		t.Fatal(err)
+	}
+
+	got := loadExecution(t, o, sagaID)
+	if got.State != StateCompleted || got.Context["approved_by"] != "alice" {
+		t.Errorf("saga = %s with context %v, want COMPLETED with the signal payload", got.State, got.Context)
+	}
+	if actions, _ := o.AdminActions(ctx, sagaID); len(actions) != 1 || actions[0].Action != AdminSignal {
+		t.Errorf("admin actions = %+v, want one %s", actions, AdminSignal)
+	}
+}
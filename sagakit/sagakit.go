@@ -2,6 +2,7 @@ package sagakit
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"time"
 
@@ -27,12 +28,25 @@ type Config struct {
 	KafkaBrokers []string
 	KafkaUser    string
 	KafkaPass    string
+	// DB and Outbox replace PostgreSQL when set, e.g. mgo.NewDB and
+	// mgo.NewOutbox for services keeping their state in MongoDB
+	DB     db.DB
+	Outbox outbox.Store
 }
 
 // Init bootstraps sagakit (DB + store + Kafka)
 func Init(cfg Config) error {
 	logger = NewStdLogger()
 
+	if cfg.DB != nil {
+		if cfg.Outbox == nil {
+			return errors.New("sagakit: Config.DB needs Config.Outbox")
+		}
+		globalDB = cfg.DB
+		globalStore = cfg.Outbox
+		return initPublisher(cfg)
+	}
+
 	// ---- PostgreSQL ----
 	pool, err := pgxpool.New(context.Background(), cfg.PostgresDSN)
 	if err != nil {
@@ -51,8 +65,11 @@ func Init(cfg Config) error {
 				payload BYTEA NOT NULL,
 				headers JSONB,
 				created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+				next_attempt_at TIMESTAMPTZ,
 				sent_at TIMESTAMPTZ
-			)
+			);
+
+			ALTER TABLE outbox ADD COLUMN IF NOT EXISTS next_attempt_at TIMESTAMPTZ;
 		`)
 	})
 	if err != nil {
@@ -62,6 +79,10 @@ func Init(cfg Config) error {
 	globalDB = dbx
 	globalStore = store
 
+	return initPublisher(cfg)
+}
+
+func initPublisher(cfg Config) error {
 	// ---- Kafka ----
 	pub, err := NewKafkaPublisher(logger, cfg.KafkaBrokers, cfg.KafkaUser, cfg.KafkaPass)
 	if err != nil {
@@ -74,15 +95,21 @@ func Init(cfg Config) error {
 
 // StartDispatcher starts outbox → Kafka background delivery
 func StartDispatcher(ctx context.Context) error {
-	d := &outbox.Dispatcher{
-		DB:     globalDB,
-		Store:  globalStore,
+	return NewDispatcher(globalDB, globalStore).Start(ctx)
+}
+
+// NewDispatcher creates an outbox → Kafka dispatcher for an outbox other
+// than the global one, e.g. a Mongo outbox written by a sagaflow
+// orchestrator; call Start on it to run it
+func NewDispatcher(database db.DB, store outbox.Store) *outbox.Dispatcher {
+	return &outbox.Dispatcher{
+		DB:     database,
+		Store:  store,
 		Pub:    globalPub,
 		Logger: logger,
 		Limit:  100,
 		Delay:  time.Second,
 	}
-	return d.Start(ctx)
 }
 
 // Publish helper (global)
@@ -90,6 +117,17 @@ func Publish(ctx context.Context, topic string, payload any) error {
 	return PublishWithMeta(ctx, topic, payload, nil)
 }
 
+// PublishAt helper (global) schedules a message for delivery at the given time
+func PublishAt(ctx context.Context, topic string, payload any, at time.Time) error {
+	if globalDB == nil || globalStore == nil {
+		return errors.New("sagakit not initialized")
+	}
+
+	return RunInTx(ctx, globalDB, globalStore, func(uow UnitOfWork) error {
+		return uow.PublishAt(topic, payload, nil, at)
+	})
+}
+
 // GetGlobalStore returns the global outbox store
 func GetGlobalStore() outbox.Store {
 	return globalStore
@@ -6,6 +6,7 @@ import (
 	"errors"
 	"shared/sagakit/db"
 	"shared/sagakit/outbox"
+	"time"
 
 	"github.com/ThreeDotsLabs/watermill"
 	"github.com/ThreeDotsLabs/watermill/message"
@@ -14,9 +15,12 @@ import (
 type UnitOfWork interface {
 	Tx() db.Tx
 	Publish(topic string, payload any, metadata map[string]string) error
+	// PublishAt is Publish with delivery held back by the dispatcher until at
+	PublishAt(topic string, payload any, metadata map[string]string, at time.Time) error
 }
 
 type unitOfWork struct {
+	ctx   context.Context
 	tx    db.Tx
 	store outbox.Store
 }
@@ -24,18 +28,39 @@ type unitOfWork struct {
 func (u *unitOfWork) Tx() db.Tx { return u.tx }
 
 func (u *unitOfWork) Publish(topic string, payload any, metadata map[string]string) error {
-	b, err := json.Marshal(payload)
+	msg, err := u.newMessage(payload, metadata)
+	if err != nil {
+		return err
+	}
+
+	return u.store.InsertTx(u.ctx, u.tx, topic, msg)
+}
+
+func (u *unitOfWork) PublishAt(topic string, payload any, metadata map[string]string, at time.Time) error {
+	msg, err := u.newMessage(payload, metadata)
 	if err != nil {
 		return err
 	}
 
+	return u.store.ScheduleTx(u.ctx, u.tx, topic, msg, at)
+}
+
+func (u *unitOfWork) newMessage(payload any, metadata map[string]string) (*message.Message, error) {
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
 	msg := message.NewMessage(watermill.NewUUID(), b)
 
 	for k, v := range metadata {
 		msg.Metadata.Set(k, v)
 	}
 
-	return u.store.InsertTx(context.Background(), u.tx, topic, msg)
+	// Carry trace/request/tenant context over to the dispatched message
+	InjectMetadata(u.ctx, msg.Metadata)
+
+	return msg, nil
 }
 
 func RunInTx(ctx context.Context, database db.DB, store outbox.Store, fn func(uow UnitOfWork) error) error {
@@ -44,7 +69,7 @@ func RunInTx(ctx context.Context, database db.DB, store outbox.Store, fn func(uo
 		return err
 	}
 
-	uow := &unitOfWork{tx: tx, store: store}
+	uow := &unitOfWork{ctx: ctx, tx: tx, store: store}
 	if err := fn(uow); err != nil {
 		_ = tx.Rollback()
 		return err